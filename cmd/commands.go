@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"tpy-blockchain/internal/blockchain"
+	"tpy-blockchain/internal/consensus"
+	"tpy-blockchain/internal/storage"
+	"tpy-blockchain/internal/wallet"
+	"tpy-blockchain/pkg/utils"
+)
+
+// bootstrapSigSuffix names the detached signature of a bootstrap file
+const bootstrapSigSuffix = ".sig"
+
+// runCommand runs a one-shot command instead of the interactive CLI and returns the exit code
+func runCommand(bc *blockchain.Blockchain, cfg *utils.Config, command string, args []string) int {
+	switch command {
+	case "reindex":
+		return handleReindex(bc)
+	case "audit":
+		return handleAudit(bc, args)
+	case "bft-testnet":
+		return handleBFTTestnet(args)
+	case "export":
+		return handleExport(bc, cfg, args)
+	case "import":
+		return handleImport(bc, cfg, args)
+	default:
+		fmt.Printf("Unknown command %q. Available commands: reindex, audit, bft-testnet, export, import\n", command)
+		return 2
+	}
+}
+
+// Handle rebuilding all derived state by replaying the blocks from genesis
+func handleReindex(bc *blockchain.Blockchain) int {
+	fmt.Printf("Reindexing %d blocks from genesis...\n", len(bc.Blocks))
+	if err := bc.Reindex(); err != nil {
+		var replayErr *blockchain.ReplayError
+		if errors.As(err, &replayErr) {
+			fmt.Printf("Reindex failed at block %d: %v\n", replayErr.BlockIndex, replayErr.Err)
+		} else {
+			fmt.Printf("Reindex failed: %v\n", err)
+		}
+		return 1
+	}
+
+	if err := bc.Save(); err != nil {
+		fmt.Printf("Failed to save blockchain: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Reindex complete: state rebuilt from %d blocks, state root %s\n", len(bc.Blocks), bc.State.Root())
+	return 0
+}
+
+// Handle auditing token supply at a height, the tip by default
+func handleAudit(bc *blockchain.Blockchain, args []string) int {
+	height := len(bc.Blocks) - 1
+	if len(args) > 0 {
+		h, err := strconv.Atoi(args[0])
+		if err != nil || h < 0 || h >= len(bc.Blocks) {
+			fmt.Printf("Invalid height %q, the chain has %d blocks\n", args[0], len(bc.Blocks))
+			return 2
+		}
+		height = h
+	}
+
+	report, err := bc.AuditSupply(height)
+	if err != nil {
+		fmt.Printf("Audit failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("\n--- Supply Audit at Block %d (%s) ---\n", report.Height, report.BlockHash)
+	for _, supply := range report.Supplies {
+		symbol := supply.Symbol
+		if symbol == "" {
+			symbol = "native"
+		}
+		fmt.Printf("%s:\n", symbol)
+		fmt.Printf("  Circulating: %s\n", supply.Circulating)
+		fmt.Printf("  Minted:      %s\n", supply.Minted)
+		fmt.Printf("  Burned:      %s\n", supply.Burned)
+		if supply.Slashed != "" {
+			fmt.Printf("  Slashed:     %s\n", supply.Slashed)
+		}
+		if supply.TotalSupply != "" {
+			fmt.Printf("  Total Supply: %s\n", supply.TotalSupply)
+		}
+	}
+
+	if !report.OK() {
+		fmt.Println("\nDiscrepancies:")
+		for _, discrepancy := range report.Discrepancies {
+			fmt.Printf("  - %s\n", discrepancy)
+		}
+		return 1
+	}
+	fmt.Println("\nNo discrepancies found.")
+	return 0
+}
+
+// openBlockchain loads the chain from store. A node importing a bootstrap
+// file into an empty store starts from the file's genesis block instead of
+// creating its own.
+func openBlockchain(cfg *utils.Config, store storage.ChainStore, engine consensus.Engine, args []string) (*blockchain.Blockchain, error) {
+	if len(args) < 2 || args[0] != "import" {
+		return blockchain.OpenBlockchain(store, engine)
+	}
+	height, err := store.Height()
+	if err != nil || height >= 0 {
+		return blockchain.OpenBlockchain(store, engine)
+	}
+
+	digest, err := trustedBootstrapDigest(cfg, args[1:])
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bootstrap file: %v", err)
+	}
+	defer f.Close()
+	genesis, err := blockchain.ReadBootstrapGenesis(f, digest)
+	if err != nil {
+		return nil, err
+	}
+	return blockchain.OpenBlockchainWithGenesis(store, engine, genesis)
+}
+
+// trustedBootstrapDigest returns the digest a bootstrap file has to match:
+// the one given after the file name, for example from release notes, or the
+// one signed in the file's detached signature by one of bootstrap_signers
+func trustedBootstrapDigest(cfg *utils.Config, args []string) (string, error) {
+	if len(args) > 1 {
+		return args[1], nil
+	}
+	data, err := os.ReadFile(args[0] + bootstrapSigSuffix)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("bootstrap file %s has no signature %s%s; give its trusted SHA-256 digest after the file name", args[0], args[0], bootstrapSigSuffix)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read bootstrap signature: %v", err)
+	}
+	var sig blockchain.BootstrapSignature
+	if err := json.Unmarshal(data, &sig); err != nil {
+		return "", fmt.Errorf("failed to unmarshal bootstrap signature: %v", err)
+	}
+	return sig.Verify(cfg.BootstrapSigners)
+}
+
+// Handle writing blocks to a bootstrap file, all of them by default, signed
+// with the export_mnemonic wallet if one is configured
+func handleExport(bc *blockchain.Blockchain, cfg *utils.Config, args []string) int {
+	if len(args) < 1 {
+		fmt.Println("Usage: export <file> [from] [to]")
+		return 2
+	}
+	from, to := 0, bc.Height()
+	for i, target := range []*int{&from, &to} {
+		if len(args) > i+1 {
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 0 {
+				fmt.Printf("Invalid height %q. Usage: export <file> [from] [to]\n", args[i+1])
+				return 2
+			}
+			*target = n
+		}
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		fmt.Printf("Failed to create bootstrap file: %v\n", err)
+		return 1
+	}
+	digest, err := bc.ExportBootstrap(f, from, to)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(args[0])
+		fmt.Printf("Export failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Exported blocks %d to %d to %s\nSHA-256: %s\n", from, to, args[0], digest)
+
+	if cfg.ExportMnemonic == "" {
+		fmt.Println("No export_mnemonic is configured, so the file is not signed.")
+		return 0
+	}
+	if err := signBootstrap(cfg.ExportMnemonic, args[0], digest); err != nil {
+		fmt.Printf("Signing failed: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Signed as %s%s\n", args[0], bootstrapSigSuffix)
+	return 0
+}
+
+// signBootstrap writes the detached signature of a bootstrap file
+func signBootstrap(mnemonic, file, digest string) error {
+	w, err := wallet.RecoverWallet(mnemonic)
+	if err != nil {
+		return fmt.Errorf("invalid export_mnemonic: %v", err)
+	}
+	sig, err := blockchain.SignBootstrap(digest, w)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal bootstrap signature: %v", err)
+	}
+	if err := os.WriteFile(file+bootstrapSigSuffix, data, 0644); err != nil {
+		return fmt.Errorf("failed to write bootstrap signature: %v", err)
+	}
+	return nil
+}
+
+// Handle connecting the blocks of a bootstrap file once it matches a trusted
+// digest. Interrupting it saves the blocks imported so far; running it again
+// resumes after them.
+func handleImport(bc *blockchain.Blockchain, cfg *utils.Config, args []string) int {
+	if len(args) < 1 {
+		fmt.Println("Usage: import <file> [sha256]")
+		return 2
+	}
+	digest, err := trustedBootstrapDigest(cfg, args)
+	if err != nil {
+		fmt.Printf("Import failed: %v\n", err)
+		return 1
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Printf("Failed to open bootstrap file: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Printf("Importing blocks from %s on top of block %d...\n", args[0], bc.Height())
+	result, err := bc.ImportBootstrap(ctx, f, digest, func(block *blockchain.Block) {
+		if block.Index%1000 == 0 {
+			fmt.Printf("Imported block %d\n", block.Index)
+		}
+	})
+	if result != nil {
+		fmt.Printf("Imported %d blocks, skipped %d already in the chain. The chain ends at block %d.\n", result.Imported, result.Skipped, bc.Height())
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("Import interrupted; run it again to resume.")
+		return 1
+	}
+	if err != nil {
+		fmt.Printf("Import failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
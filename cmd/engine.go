@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+	"tpy-blockchain/internal/consensus"
+	"tpy-blockchain/internal/storage"
+	"tpy-blockchain/internal/wallet"
+	"tpy-blockchain/pkg/utils"
+)
+
+// newChainConfig creates the chain config with the configured upgrades and block limits
+func newChainConfig(cfg *utils.Config) (*consensus.ChainConfig, error) {
+	upgrades := make([]*consensus.Upgrade, 0, len(cfg.Upgrades))
+	for _, upgrade := range cfg.Upgrades {
+		upgrades = append(upgrades, &consensus.Upgrade{Name: upgrade.Name, Height: upgrade.Height, Version: upgrade.Version})
+	}
+	config, err := consensus.NewChainConfig(upgrades)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.SetLimits(cfg.MaxBlockSize, cfg.MaxBlockTxs, cfg.MaxTxSize); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// newChainStore opens the storage backend selected in the configuration
+func newChainStore(cfg *utils.Config) (storage.ChainStore, error) {
+	path := "Blocks"
+	if cfg.Storage == storage.BackendSQLite {
+		path = cfg.BlockchainDB
+		if path == "" {
+			path = "blockchain.db"
+		}
+	}
+	return storage.Open(cfg.Storage, path)
+}
+
+// newEngine creates the consensus engine selected in the configuration
+func newEngine(cfg *utils.Config) (consensus.Engine, error) {
+	switch cfg.Consensus {
+	case "", "pow":
+		algorithm, err := consensus.ParsePoWAlgorithm(cfg.PowAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		pow := consensus.NewProofOfWork(cfg.Difficulty)
+		pow.Algorithm = algorithm
+		pow.Miner.Threads = cfg.MiningThreads
+		if cfg.BlockReward > 0 {
+			pow.Reward = big.NewInt(cfg.BlockReward)
+			pow.RewardToken = cfg.RewardToken
+		}
+		return pow, nil
+	case "poa":
+		if len(cfg.Signers) == 0 {
+			return nil, fmt.Errorf("proof-of-authority requires at least one signer")
+		}
+		clique := consensus.NewClique(cfg.Signers, cfg.Epoch)
+		if cfg.SignerMnemonic != "" {
+			w, err := wallet.RecoverWallet(cfg.SignerMnemonic)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load signer wallet: %v", err)
+			}
+			clique.Authorize(w.Address, w.Sign)
+			fmt.Printf("Sealing blocks as %s\n", w.Address)
+		}
+		return clique, nil
+	case "pos":
+		stakeToken := cfg.StakeToken
+		if stakeToken == "" {
+			stakeToken = "TPY"
+		}
+		pos := consensus.NewProofOfStake(stakeToken, cfg.Validators)
+		if cfg.BlockReward > 0 {
+			pos.Reward = big.NewInt(cfg.BlockReward)
+		}
+		if cfg.RoundTimeoutMs > 0 {
+			pos.RoundTimeout = time.Duration(cfg.RoundTimeoutMs) * time.Millisecond
+		}
+		if cfg.SignerMnemonic != "" {
+			w, err := wallet.RecoverWallet(cfg.SignerMnemonic)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load validator wallet: %v", err)
+			}
+			pos.Authorize(w.Address, w.Sign)
+			fmt.Printf("Proposing blocks as %s\n", w.Address)
+		}
+		return pos, nil
+	case "bft":
+		if len(cfg.Validators) == 0 {
+			return nil, fmt.Errorf("BFT consensus requires at least one validator")
+		}
+		validators := make([]*consensus.Validator, 0, len(cfg.Validators))
+		for _, address := range cfg.Validators {
+			validators = append(validators, &consensus.Validator{Address: address, Power: big.NewInt(1)})
+		}
+		tendermint := consensus.NewTendermint(validators)
+		if cfg.RoundTimeoutMs > 0 {
+			tendermint.Timeouts.Propose = time.Duration(cfg.RoundTimeoutMs) * time.Millisecond
+		}
+		if cfg.SignerMnemonic != "" {
+			w, err := wallet.RecoverWallet(cfg.SignerMnemonic)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load validator wallet: %v", err)
+			}
+			tendermint.Authorize(w.Address, w.Sign)
+			fmt.Printf("Validating blocks as %s\n", w.Address)
+		}
+		return tendermint, nil
+	default:
+		return nil, fmt.Errorf("unknown consensus engine %q", cfg.Consensus)
+	}
+}
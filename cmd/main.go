@@ -7,15 +7,53 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"time"
 	"tpy-blockchain/internal/blockchain"
 	"tpy-blockchain/internal/common"
+	"tpy-blockchain/internal/producer"
 	"tpy-blockchain/internal/wallet"
+	"tpy-blockchain/pkg/utils"
 )
 
 func main() {
-	// Initialize the blockchain
-	bc := blockchain.NewBlockchain()
+	// Load the optional node configuration
+	cfg, err := utils.LoadConfigIfExists("config.json")
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Initialize the blockchain with the configured consensus engine
+	engine, err := newEngine(cfg)
+	if err != nil {
+		fmt.Printf("Error creating consensus engine: %v\n", err)
+		os.Exit(1)
+	}
+	chainConfig, err := newChainConfig(cfg)
+	if err != nil {
+		fmt.Printf("Error in chain configuration: %v\n", err)
+		os.Exit(1)
+	}
+	store, err := newChainStore(cfg)
+	if err != nil {
+		fmt.Printf("Error opening storage: %v\n", err)
+		os.Exit(1)
+	}
+	bc, err := openBlockchain(cfg, store, engine, os.Args[1:])
+	if err != nil {
+		fmt.Printf("Error loading blockchain: %v\n", err)
+		os.Exit(1)
+	}
+	if err := bc.SetChainConfig(chainConfig); err != nil {
+		fmt.Printf("Error applying upgrades: %v\n", err)
+		os.Exit(1)
+	}
+	bc.SetAuditEachBlock(cfg.AuditEachBlock)
+	restore, err := bc.LoadPending()
+	if err != nil {
+		fmt.Printf("Error loading pending transactions: %v\n", err)
+		os.Exit(1)
+	}
+	reportPendingRestore(restore)
 	fmt.Println("Blockchain initialized with genesis block.")
 
 	// Create a UtilityToken using common.UtilityToken
@@ -28,13 +66,39 @@ func main() {
 	}
 
 	// Add the token to the blockchain
-	err := bc.AddToken(token.Name, token.Symbol, token.TotalSupply, token.Decimals)
+	err = bc.AddToken(token.Name, token.Symbol, token.TotalSupply, token.Decimals)
 	if err != nil {
 		fmt.Printf("Error adding token: %v\n", err)
 	} else {
 		fmt.Printf("Utility Token '%s' (%s) successfully added with total supply %s.\n", token.Name, token.Symbol, token.TotalSupply.String())
 	}
 
+	// Run a one-shot command if one was given, e.g. `go run cmd/main.go reindex`
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(bc, cfg, os.Args[1], os.Args[2:]))
+	}
+
+	// New wallets are funded from the faucet wallet, if one is configured
+	var faucet *wallet.Wallet
+	if cfg.FaucetMnemonic != "" {
+		if faucet, err = wallet.RecoverWallet(cfg.FaucetMnemonic); err != nil {
+			fmt.Printf("Error loading faucet wallet: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// The block producer turns pending transactions into blocks in the background
+	blockProducer := producer.NewProducer(bc)
+	blockProducer.SetCoinbase(cfg.Coinbase)
+	blockProducer.AllowEmpty = cfg.AllowEmptyBlocks
+	if cfg.ProduceBlocks {
+		if err := blockProducer.Start(); err != nil {
+			fmt.Printf("Error starting block producer: %v\n", err)
+		} else {
+			fmt.Println("Block producer started.")
+		}
+	}
+
 	// Command-line interface loop
 	reader := bufio.NewReader(os.Stdin)
 	for {
@@ -45,6 +109,8 @@ func main() {
 		fmt.Println("4. View Wallet Balance")
 		fmt.Println("5. Transfer Tokens")
 		fmt.Println("6. Exit")
+		fmt.Println("7. Start/Stop Block Producer")
+		fmt.Println("8. Set Coinbase Address")
 		fmt.Print("Enter your choice: ")
 
 		// Read user input
@@ -57,27 +123,45 @@ func main() {
 
 		switch choice {
 		case "1":
-			handleCreateWallet(bc, token)
+			handleCreateWallet(bc, token.Symbol, faucet, blockProducer)
 		case "2":
 			handleViewBlockchain(bc)
 		case "3":
 			handleAddTransaction(bc, reader)
 		case "4":
-			handleViewWalletBalance(reader, token) // Removed bc
+			handleViewWalletBalance(bc, reader, token.Symbol)
 		case "5":
-			handleTransferTokens(reader, token)    // Removed bc		
+			handleTransferTokens(bc, reader, token.Symbol, blockProducer)
 		case "6":
+			if blockProducer.Running() {
+				blockProducer.Stop()
+			}
 			fmt.Println("Exiting...")
 			return
+		case "7":
+			handleToggleProducer(blockProducer)
+		case "8":
+			handleSetCoinbase(reader, blockProducer)
 		default:
 			fmt.Println("Invalid choice, please try again.")
 		}
 	}
 }
 
+// reportPendingRestore prints how many pending transactions survived the
+// restart and why the others were dropped
+func reportPendingRestore(restore *blockchain.PendingRestore) {
+	fmt.Printf("Restored %d pending transactions.\n", restore.Restored)
+	for _, dropped := range restore.Stale {
+		fmt.Printf("Dropped stale pending transaction %s: %s\n", dropped.Hash, dropped.Reason)
+	}
+	for _, dropped := range restore.Invalid {
+		fmt.Printf("Dropped invalid pending transaction %s: %s\n", dropped.Hash, dropped.Reason)
+	}
+}
+
 // Handle wallet creation
-// Handle wallet creation
-func handleCreateWallet(bc *blockchain.Blockchain, token *common.UtilityToken) {
+func handleCreateWallet(bc *blockchain.Blockchain, tokenSymbol string, faucet *wallet.Wallet, blockProducer *producer.Producer) {
 	fmt.Println("\nCreating a new wallet...")
 	w, err := wallet.NewWallet()
 	if err != nil {
@@ -87,36 +171,44 @@ func handleCreateWallet(bc *blockchain.Blockchain, token *common.UtilityToken) {
 
 	// Add wallet to blockchain
 	bc.Wallets[w.Address] = w
+	fmt.Printf("Wallet Created Successfully!\nAddress: %s\nMnemonic: %s\n", w.Address, w.Mnemonic)
+	if faucet == nil {
+		fmt.Println("No faucet_mnemonic is configured, so the wallet starts without a balance.")
+		return
+	}
 
-	// Assign initial token balance
+	// Fund the wallet with a transfer from the faucet in a new block, or leave
+	// it to the block producer if it is running
 	initialBalance := big.NewInt(1000)
-	token.Balances[w.Address] = initialBalance
-
-	// Create a new block to reflect the wallet creation
-	block := &blockchain.Block{
-		Index:        len(bc.Blocks),
-		Timestamp:    time.Now().String(),
-		Transactions: []*blockchain.Transaction{}, // No transactions yet
-		Wallets:      bc.Wallets,                  // Updated wallets
-		Tokens:       bc.Tokens,                   // Updated tokens
-		Nonce:        0,
-		PreviousHash: bc.Blocks[len(bc.Blocks)-1].Hash,
+	funding := blockchain.NewUnsignedTransaction(blockchain.TxTypeTransfer, faucet.Address, w.Address, initialBalance, tokenSymbol, "")
+	funding.SetVersion(bc.TxVersion())
+	funding.SetNonce(bc.NextNonce(faucet.Address))
+	bc.FillFees(funding)
+	if err := funding.Sign(faucet); err != nil {
+		fmt.Println("Error signing faucet transfer:", err)
+		return
+	}
+	if blockProducer.Running() {
+		if err := bc.AddTransaction(funding); err != nil {
+			fmt.Println("Error funding wallet:", err)
+			return
+		}
+		fmt.Printf("Transfer of %s %s from the faucet added to the pending transactions.\n", initialBalance.String(), tokenSymbol)
+		return
+	}
+	if err := bc.AddBlock([]*blockchain.Transaction{funding}); err != nil {
+		fmt.Printf("Failed to add block: %v\n", err)
+		return
 	}
-	block.Hash = blockchain.CalculateHash(block)
-
-	// Add the block to the blockchain
-	bc.Blocks = append(bc.Blocks, block)
 
 	// Save the updated blockchain
-	err = bc.SaveBlocksToFile()
+	err = bc.Save()
 	if err != nil {
 		fmt.Printf("Failed to save blockchain: %v\n", err)
 		return
 	}
 
-	// Display wallet details
-	fmt.Printf("Wallet Created Successfully!\nAddress: %s\nMnemonic: %s\n", w.Address, w.Mnemonic)
-	fmt.Printf("Assigned initial balance of %s %s\n", initialBalance.String(), token.Symbol)
+	fmt.Printf("Transferred an initial balance of %s %s from the faucet\n", initialBalance.String(), tokenSymbol)
 	fmt.Println("Blockchain updated with new block.")
 }
 
@@ -151,10 +243,18 @@ func handleAddTransaction(bc *blockchain.Blockchain, reader *bufio.Reader) {
 		return
 	}
 
-	transaction := &blockchain.Transaction{
-		Sender:   sender,
-		Receiver: receiver,
-		Amount:   big.NewInt(amount),
+	senderWallet, err := signingWallet(bc, reader, sender)
+	if err != nil {
+		fmt.Println("Error loading sender wallet:", err)
+		return
+	}
+	transaction := blockchain.NewUnsignedTransaction(blockchain.TxTypeTransfer, sender, receiver, big.NewInt(amount), "", "")
+	transaction.SetVersion(bc.TxVersion())
+	transaction.SetNonce(bc.NextNonce(sender))
+	bc.FillFees(transaction)
+	if err := transaction.Sign(senderWallet); err != nil {
+		fmt.Println("Error signing transaction:", err)
+		return
 	}
 	err = bc.AddTransaction(transaction)
 	if err != nil {
@@ -162,25 +262,44 @@ func handleAddTransaction(bc *blockchain.Blockchain, reader *bufio.Reader) {
 		return
 	}
 
-	fmt.Println("Transaction added successfully!")
+	fmt.Println("Transaction added to the pending transactions!")
+}
+
+// signingWallet returns the key of a sender to sign its transactions with:
+// the wallet if it was created in this session, otherwise the wallet
+// recovered from a mnemonic the user enters
+func signingWallet(bc *blockchain.Blockchain, reader *bufio.Reader, address string) (*wallet.Wallet, error) {
+	if w, ok := bc.Wallets[address]; ok && w.PrivateKey != nil {
+		return w, nil
+	}
+	fmt.Printf("Enter the mnemonic of %s: ", address)
+	mnemonic, _ := reader.ReadString('\n')
+	w, err := wallet.RecoverWallet(strings.TrimSpace(mnemonic))
+	if err != nil {
+		return nil, err
+	}
+	if w.Address != address {
+		return nil, fmt.Errorf("the mnemonic belongs to %s, not %s", w.Address, address)
+	}
+	return w, nil
 }
 
 // Handle viewing wallet balance
-func handleViewWalletBalance(reader *bufio.Reader, token *common.UtilityToken) {
+func handleViewWalletBalance(bc *blockchain.Blockchain, reader *bufio.Reader, tokenSymbol string) {
 	fmt.Print("\nEnter wallet address: ")
 	address, _ := reader.ReadString('\n')
 	address = strings.TrimSpace(address)
 
-	balance := token.Balances[address]
+	balance := bc.Tokens[tokenSymbol].Balances[address]
 	if balance == nil {
 		balance = big.NewInt(0)
 	}
 
-	fmt.Printf("Wallet Balance: %s %s\n", balance.String(), token.Symbol)
+	fmt.Printf("Wallet Balance: %s %s\n", balance.String(), tokenSymbol)
 }
 
 // Handle transferring tokens
-func handleTransferTokens(reader *bufio.Reader, token *common.UtilityToken) {
+func handleTransferTokens(bc *blockchain.Blockchain, reader *bufio.Reader, tokenSymbol string, blockProducer *producer.Producer) {
 	fmt.Println("\nTransferring tokens...")
 	fmt.Print("Enter sender address: ")
 	sender, _ := reader.ReadString('\n')
@@ -199,12 +318,64 @@ func handleTransferTokens(reader *bufio.Reader, token *common.UtilityToken) {
 		return
 	}
 
-	// Perform the token transfer
-	err = token.Transfer(sender, receiver, big.NewInt(amount))
+	senderWallet, err := signingWallet(bc, reader, sender)
+	if err != nil {
+		fmt.Println("Error loading sender wallet:", err)
+		return
+	}
+
+	// Perform the token transfer in a new block, or leave it to the block producer if it is running
+	transfer := blockchain.NewUnsignedTransaction(blockchain.TxTypeTransfer, sender, receiver, big.NewInt(amount), tokenSymbol, "")
+	transfer.SetVersion(bc.TxVersion())
+	transfer.SetNonce(bc.NextNonce(sender))
+	bc.FillFees(transfer)
+	if err := transfer.Sign(senderWallet); err != nil {
+		fmt.Println("Error signing transfer:", err)
+		return
+	}
+	if blockProducer.Running() {
+		if err := bc.AddTransaction(transfer); err != nil {
+			fmt.Println("Error transferring tokens:", err)
+			return
+		}
+		fmt.Println("Transfer added to the pending transactions, the block producer will include it in the next block.")
+		return
+	}
+	err = bc.AddBlock([]*blockchain.Transaction{transfer})
 	if err != nil {
 		fmt.Println("Error transferring tokens:", err)
 		return
 	}
+	if err := bc.Save(); err != nil {
+		fmt.Printf("Failed to save blockchain: %v\n", err)
+		return
+	}
 
 	fmt.Println("Tokens transferred successfully!")
 }
+
+// Handle starting or stopping the block producer
+func handleToggleProducer(blockProducer *producer.Producer) {
+	if blockProducer.Running() {
+		if err := blockProducer.Stop(); err != nil {
+			fmt.Println("Error stopping block producer:", err)
+			return
+		}
+		fmt.Printf("Block producer stopped after producing %d blocks.\n", blockProducer.Produced())
+		return
+	}
+	if err := blockProducer.Start(); err != nil {
+		fmt.Println("Error starting block producer:", err)
+		return
+	}
+	fmt.Println("Block producer started.")
+}
+
+// Handle setting the address that receives the rewards of produced blocks
+func handleSetCoinbase(reader *bufio.Reader, blockProducer *producer.Producer) {
+	fmt.Printf("\nCurrent coinbase: %q\n", blockProducer.Coinbase())
+	fmt.Print("Enter coinbase address: ")
+	address, _ := reader.ReadString('\n')
+	blockProducer.SetCoinbase(strings.TrimSpace(address))
+	fmt.Println("Coinbase updated.")
+}
@@ -0,0 +1,144 @@
+// Command miner mines proof-of-work blocks for a node in a separate process.
+// It fetches block templates from the node's GET /work endpoint, searches for
+// a nonce on all CPUs and submits solutions to POST /work.
+//
+//	go run ./cmd/miner -node http://localhost:8080 -coinbase 0x... -threads 4
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"tpy-blockchain/internal/consensus"
+	"tpy-blockchain/internal/producer"
+)
+
+// pollInterval is how often the node is asked whether the tip has moved on
+const pollInterval = 2 * time.Second
+
+func main() {
+	node := flag.String("node", "http://localhost:8080", "Base URL of the node's REST API")
+	coinbase := flag.String("coinbase", "", "Address receiving the block rewards")
+	threads := flag.Int("threads", 0, "Worker goroutines, all CPUs if 0")
+	flag.Parse()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	base := strings.TrimSuffix(*node, "/")
+	miner := consensus.NewMiner(*threads)
+
+	for {
+		work, err := getWork(client, base, *coinbase)
+		if err != nil {
+			fmt.Printf("Failed to get work: %v\n", err)
+			time.Sleep(pollInterval)
+			continue
+		}
+		fmt.Printf("Mining block %d at %s difficulty %d (job %s)\n", work.Height, work.Algorithm, work.Difficulty, work.ID[:12])
+
+		header, err := mine(client, base, *coinbase, miner, work)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		stats := miner.Stats()
+		fmt.Printf("Found nonce %d after %d hashes (%.0f H/s)\n", header.Nonce, stats.Hashes, stats.HashRate)
+
+		solution := &producer.Solution{ID: work.ID, Nonce: header.Nonce, Extra: header.Extra}
+		if header.Timestamp != work.Header.Timestamp {
+			solution.Timestamp = header.Timestamp
+		}
+		if err := submitWork(client, base, solution); err != nil {
+			fmt.Printf("Solution rejected: %v\n", err)
+			continue
+		}
+		fmt.Printf("Block %d accepted: %s\n", work.Height, header.Hash)
+	}
+}
+
+// mine searches for a solution to the job until one is found or the node
+// hands out work for a later block
+func mine(client *http.Client, base, coinbase string, miner *consensus.Miner, work *producer.Work) (*consensus.Header, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+			}
+			if latest, err := getWork(client, base, coinbase); err == nil && latest.Height != work.Height {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	header := work.Header.Copy()
+	if err := miner.Mine(ctx, header, work.Algorithm, work.Difficulty); err != nil {
+		return nil, fmt.Errorf("abandoned block %d, the chain moved on", work.Height)
+	}
+	return header, nil
+}
+
+// getWork fetches a block template from the node
+func getWork(client *http.Client, base, coinbase string) (*producer.Work, error) {
+	resp, err := client.Get(base + "/work?coinbase=" + url.QueryEscape(coinbase))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeError(resp)
+	}
+	var work producer.Work
+	if err := json.NewDecoder(resp.Body).Decode(&work); err != nil {
+		return nil, fmt.Errorf("failed to decode work: %v", err)
+	}
+	return &work, nil
+}
+
+// submitWork sends a solution to the node
+func submitWork(client *http.Client, base string, solution *producer.Solution) error {
+	body, err := json.Marshal(solution)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(base+"/work", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp)
+	}
+	return nil
+}
+
+// decodeError returns the error message of a failed API response
+func decodeError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error == "" {
+		return fmt.Errorf("node returned %s", resp.Status)
+	}
+	return fmt.Errorf("%s", body.Error)
+}
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: miner [-node url] [-coinbase address] [-threads n]\n")
+		flag.PrintDefaults()
+	}
+}
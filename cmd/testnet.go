@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+	"tpy-blockchain/internal/blockchain"
+	"tpy-blockchain/internal/consensus"
+	"tpy-blockchain/internal/wallet"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+// testnetTimeout bounds how long the BFT testnet runs before giving up
+const testnetTimeout = 2 * time.Minute
+
+// Handle running a BFT network of validators in this process, each with its
+// own chain in a temporary directory, until every node committed the
+// requested number of blocks. The last validators can be started offline to
+// show that consensus only needs more than two thirds of them.
+func handleBFTTestnet(args []string) int {
+	validators, blocks, offline := 4, 5, 0
+	for i, target := range []*int{&validators, &blocks, &offline} {
+		if len(args) > i {
+			n, err := strconv.Atoi(args[i])
+			if err != nil || n < 0 {
+				fmt.Printf("Invalid argument %q. Usage: bft-testnet [validators] [blocks] [offline]\n", args[i])
+				return 2
+			}
+			*target = n
+		}
+	}
+	if validators == 0 || offline >= validators {
+		fmt.Println("The testnet needs at least one online validator")
+		return 2
+	}
+
+	dir, err := os.MkdirTemp("", "bft-testnet")
+	if err != nil {
+		fmt.Printf("Failed to create testnet directory: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	// Every validator gets a fresh key with equal voting power
+	keys := make([]*wallet.Wallet, validators)
+	set := make([]*consensus.Validator, validators)
+	for i := range keys {
+		entropy, err := bip39.NewEntropy(128)
+		if err != nil {
+			fmt.Printf("Failed to generate validator key: %v\n", err)
+			return 1
+		}
+		mnemonic, _ := bip39.NewMnemonic(entropy)
+		if keys[i], err = wallet.RecoverWallet(mnemonic); err != nil {
+			fmt.Printf("Failed to generate validator key: %v\n", err)
+			return 1
+		}
+		set[i] = &consensus.Validator{Address: keys[i].Address, Power: big.NewInt(1)}
+	}
+
+	// All nodes start from the genesis block of the first one
+	network := consensus.NewLocalNetwork()
+	chains := make([]*blockchain.Blockchain, validators)
+	engines := make([]*consensus.Tendermint, validators)
+	for i := range chains {
+		blockDir := filepath.Join(dir, fmt.Sprintf("node%d", i))
+		if i > 0 {
+			if err := copyGenesis(filepath.Join(dir, "node0"), blockDir); err != nil {
+				fmt.Printf("Failed to share genesis: %v\n", err)
+				return 1
+			}
+		}
+		engines[i] = consensus.NewTendermint(set)
+		engines[i].Timeouts = consensus.Timeouts{
+			Propose:   time.Second,
+			Prevote:   500 * time.Millisecond,
+			Precommit: 500 * time.Millisecond,
+			Delta:     250 * time.Millisecond,
+			Commit:    100 * time.Millisecond,
+		}
+		engines[i].Authorize(keys[i].Address, keys[i].Sign)
+		if chains[i], err = blockchain.NewBlockchainInDir(blockDir, engines[i]); err != nil {
+			fmt.Printf("Failed to start validator %d: %v\n", i, err)
+			return 1
+		}
+		network.Join(engines[i])
+		if i >= validators-offline {
+			network.SetConnected(engines[i], false)
+		}
+	}
+
+	fmt.Printf("\nRunning %d validators (%d offline) until %d blocks are committed...\n", validators, offline, blocks)
+	ctx, cancel := context.WithTimeout(context.Background(), testnetTimeout)
+	defer cancel()
+	for i := 0; i < validators-offline; i++ {
+		go engines[i].Run(ctx, chains[i].BFTBackend())
+	}
+
+	online := chains[:validators-offline]
+	for !committed(online, blocks) {
+		select {
+		case <-ctx.Done():
+			fmt.Printf("Testnet did not commit %d blocks within %s\n", blocks, testnetTimeout)
+			return 1
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	cancel()
+
+	fmt.Println("\n--- Committed Blocks ---")
+	for index := 1; index <= blocks; index++ {
+		block, _ := online[0].GetBlockByIndex(index)
+		fmt.Printf("Block %d: %s proposed by %s in round %d, %d/%d precommits\n",
+			block.Index, block.Hash, block.Coinbase, block.Commit.Round, len(block.Commit.Precommits), validators)
+		for i, chain := range online[1:] {
+			other, err := chain.GetBlockByIndex(index)
+			if err != nil || other.Hash != block.Hash {
+				fmt.Printf("Node %d disagrees on block %d\n", i+1, index)
+				return 1
+			}
+		}
+	}
+	fmt.Printf("All %d online nodes agree on blocks 1 to %d\n", len(online), blocks)
+	return 0
+}
+
+// committed reports whether every chain has at least the given number of blocks after genesis
+func committed(chains []*blockchain.Blockchain, blocks int) bool {
+	for _, chain := range chains {
+		if chain.BFTBackend().CurrentHeader().Index < blocks {
+			return false
+		}
+	}
+	return true
+}
+
+// copyGenesis seeds a new node's block directory with the files of another node
+// that holds only the genesis block
+func copyGenesis(fromDir, toDir string) error {
+	files, err := os.ReadDir(fromDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(toDir, 0755); err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(fromDir, file.Name()))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(toDir, file.Name()), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// TokenSupply is the audited supply of one token. Native balances use an empty symbol.
+type TokenSupply struct {
+	Symbol      string `json:"symbol"`
+	Circulating string `json:"circulating"`       // Sum of all balances, stake and rewards in the replayed state
+	Minted      string `json:"minted"`            // Sum of mint transactions in the blocks
+	Burned      string `json:"burned"`            // Sum of burn transactions in the blocks, slashed stake and burned fees
+	Slashed     string `json:"slashed,omitempty"` // Stake burned by slashing evidence in the blocks
+	TotalSupply string `json:"totalSupply,omitempty"`
+}
+
+// AuditReport is the result of a supply audit at a given height
+type AuditReport struct {
+	Height        int            `json:"height"`
+	BlockHash     string         `json:"blockHash"`
+	Supplies      []*TokenSupply `json:"supplies"`
+	Discrepancies []string       `json:"discrepancies"`
+}
+
+// OK reports whether the audit found no discrepancies
+func (r *AuditReport) OK() bool {
+	return len(r.Discrepancies) == 0
+}
+
+// AuditSupply replays the chain up to height and checks, for every token,
+// that the circulating supply equals minted minus burned as counted from
+// the block transactions, and that the issued supply stays within the total
+// supply. At the tip it also compares the live state with the replayed one.
+func (bc *Blockchain) AuditSupply(height int) (*AuditReport, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	st, err := ReplayBlocks(bc.Blocks, height, bc.Tokens, bc.engine, bc.config)
+	if err != nil {
+		return nil, err
+	}
+
+	minted := make(map[string]*big.Int)
+	burned := make(map[string]*big.Int)
+	for _, block := range bc.Blocks[:height+1] {
+		author, err := bc.engine.Author(block.Header())
+		if err != nil {
+			return nil, err
+		}
+		if fees := burnedFees(block, author); fees.Sign() > 0 {
+			burned[""] = addBig(burned[""], fees)
+		}
+		for _, tx := range block.Transactions {
+			switch tx.Type {
+			case TxTypeMint:
+				minted[tx.TokenSymbol] = addBig(minted[tx.TokenSymbol], tx.Amount)
+			case TxTypeBurn:
+				burned[tx.TokenSymbol] = addBig(burned[tx.TokenSymbol], tx.Amount)
+			}
+		}
+	}
+
+	report := &AuditReport{
+		Height:        height,
+		BlockHash:     bc.Blocks[height].Hash,
+		Discrepancies: []string{},
+	}
+	// Slashing burns stake without a burn amount in the transaction, so take it from the replay
+	for symbol, token := range st.Tokens {
+		if token.Slashed != nil && token.Slashed.Sign() > 0 {
+			burned[symbol] = addBig(burned[symbol], token.Slashed)
+		}
+	}
+
+	replayed := circulatingSupply(st)
+	for _, symbol := range auditedSymbols(st, minted) {
+		supply := &TokenSupply{
+			Symbol:      symbol,
+			Circulating: bigOrZero(replayed[symbol]).String(),
+			Minted:      bigOrZero(minted[symbol]).String(),
+			Burned:      bigOrZero(burned[symbol]).String(),
+		}
+		if token, ok := st.Tokens[symbol]; ok && token.Slashed != nil && token.Slashed.Sign() > 0 {
+			supply.Slashed = token.Slashed.String()
+		}
+		report.Supplies = append(report.Supplies, supply)
+
+		expected := new(big.Int).Sub(bigOrZero(minted[symbol]), bigOrZero(burned[symbol]))
+		if bigOrZero(replayed[symbol]).Cmp(expected) != 0 {
+			report.Discrepancies = append(report.Discrepancies, fmt.Sprintf("%s: circulating %s != minted %s - burned %s",
+				supplyName(symbol), supply.Circulating, supply.Minted, supply.Burned))
+		}
+		if token, ok := st.Tokens[symbol]; ok && token.TotalSupply != nil && token.TotalSupply.Sign() > 0 {
+			supply.TotalSupply = token.TotalSupply.String()
+			if expected.Cmp(token.TotalSupply) > 0 {
+				report.Discrepancies = append(report.Discrepancies, fmt.Sprintf("%s: issued %s exceeds total supply %s",
+					supplyName(symbol), expected, token.TotalSupply))
+			}
+		}
+	}
+
+	if height == len(bc.Blocks)-1 {
+		live := circulatingSupply(bc.State)
+		for _, symbol := range auditedSymbols(bc.State, replayed) {
+			if bigOrZero(live[symbol]).Cmp(bigOrZero(replayed[symbol])) != 0 {
+				report.Discrepancies = append(report.Discrepancies, fmt.Sprintf("%s: live state holds %s but the blocks give %s",
+					supplyName(symbol), bigOrZero(live[symbol]), bigOrZero(replayed[symbol])))
+			}
+		}
+	}
+	return report, nil
+}
+
+// checkSupply compares each token's balances with its tracked minted minus burned supply.
+// It is cheap enough to run after every block.
+func checkSupply(st *State) []string {
+	var discrepancies []string
+	circulating := circulatingSupply(st)
+	for _, symbol := range auditedSymbols(st, nil) {
+		token, ok := st.Tokens[symbol]
+		if !ok {
+			continue
+		}
+		if bigOrZero(circulating[symbol]).Cmp(token.Issued()) != 0 {
+			discrepancies = append(discrepancies, fmt.Sprintf("%s: circulating %s != issued %s",
+				symbol, bigOrZero(circulating[symbol]), token.Issued()))
+		}
+	}
+	return discrepancies
+}
+
+// circulatingSupply sums the balances, stake and unclaimed staking rewards of
+// every token, with native balances under ""
+func circulatingSupply(st *State) map[string]*big.Int {
+	supply := make(map[string]*big.Int)
+	for _, balance := range st.Balances {
+		supply[""] = addBig(supply[""], balance)
+	}
+	for symbol, token := range st.Tokens {
+		supply[symbol] = new(big.Int).Add(token.Bonded(), token.Unclaimed())
+		for _, balance := range token.Balances {
+			supply[symbol].Add(supply[symbol], balance)
+		}
+	}
+	return supply
+}
+
+// auditedSymbols lists the token symbols present in the state or in extra, sorted
+func auditedSymbols(st *State, extra map[string]*big.Int) []string {
+	seen := make(map[string]bool)
+	for symbol := range st.Tokens {
+		seen[symbol] = true
+	}
+	if len(st.Balances) > 0 {
+		seen[""] = true
+	}
+	for symbol := range extra {
+		seen[symbol] = true
+	}
+
+	symbols := make([]string, 0, len(seen))
+	for symbol := range seen {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
+
+func bigOrZero(b *big.Int) *big.Int {
+	if b == nil {
+		return big.NewInt(0)
+	}
+	return b
+}
+
+func supplyName(symbol string) string {
+	if symbol == "" {
+		return "native"
+	}
+	return symbol
+}
@@ -0,0 +1,104 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"tpy-blockchain/internal/consensus"
+)
+
+// bftBackend lets a Tendermint engine propose, verify and commit blocks of the chain
+type bftBackend struct {
+	bc *Blockchain
+}
+
+// BFTBackend returns the chain as a backend for a Tendermint engine's Run loop.
+// Proposals carry the JSON encoded transactions of the block as payload.
+func (bc *Blockchain) BFTBackend() consensus.BFTBackend {
+	return &bftBackend{bc: bc}
+}
+
+func (b *bftBackend) CurrentHeader() *consensus.Header {
+	b.bc.mutex.Lock()
+	defer b.bc.mutex.Unlock()
+	return b.bc.view().CurrentHeader()
+}
+
+// BuildProposal seals a block with the pending transactions on top of the tip
+func (b *bftBackend) BuildProposal(ctx context.Context) (*consensus.Header, []byte, error) {
+	b.bc.mutex.Lock()
+	defer b.bc.mutex.Unlock()
+
+	template, err := b.bc.newTemplate(b.bc.Transactions, "", true)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := b.bc.SealBlock(ctx, template)
+	if err != nil {
+		return nil, nil, err
+	}
+	payload, err := json.Marshal(block.Transactions)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode block %d: %v", block.Index, err)
+	}
+	return block.Header(), payload, nil
+}
+
+// VerifyProposal runs the checks of connecting a block on a proposed one,
+// except for the commit certificate it cannot have yet
+func (b *bftBackend) VerifyProposal(header *consensus.Header, payload []byte) error {
+	block, err := decodeProposal(header, payload)
+	if err != nil {
+		return err
+	}
+
+	b.bc.mutex.Lock()
+	defer b.bc.mutex.Unlock()
+
+	if err := validateBlockLinkage(block, b.bc.Blocks[len(b.bc.Blocks)-1]); err != nil {
+		return err
+	}
+	verifyHeader := b.bc.engine.VerifyHeader
+	if tendermint, ok := b.bc.engine.(*consensus.Tendermint); ok {
+		verifyHeader = func(chain consensus.ChainReader, header *consensus.Header) error {
+			if err := tendermint.VerifyProposal(header); err != nil {
+				return err
+			}
+			return consensus.VerifyTimestamp(chain, header)
+		}
+	}
+	if err := verifyBlockRules(b.bc.engine, b.bc.view(), block, verifyHeader); err != nil {
+		return err
+	}
+	newState := b.bc.State.Copy()
+	if err := newState.applyBlock(block, b.bc.engine); err != nil {
+		return fmt.Errorf("block %d: %v", block.Index, err)
+	}
+	if newState.Root() != block.StateRoot {
+		return fmt.Errorf("block %d state root mismatch", block.Index)
+	}
+	return nil
+}
+
+// CommitBlock connects a block carrying its commit certificate and saves the chain
+func (b *bftBackend) CommitBlock(header *consensus.Header, payload []byte) error {
+	block, err := decodeProposal(header, payload)
+	if err != nil {
+		return err
+	}
+	if err := b.bc.ConnectBlock(block); err != nil {
+		return err
+	}
+	return b.bc.Save()
+}
+
+// decodeProposal rebuilds a block from a proposed header and its transactions
+func decodeProposal(header *consensus.Header, payload []byte) (*Block, error) {
+	var transactions []*Transaction
+	if err := json.Unmarshal(payload, &transactions); err != nil {
+		return nil, fmt.Errorf("failed to decode block %d: %v", header.Index, err)
+	}
+	block := &Block{Transactions: transactions}
+	block.applyHeader(header)
+	return block, nil
+}
@@ -0,0 +1,48 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"tpy-blockchain/internal/consensus"
+)
+
+func TestBFTProposalWithMintIsRejected(t *testing.T) {
+	validator := newTestWallet(t)
+	engine := consensus.NewTendermint([]*consensus.Validator{{Address: validator.Address, Power: big.NewInt(1)}})
+	engine.Authorize(validator.Address, validator.Sign)
+	bc := newTestChain(t, t.TempDir(), engine)
+	backend := bc.BFTBackend()
+
+	header, payload, err := backend.BuildProposal(context.Background())
+	if err != nil {
+		t.Fatalf("failed to build proposal: %v", err)
+	}
+	if err := backend.VerifyProposal(header, payload); err != nil {
+		t.Fatalf("valid proposal rejected: %v", err)
+	}
+
+	// A correctly signed proposal whose state root includes a mint to the proposer
+	mint := NewUnsignedTransaction(TxTypeMint, "", validator.Address, big.NewInt(1000000), "", "")
+	mint.SetVersion(bc.TxVersion())
+	bc.mutex.Lock()
+	template, err := bc.newTemplate([]*Transaction{mint}, "", false)
+	bc.mutex.Unlock()
+	if err != nil {
+		t.Fatalf("failed to build block with a mint: %v", err)
+	}
+	block, err := bc.SealBlock(context.Background(), template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err = json.Marshal(block.Transactions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = backend.VerifyProposal(block.Header(), payload)
+	if err == nil || !strings.Contains(err.Error(), "unexpected mint") {
+		t.Fatalf("proposal with a mint was not rejected for it: %v", err)
+	}
+}
@@ -1,23 +1,32 @@
 package blockchain
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"context"
 	"fmt"
+	"math/big"
 	"time"
 	"tpy-blockchain/internal/common"
+	"tpy-blockchain/internal/consensus"
 	"tpy-blockchain/internal/wallet"
 )
 
 type Block struct {
+	Version       int                          `json:"version,omitempty"` // Rules the block follows, see consensus.ChainConfig
 	Index         int                          `json:"index"`
 	Timestamp     string                       `json:"timestamp"`
 	Transactions  []*Transaction               `json:"transactions"`
 	Wallets       map[string]*wallet.Wallet    `json:"wallets"` // Include Wallets
 	Tokens        map[string]*common.UtilityToken `json:"tokens"` // Include Tokens
-	Nonce         int                          `json:"nonce"`
+	Nonce         uint64                       `json:"nonce"`
 	PreviousHash  string                       `json:"previousHash"`
+	MerkleRoot    string                       `json:"merkleRoot"` // Root of the transaction hashes
+	StateRoot     string                       `json:"stateRoot"`  // Root of the balances after this block
+	Difficulty    int                          `json:"difficulty,omitempty"`
+	Coinbase      string                       `json:"coinbase,omitempty"` // Receives the block reward
+	BaseFee       *big.Int                     `json:"baseFee,omitempty"`  // Fee burned per transaction, see consensus.UpgradeFeeMarket
+	Extra         string                       `json:"extra,omitempty"`    // Engine specific seal
 	Hash          string                       `json:"hash"`
+	Commit        *consensus.Commit            `json:"commit,omitempty"` // BFT commit certificate
 }
 
 // NewBlock initializes a new block with the given parameters
@@ -29,6 +38,7 @@ func NewBlock(index int, previousHash string, transactions []*Transaction) *Bloc
 		Wallets:      make(map[string]*wallet.Wallet),
 		Tokens:       make(map[string]*common.UtilityToken),
 		PreviousHash: previousHash,
+		MerkleRoot:   TransactionsRoot(transactions),
 		Nonce:        0,
 	}
 	block.Hash = CalculateHash(block)
@@ -41,6 +51,7 @@ func (block *Block) AddTransaction(tx *Transaction) error {
 		return fmt.Errorf("transaction validation failed: %v", err)
 	}
 	block.Transactions = append(block.Transactions, tx)
+	block.MerkleRoot = TransactionsRoot(block.Transactions)
 	return nil
 }
 
@@ -54,29 +65,26 @@ func (block *Block) AddToken(token *common.UtilityToken) {
 	block.Tokens[token.Symbol] = token
 }
 
-// CalculateHash computes a hash of the block
+// CalculateHash computes a hash of the block from its header fields
 func CalculateHash(block *Block) string {
-	record := fmt.Sprintf("%08d", block.Index) + block.Timestamp + fmt.Sprintf("%08d", block.Nonce) + block.PreviousHash
-	for _, tx := range block.Transactions {
-		record += tx.Hash
-	}
-	h := sha256.New()
-	h.Write([]byte(record))
-	return hex.EncodeToString(h.Sum(nil))
+	return CalculateHeaderHash(block.Header())
 }
 
-// MineBlock performs proof-of-work mining for the block
-func (block *Block) MineBlock(difficulty int) {
-	target := ""
-	for i := 0; i < difficulty; i++ {
-		target += "0"
-	}
-
-	for block.Hash[:difficulty] != target {
-		block.Nonce++
-		block.Hash = CalculateHash(block)
+// MineBlock performs proof-of-work mining for the block on the given number
+// of threads, all CPUs if 0. It stops with ctx's error if ctx is cancelled,
+// e.g. when a competing block arrives.
+func (block *Block) MineBlock(ctx context.Context, difficulty, threads int) error {
+	pow := consensus.NewProofOfWork(difficulty)
+	pow.Miner.Threads = threads
+	header := block.Header()
+	pow.Prepare(nil, header)
+	if err := pow.Seal(ctx, nil, header); err != nil {
+		return err
 	}
-	fmt.Printf("Block mined with nonce %d: %s\n", block.Nonce, block.Hash)
+	block.applyHeader(header)
+	stats := pow.Miner.Stats()
+	fmt.Printf("Block mined with nonce %d: %s (%.0f H/s on %d threads)\n", block.Nonce, block.Hash, stats.HashRate, stats.Threads)
+	return nil
 }
 
 // ValidateTransactions checks the validity of all transactions in the block
@@ -91,19 +99,6 @@ func (block *Block) ValidateTransactions() error {
 
 // VerifyTransaction validates a transaction's signature, hash, and data
 func VerifyTransaction(tx *Transaction) bool {
-	publicKey, err := wallet.DecodePublicKey(tx.Sender)
-	if err != nil {
-		fmt.Printf("Failed to decode public key: %v\n", err)
-		return false
-	}
-
-	// Verify the signature
-	isValid := wallet.VerifySignature(publicKey, tx.Signature, []byte(tx.Hash))
-	if !isValid {
-		fmt.Println("Signature verification failed for transaction:", tx)
-		return false
-	}
-
 	// Ensure the hash matches the calculated hash
 	calculatedHash := tx.calculateHash()
 	if tx.Hash != calculatedHash {
@@ -111,6 +106,12 @@ func VerifyTransaction(tx *Transaction) bool {
 		return false
 	}
 
+	// Verify the signature
+	if err := tx.verifySignature(); err != nil {
+		fmt.Println("Signature verification failed:", err)
+		return false
+	}
+
 	return true
 }
 
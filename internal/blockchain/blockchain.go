@@ -1,66 +1,79 @@
 package blockchain
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/big"
-	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
 	"sync"
 	"time"
 	"tpy-blockchain/internal/common"
+	"tpy-blockchain/internal/consensus"
+	"tpy-blockchain/internal/filters"
+	"tpy-blockchain/internal/index"
+	"tpy-blockchain/internal/storage"
 	"tpy-blockchain/internal/wallet"
 )
 
 type Blockchain struct {
 	Blocks        []*Block                 `json:"blocks"`
 	Wallets       map[string]*wallet.Wallet `json:"wallets"`
-	Tokens        map[string]*common.UtilityToken `json:"tokens"`
-	Balances      map[string]*big.Int      `json:"balances"`      // Add Balances
-	Transactions  []*Transaction           `json:"transactions"` // Add Transactions
+	*State                                 // Balances and tokens derived from the blocks
+	Transactions  []*Transaction           `json:"transactions"` // Pending transactions not yet in a block
+	filters       []*filters.BlockFilter
+	indexes       *index.Index
+	auditEachBlock bool
+	engine        consensus.Engine
+	config        *consensus.ChainConfig // Upgrades with their activation heights, see SetChainConfig
+	subscribers   map[chan *Block]bool // Receive every connected block, see SubscribeBlocks
+	orphans       *orphanPool          // Blocks waiting for their parent, see ProcessBlock
+	requestBlock  func(hash string)    // Asks for a missing parent, see SetBlockRequester
+	journalPending bool                // Accepted transactions are journaled, see LoadPending
+	journaled     int                  // Journal entries appended since the pool was last stored
+	derived       map[int]*blockDerived // Of the latest and unsaved blocks by height, see keepDerived
+	snapshot      int                  // Height of the stored state, see snapshotDue
+	snapshotDirty bool                 // Tokens were added since the snapshot
+	snapshotWallets int                // Number of wallets in the snapshot
 	mutex         sync.Mutex
-	blockDir      string
-	blockLimit    int
+	store         storage.ChainStore // Where blocks and derived records are persisted, see Save
+	stored        int                // Number of leading blocks known to be in the store
+	indexed       int                // Number of leading blocks in the store's tables, see storage.IndexedStore
 }
 
-func NewBlockchain() *Blockchain {
-	blockDir := "Blocks"
+// NewBlockchain loads the chain from the Blocks directory, or creates a new
+// one with a genesis block, validating blocks with the given consensus engine
+func NewBlockchain(engine consensus.Engine) (*Blockchain, error) {
+	return NewBlockchainInDir("Blocks", engine)
+}
 
-	// Ensure the Blocks directory exists
-	err := os.MkdirAll(blockDir, 0755)
+// NewBlockchainInDir is NewBlockchain with the chain stored as JSON files in blockDir
+func NewBlockchainInDir(blockDir string, engine consensus.Engine) (*Blockchain, error) {
+	store, err := storage.NewFileStore(blockDir)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to create block directory: %v", err))
+		return nil, fmt.Errorf("failed to open block directory: %v", err)
 	}
-
-	// Check for existing chain files
-	files, err := os.ReadDir(blockDir)
+	bc, err := OpenBlockchain(store, engine)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to read block directory: %v", err))
+		store.Close()
+		return nil, fmt.Errorf("failed to load blockchain: %v", err)
 	}
+	return bc, nil
+}
 
-	var highestChainIndex int
-	for _, file := range files {
-		if strings.HasPrefix(file.Name(), "chain") && strings.HasSuffix(file.Name(), ".json") {
-			chainNumber, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(file.Name(), "chain"), ".json"))
-			if err == nil && chainNumber > highestChainIndex {
-				highestChainIndex = chainNumber
-			}
-		}
+// OpenBlockchain loads the chain from store, or creates a new one with a
+// genesis block if the store is empty, validating blocks with the given
+// consensus engine
+func OpenBlockchain(store storage.ChainStore, engine consensus.Engine) (*Blockchain, error) {
+	height, err := store.Height()
+	if err != nil {
+		return nil, err
 	}
-
-	// Load blockchain if chain files are found
-	if highestChainIndex > 0 {
-		fmt.Printf("Found existing blockchain files up to chain%d.json. Loading...\n", highestChainIndex)
-		bc, err := LoadBlockchainFromFiles(blockDir, highestChainIndex)
-		if err != nil {
-			panic(fmt.Sprintf("Failed to load blockchain: %v", err))
-		}
-		return bc
+	if height >= 0 {
+		fmt.Printf("Found existing blockchain up to block %d. Loading...\n", height)
+		return loadBlockchain(store, height, engine)
 	}
 
-	// Create a new blockchain if no chain files are found
+	// Create a new blockchain if the store is empty
 	genesisBlock := &Block{
 		Index:        0,
 		Timestamp:    time.Now().String(),
@@ -69,25 +82,44 @@ func NewBlockchain() *Blockchain {
 		Tokens:       make(map[string]*common.UtilityToken), // Initialize Tokens
 		Nonce:        0,
 		PreviousHash: "0",
+		MerkleRoot:   TransactionsRoot(nil),
+		StateRoot:    NewState().Root(),
 	}	
+	// A proof-of-work chain records its hash algorithm in the genesis block
+	if pow, ok := engine.(*consensus.ProofOfWork); ok {
+		genesisBlock.Extra = pow.GenesisExtra()
+	}
 	genesisBlock.Hash = CalculateHash(genesisBlock)
 
+	bc, err := newBlockchain(store, engine, genesisBlock)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Genesis block created and saved.")
+	return bc, nil
+}
+
+// newBlockchain creates a chain of the genesis block in an empty store
+func newBlockchain(store storage.ChainStore, engine consensus.Engine, genesisBlock *Block) (*Blockchain, error) {
 	bc := &Blockchain{
-		Blocks:     []*Block{genesisBlock},
-		Balances:   make(map[string]*big.Int),
-		Tokens:     make(map[string]*common.UtilityToken),
-		Wallets:    make(map[string]*wallet.Wallet), // Initialize Wallets
-		blockDir:   blockDir,
-		blockLimit: 1000,
+		Blocks:  []*Block{genesisBlock},
+		State:   NewState(),
+		Wallets: make(map[string]*wallet.Wallet), // Initialize Wallets
+		engine:  engine,
+		store:   store,
+		snapshotDirty: true,
+	}
+	if err := bc.buildFilter(genesisBlock); err != nil {
+		return nil, fmt.Errorf("failed to build genesis filter: %v", err)
 	}
+	bc.indexes = index.New()
+	bc.keepDerived(0, &blockDerived{Filter: bc.filters[0], Holders: bc.indexBlock(genesisBlock, nil)})
 
 	// Save the genesis block
-	if err := bc.SaveBlocksToFile(); err != nil {
-		panic(fmt.Sprintf("Failed to save genesis block: %v", err))
+	if err := bc.saveChain(); err != nil {
+		return nil, fmt.Errorf("failed to save genesis block: %v", err)
 	}
-
-	fmt.Println("Genesis block created and saved as chain1.json.")
-	return bc
+	return bc, nil
 }
 
 func (bc *Blockchain) AddToken(name, symbol string, totalSupply *big.Int, decimals uint) error {
@@ -112,102 +144,150 @@ func (bc *Blockchain) AddToken(name, symbol string, totalSupply *big.Int, decima
 	}
 
 	bc.Tokens[symbol] = token
+	bc.snapshotDirty = true
 	return nil
 }
 
-func LoadBlockchainFromFiles(blockDir string, highestChainIndex int) (*Blockchain, error) {
+// chainState is the stored state record, a snapshot of the state and token
+// holders after the block at Height. Chain files written by earlier versions
+// hold it next to their blocks, along with pending transactions.
+type chainState struct {
+	Height       *int                            `json:"height,omitempty"` // Nil in records of earlier versions, which hold the state at the tip
+	Holders      map[string]map[string]bool      `json:"holders,omitempty"`
+	Tokens       map[string]*common.UtilityToken `json:"tokens"`
+	Wallets      map[string]*wallet.Wallet       `json:"wallets"`
+	Balances     map[string]string               `json:"balances"`
+	Nonces       map[string]uint64               `json:"nonces"`
+	Transactions []*Transaction                  `json:"transactions,omitempty"`
+}
+
+// Keys of the records stored next to the blocks
+const (
+	stateRecord   = "state"
+	filtersRecord = "filters"
+	indexRecord   = "index"
+	pendingRecord = "pending"
+)
+
+// loadBlockchain reads the blocks up to height and the state from store
+func loadBlockchain(store storage.ChainStore, height int, engine consensus.Engine) (*Blockchain, error) {
 	bc := &Blockchain{
-		Blocks:       []*Block{},
+		Blocks:       make([]*Block, 0, height+1),
 		Wallets:      make(map[string]*wallet.Wallet),
-		Tokens:       make(map[string]*common.UtilityToken),
-		Balances:     make(map[string]*big.Int),
+		State:        NewState(),
 		Transactions: []*Transaction{},
-		blockDir:     blockDir,
-		blockLimit:   1000,
+		engine:       engine,
+		store:        store,
 	}
 
-	for i := 1; i <= highestChainIndex; i++ {
-		filename := filepath.Join(blockDir, fmt.Sprintf("chain%d.json", i))
-
-		// Read the file
-		data, err := os.ReadFile(filename)
+	for i := 0; i <= height; i++ {
+		data, err := store.BlockByHeight(i)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read file %s: %v", filename, err)
+			return nil, fmt.Errorf("failed to read block %d: %v", i, err)
 		}
-
-		// Parse the JSON data
-		var loadedData map[string]interface{}
-		if err := json.Unmarshal(data, &loadedData); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal data from file %s: %v", filename, err)
+		var block Block
+		if err := json.Unmarshal(data, &block); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal block %d: %v", i, err)
 		}
-
-		// Load Blocks
-		if blocks, ok := loadedData["blocks"].([]interface{}); ok {
-			for _, blockData := range blocks {
-				blockBytes, _ := json.Marshal(blockData)
-				var block Block
-				if err := json.Unmarshal(blockBytes, &block); err != nil {
-					return nil, fmt.Errorf("failed to unmarshal block: %v", err)
-				}
-				bc.Blocks = append(bc.Blocks, &block)
-			}
+		bc.Blocks = append(bc.Blocks, &block)
+	}
+	if pow, ok := engine.(*consensus.ProofOfWork); ok {
+		if err := pow.VerifyGenesis(bc.Blocks[0].Header()); err != nil {
+			return nil, err
 		}
+	}
 
-		// Load Wallets
-		if wallets, ok := loadedData["wallets"].(map[string]interface{}); ok {
-			for addr, walletData := range wallets {
-				walletBytes, _ := json.Marshal(walletData)
-				var w wallet.Wallet
-				if err := json.Unmarshal(walletBytes, &w); err != nil {
-					return nil, fmt.Errorf("failed to unmarshal wallet: %v", err)
-				}
-				bc.Wallets[addr] = &w
-			}
+	bc.snapshot = len(bc.Blocks) - 1
+	var holders map[string]map[string]bool
+	data, err := store.Get(stateRecord)
+	if err != nil && err != storage.ErrNotFound {
+		return nil, err
+	}
+	if err == nil {
+		var stored chainState
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal state: %v", err)
 		}
-
-		// Load Tokens
-		if tokens, ok := loadedData["tokens"].(map[string]interface{}); ok {
-			for symbol, tokenData := range tokens {
-				tokenBytes, _ := json.Marshal(tokenData)
-				var token common.UtilityToken
-				if err := json.Unmarshal(tokenBytes, &token); err != nil {
-					return nil, fmt.Errorf("failed to unmarshal token: %v", err)
-				}
-				bc.Tokens[symbol] = &token
+		for symbol, token := range stored.Tokens {
+			bc.Tokens[symbol] = token
+		}
+		for address, w := range stored.Wallets {
+			bc.Wallets[address] = w
+		}
+		for address, balance := range stored.Balances {
+			if bal, ok := new(big.Int).SetString(balance, 10); ok {
+				bc.Balances[address] = bal
 			}
 		}
+		for address, nonce := range stored.Nonces {
+			bc.Nonces[address] = nonce
+		}
+		bc.Transactions = append(bc.Transactions, stored.Transactions...)
+		if stored.Height != nil {
+			bc.snapshot = *stored.Height
+		}
+		holders = stored.Holders
+	}
+	bc.snapshotWallets = len(bc.Wallets)
 
-		// Load Balances
-		if balances, ok := loadedData["balances"].(map[string]interface{}); ok {
-			for addr, balance := range balances {
-				if balanceStr, ok := balance.(string); ok {
-					bal := new(big.Int)
-					bal.SetString(balanceStr, 10)
-					bc.Balances[addr] = bal
-				}
-			}
+	// Blocks stored by earlier versions have no derived data, so the filters
+	// and indexes are read from their records, and the blocks written again
+	converted, err := bc.loadDerived(holders)
+	if err != nil {
+		return nil, err
+	}
+	if converted >= 0 {
+		if err := bc.loadFilters(); err != nil {
+			return nil, err
 		}
+		if err := bc.loadIndexes(); err != nil {
+			return nil, err
+		}
+	}
+	if err := bc.loadIndexedHeight(); err != nil {
+		return nil, err
+	}
+	bc.State.height = len(bc.Blocks) - 1
+	if root := bc.State.Root(); root != bc.Blocks[len(bc.Blocks)-1].StateRoot {
+		fmt.Printf("Warning: stored state does not match the state root of block %d; run the reindex command to rebuild it from the blocks.\n", len(bc.Blocks)-1)
+	}
 
-		// Load Transactions
-		if transactions, ok := loadedData["transactions"].([]interface{}); ok {
-			for _, txData := range transactions {
-				txBytes, _ := json.Marshal(txData)
-				var tx Transaction
-				if err := json.Unmarshal(txBytes, &tx); err != nil {
-					return nil, fmt.Errorf("failed to unmarshal transaction: %v", err)
-				}
-				bc.Transactions = append(bc.Transactions, &tx)
-			}
+	// Chain files of earlier versions store blocks without headers, so they
+	// are written again in the current format
+	bc.stored = len(bc.Blocks)
+	_, genesisErr := store.HeaderByHeight(0)
+	_, tipErr := store.HeaderByHeight(height)
+	if genesisErr == storage.ErrNotFound || tipErr == storage.ErrNotFound {
+		converted = 0
+	}
+	if converted >= 0 {
+		bc.stored = converted
+		if err := bc.saveChain(); err != nil {
+			return nil, fmt.Errorf("failed to convert chain files: %v", err)
+		}
+		// Earlier versions kept the undo data of the latest blocks in records
+		batch := store.NewBatch()
+		for i := len(bc.Blocks) - undoDepth; i < len(bc.Blocks); i++ {
+			batch.Delete(fmt.Sprintf("undo-%d", i))
+		}
+		if err := batch.Write(); err != nil {
+			return nil, fmt.Errorf("failed to convert chain files: %v", err)
 		}
+		fmt.Println("Converted stored blocks to the current format.")
 	}
 
-	fmt.Println("Blockchain successfully loaded from chain files.")
+	fmt.Println("Blockchain successfully loaded.")
 	return bc, nil
 }
 
 // Helper function to generate a unique token address
+// generateUniqueTokenAddress derives a token's address from its symbol, so it
+// is known from block data alone
 func generateUniqueTokenAddress(symbol string) string {
-	return fmt.Sprintf("0x%s", symbol[:3])
+	if len(symbol) > 3 {
+		symbol = symbol[:3]
+	}
+	return fmt.Sprintf("0x%s", symbol)
 }
 
 func (bc *Blockchain) GetWallet(address string) (*wallet.Wallet, error) {
@@ -223,26 +303,110 @@ func (bc *Blockchain) GetWallet(address string) (*wallet.Wallet, error) {
 	}, nil
 }
 
+// AddTransaction validates a transaction against the state and the other pending
+// transactions and adds it to the pending list. Balances change once it is in a block.
 func (bc *Blockchain) AddTransaction(transaction *Transaction) error {
-	if transaction.Amount.Sign() <= 0 {
-		return fmt.Errorf("transaction amount must be positive")
+	_, err := bc.SubmitTransaction(transaction)
+	return err
+}
+
+// SubmitTransaction is AddTransaction that also returns the pending
+// transaction it replaced, if any. A transaction with a nonce replaces the
+// pending one with the same sender and nonce if it raises both fees by at
+// least ReplacementFeeBump percent.
+func (bc *Blockchain) SubmitTransaction(transaction *Transaction) (*Transaction, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.addTransaction(transaction)
+}
+
+func (bc *Blockchain) addTransaction(transaction *Transaction) (*Transaction, error) {
+	if transaction.Type == TxTypeMint {
+		return nil, fmt.Errorf("mint transactions can only be created by block producers")
+	}
+	if !hasSender(transaction) {
+		// Unsigned evidence takes the version of the next block
+		transaction.SetVersion(bc.nextTxVersion())
+	} else if transaction.Hash == "" {
+		transaction.Hash = transaction.calculateHash()
+	}
+	if err := checkTxLimits(bc.config, transaction); err != nil {
+		return nil, err
+	}
+	for _, pending := range bc.Transactions {
+		if pending.Hash == transaction.Hash {
+			return nil, fmt.Errorf("transaction %s is already pending", transaction.Hash)
+		}
 	}
 
-	senderBalance, senderExists := bc.Balances[transaction.Sender]
-	if !senderExists || senderBalance.Cmp(transaction.Amount) < 0 {
-		return fmt.Errorf("insufficient balance for sender %s", transaction.Sender)
+	if hasSender(transaction) {
+		if err := transaction.verifySignature(); err != nil {
+			return nil, err
+		}
 	}
 
-	bc.Balances[transaction.Sender].Sub(senderBalance, transaction.Amount)
-	receiverBalance, receiverExists := bc.Balances[transaction.Receiver]
-	if !receiverExists {
-		bc.Balances[transaction.Receiver] = big.NewInt(0)
-		receiverBalance = bc.Balances[transaction.Receiver]
+	replaceAt := -1
+	if hasSender(transaction) {
+		replaceAt = bc.pendingByNonce(transaction.Sender, transaction.Nonce)
+	}
+	if replaceAt >= 0 {
+		if err := checkReplacement(bc.Transactions[replaceAt], transaction); err != nil {
+			return nil, err
+		}
 	}
-	receiverBalance.Add(receiverBalance, transaction.Amount)
 
-	bc.Transactions = append(bc.Transactions, transaction)
-	return nil
+	// A replacement takes the place of the transaction it replaces
+	pendingState := bc.newPendingState()
+	for i, pending := range bc.Transactions {
+		if i == replaceAt {
+			break
+		}
+		pendingState.applyTransaction(pending)
+	}
+	if err := pendingState.applyTransaction(transaction); err != nil {
+		return nil, err
+	}
+
+	var replaced *Transaction
+	if replaceAt < 0 {
+		bc.Transactions = append(bc.Transactions, transaction)
+	} else {
+		replaced = bc.Transactions[replaceAt]
+		fmt.Printf("Transaction %s replaced pending transaction %s\n", transaction.Hash, replaced.Hash)
+
+		// The replacement may spend more than the transaction it replaces,
+		// so the pending transactions after it are checked again and those
+		// that no longer apply are evicted
+		pool := append(append([]*Transaction(nil), bc.Transactions[:replaceAt]...), transaction)
+		for _, pending := range bc.Transactions[replaceAt+1:] {
+			trial := pendingState.Copy()
+			if err := trial.applyTransaction(pending); err != nil {
+				fmt.Printf("Evicted pending transaction %s: %v\n", pending.Hash, err)
+				continue
+			}
+			pendingState = trial
+			pool = append(pool, pending)
+		}
+		bc.Transactions = pool
+	}
+	if err := bc.journalTransaction(transaction); err != nil {
+		fmt.Printf("Pending transaction %s is not journaled: %v\n", transaction.Hash, err)
+	}
+	return replaced, nil
+}
+
+// Height returns the index of the tip
+func (bc *Blockchain) Height() int {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return len(bc.Blocks) - 1
+}
+
+// PendingCount returns the number of pending transactions
+func (bc *Blockchain) PendingCount() int {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return len(bc.Transactions)
 }
 
 func (bc *Blockchain) GetBlockByIndex(index int) (*Block, error) {
@@ -264,112 +428,291 @@ func (bc *Blockchain) GetBalance(address string) (*big.Int, error) {
 	return balance, nil
 }
 
+// AddBlock seals a new block with the given transactions on top of the tip
+// using the consensus engine, paying any block reward to coinbase
 func (bc *Blockchain) AddBlock(transactions []*Transaction) error {
+	return bc.AddBlockWithCoinbase(transactions, "")
+}
+
+// AddBlockWithCoinbase is AddBlock with an explicit reward address
+func (bc *Blockchain) AddBlockWithCoinbase(transactions []*Transaction, coinbase string) error {
 	bc.mutex.Lock()
 	defer bc.mutex.Unlock()
 
-	previousBlock := bc.Blocks[len(bc.Blocks)-1]
-	newBlock := &Block{
-		Index:        len(bc.Blocks),
-		Timestamp:    time.Now().String(),
-		Transactions: transactions,
-		Nonce:        0,
-		PreviousHash: previousBlock.Hash,
+	newBlock, err := bc.buildBlock(context.Background(), transactions, coinbase)
+	if err != nil {
+		return err
 	}
-	newBlock.Hash = CalculateHash(newBlock)
-	bc.Blocks = append(bc.Blocks, newBlock)
+	return bc.connectBlock(newBlock)
+}
+
+// buildBlock prepares, executes and seals a block with the given
+// transactions on top of the tip without connecting it
+func (bc *Blockchain) buildBlock(ctx context.Context, transactions []*Transaction, coinbase string) (*Block, error) {
+	template, err := bc.newTemplate(transactions, coinbase, false)
+	if err != nil {
+		return nil, err
+	}
+	return bc.SealBlock(ctx, template)
+}
+
+// ConnectBlock appends a block that extends the current tip
+func (bc *Blockchain) ConnectBlock(block *Block) error {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	bc.reportDoubleSign(block)
+	return bc.connectBlock(block)
+}
+
+func (bc *Blockchain) connectBlock(block *Block) error {
+	if err := validateBlockLinkage(block, bc.Blocks[len(bc.Blocks)-1]); err != nil {
+		return err
+	}
+	if err := verifyConsensus(bc.engine, bc.view(), block); err != nil {
+		return err
+	}
+
+	newState := bc.State.Copy()
+	if err := newState.applyBlock(block, bc.engine); err != nil {
+		return fmt.Errorf("block %d: %v", block.Index, err)
+	}
+	if newState.Root() != block.StateRoot {
+		return fmt.Errorf("block %d state root mismatch", block.Index)
+	}
+	filter, err := bc.newFilter(block)
+	if err != nil {
+		return err
+	}
+
+	derived := &blockDerived{Filter: filter, Undo: diffState(newState, bc.State), Redo: diffState(bc.State, newState)}
+	bc.Blocks = append(bc.Blocks, block)
+	bc.State = newState
+	bc.filters = append(bc.filters, filter)
+	bc.removePending(block.Transactions)
+	derived.Holders = bc.indexBlock(block, derived.Redo)
+	bc.keepDerived(block.Index, derived)
+	if bc.auditEachBlock {
+		for _, discrepancy := range checkSupply(bc.State) {
+			fmt.Printf("Supply audit failed at block %d: %s\n", block.Index, discrepancy)
+		}
+	}
+	bc.announceBlock(block)
 	return nil
 }
 
+// SetAuditEachBlock enables a supply check after every connected block
+func (bc *Blockchain) SetAuditEachBlock(enabled bool) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	bc.auditEachBlock = enabled
+}
+
+// SetChainConfig sets the upgrades the chain's blocks follow. It fails if a
+// stored block has a lower version than the config requires at its height.
+func (bc *Blockchain) SetChainConfig(config *consensus.ChainConfig) error {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	for _, block := range bc.Blocks {
+		if err := config.VerifyVersion(block.Header()); err != nil {
+			return fmt.Errorf("chain config does not match the stored chain: %v", err)
+		}
+	}
+	bc.config = config
+	bc.State.config = config
+	return nil
+}
+
+// ChainConfig returns the upgrades the chain's blocks follow
+func (bc *Blockchain) ChainConfig() *consensus.ChainConfig {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.config
+}
+
+// DisconnectTip rolls back the latest block, rewinding state, filters and
+// indexes with the block's undo data. Its transactions, except mints, are
+// submitted again ahead of the pending ones, and those that no longer apply
+// are dropped.
+func (bc *Blockchain) DisconnectTip() (*Block, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.disconnectTip()
+}
+
+func (bc *Blockchain) disconnectTip() (*Block, error) {
+	if len(bc.Blocks) == 1 {
+		return nil, fmt.Errorf("cannot disconnect the genesis block")
+	}
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	parent := bc.Blocks[len(bc.Blocks)-2]
+	derived, ok := bc.derived[tip.Index]
+	if !ok || derived.Undo == nil {
+		return nil, fmt.Errorf("no undo data for block %d; only the latest %d blocks can be disconnected, and blocks of earlier versions only after a reindex", tip.Index, undoDepth)
+	}
+
+	st := bc.State.Copy()
+	derived.Undo.apply(st)
+	st.height = parent.Index
+	if st.Root() != parent.StateRoot {
+		return nil, fmt.Errorf("undo data of block %d does not restore the state of block %d", tip.Index, parent.Index)
+	}
+	bc.Blocks = bc.Blocks[:len(bc.Blocks)-1]
+	if bc.stored > len(bc.Blocks) {
+		bc.stored = len(bc.Blocks)
+	}
+	if bc.indexed > len(bc.Blocks) {
+		bc.indexed = len(bc.Blocks)
+	}
+	delete(bc.derived, tip.Index)
+	bc.State = st
+	bc.filters = bc.filters[:len(bc.Blocks)]
+	bc.unindexBlock(tip, derived.Undo)
+
+	pending := bc.Transactions
+	bc.Transactions = []*Transaction{}
+	for _, tx := range append(append([]*Transaction(nil), tip.Transactions...), pending...) {
+		if tx.Type == TxTypeMint {
+			continue
+		}
+		if _, err := bc.addTransaction(tx); err != nil {
+			fmt.Printf("Dropped pending transaction %s: %v\n", tx.Hash, err)
+		}
+	}
+	return tip, nil
+}
+
+// newPendingState returns a copy of the state set up for the next block,
+// which pending transactions are checked as part of. The author is not known
+// before the block is produced.
+func (bc *Blockchain) newPendingState() *State {
+	pendingState := bc.State.Copy()
+	pendingState.height = len(bc.Blocks)
+	pendingState.baseFee = calcBaseFee(bc.config, bc.Blocks[len(bc.Blocks)-1])
+	pendingState.author = ""
+	return pendingState
+}
+
+// removePending drops transactions included in a block from the pending list,
+// along with those whose nonce the block used up
+func (bc *Blockchain) removePending(included []*Transaction) {
+	hashes := make(map[string]bool, len(included))
+	for _, tx := range included {
+		hashes[tx.Hash] = true
+	}
+	pending := bc.Transactions[:0]
+	for _, tx := range bc.Transactions {
+		if !hashes[tx.Hash] && (!hasSender(tx) || tx.Nonce > bc.Nonces[tx.Sender]) {
+			pending = append(pending, tx)
+		}
+	}
+	bc.Transactions = pending
+}
+
 func (bc *Blockchain) IsValid() bool {
 	for i := 1; i < len(bc.Blocks); i++ {
-		currentBlock := bc.Blocks[i]
-		previousBlock := bc.Blocks[i-1]
-		if currentBlock.PreviousHash != previousBlock.Hash || currentBlock.Hash != CalculateHash(currentBlock) {
+		if validateBlockLinkage(bc.Blocks[i], bc.Blocks[i-1]) != nil {
 			return false
 		}
 	}
 	return true
 }
 
-func (bc *Blockchain) SaveBlocksToFile() error {
-	startIndex := len(bc.Blocks) - bc.blockLimit
-	if startIndex < 0 {
-		startIndex = 0
-	}
-	blocksToSave := bc.Blocks[startIndex:]
+// Save writes the chain to disk while holding the chain's lock, for callers
+// that run alongside other users of the chain
+func (bc *Blockchain) Save() error {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.saveChain()
+}
 
-	dataToSave := map[string]interface{}{
-		"blocks":  blocksToSave,
-		"tokens":  bc.Tokens,   // Serialize tokens globally
-		"wallets": bc.Wallets,  // Serialize wallets globally
+// saveChain writes the blocks missing from the store, each with its filter,
+// holder changes and state changes, in one batch. The state is stored as a
+// snapshot when one is due, and the pending transactions in place of their
+// journal once it grows past journalLimit. A store with tables also gets the
+// rows of the blocks missing from them.
+func (bc *Blockchain) saveChain() error {
+	batch := bc.store.NewBatch()
+	batch.Truncate(bc.stored - 1)
+	for _, block := range bc.Blocks[bc.stored:] {
+		derived, ok := bc.derived[block.Index]
+		if !ok {
+			// Blocks of earlier versions only get their filter
+			derived = &blockDerived{Filter: bc.filters[block.Index]}
+		}
+		if err := putBlock(batch, block, derived); err != nil {
+			return err
+		}
 	}
 
-	fileIndex := (len(bc.Blocks)-1)/bc.blockLimit + 1
-	filename := filepath.Join(bc.blockDir, fmt.Sprintf("chain%d.json", fileIndex))
-
-	data, err := json.MarshalIndent(dataToSave, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal blockchain data: %v", err)
+	snapshot := bc.snapshotDue()
+	if snapshot {
+		if err := bc.putSnapshot(batch); err != nil {
+			return err
+		}
+	}
+	compact := bc.journalPending && bc.journaled >= journalLimit
+	if compact {
+		if err := bc.compactJournal(batch); err != nil {
+			return err
+		}
+	}
+	indexBatch, indexed := batch.(storage.IndexBatch)
+	if indexed {
+		bc.writeIndexed(indexBatch)
 	}
 
-	if err := os.WriteFile(filename, data, 0644); err != nil {
-		return fmt.Errorf("failed to write to file %s: %v", filename, err)
+	if err := batch.Write(); err != nil {
+		return fmt.Errorf("failed to save chain: %v", err)
+	}
+	bc.stored = len(bc.Blocks)
+	if indexed {
+		bc.indexed = len(bc.Blocks)
+	}
+	if snapshot {
+		bc.snapshot = len(bc.Blocks) - 1
+		bc.snapshotDirty = false
+		bc.snapshotWallets = len(bc.Wallets)
 	}
+	if compact {
+		bc.journaled = 0
+	}
+	bc.pruneDerived()
 	return nil
 }
 
-func (bc *Blockchain) LoadBlocks() error {
-	files, err := os.ReadDir(bc.blockDir)
+// putBlock adds a block, its header and what was derived from it to a batch
+func putBlock(batch storage.Batch, block *Block, derived *blockDerived) error {
+	header, err := json.Marshal(block.Header())
 	if err != nil {
-		return fmt.Errorf("failed to read block directory: %v", err)
+		return fmt.Errorf("failed to marshal header of block %d: %v", block.Index, err)
 	}
+	data, err := json.Marshal(block)
+	if err != nil {
+		return fmt.Errorf("failed to marshal block %d: %v", block.Index, err)
+	}
+	derivedData, err := json.Marshal(derived)
+	if err != nil {
+		return fmt.Errorf("failed to marshal data derived from block %d: %v", block.Index, err)
+	}
+	batch.PutBlock(block.Index, block.Hash, header, data, derivedData)
+	return nil
+}
 
-	for _, file := range files {
-		if filepath.Ext(file.Name()) == ".json" {
-			filename := filepath.Join(bc.blockDir, file.Name())
-
-			// Read the file
-			data, err := os.ReadFile(filename)
-			if err != nil {
-				return fmt.Errorf("failed to read file %s: %v", filename, err)
-			}
-
-			// Unmarshal the blocks, tokens, and wallets
-			var dataToLoad map[string]interface{}
-			if err := json.Unmarshal(data, &dataToLoad); err != nil {
-				return fmt.Errorf("failed to unmarshal data from file %s: %v", filename, err)
-			}
-
-			// Load blocks
-			var loadedBlocks []*Block
-			blocksData, _ := dataToLoad["blocks"].([]interface{})
-			for _, block := range blocksData {
-				blockBytes, _ := json.Marshal(block)
-				var b Block
-				json.Unmarshal(blockBytes, &b)
-				loadedBlocks = append(loadedBlocks, &b)
-			}
-			bc.Blocks = append(bc.Blocks, loadedBlocks...)
-
-			// Load tokens
-			tokensData, _ := dataToLoad["tokens"].(map[string]interface{})
-			for symbol, tokenData := range tokensData {
-				tokenBytes, _ := json.Marshal(tokenData)
-				var token common.UtilityToken
-				json.Unmarshal(tokenBytes, &token)
-				bc.Tokens[symbol] = &token
-			}
-
-			// Load wallets
-			walletsData, _ := dataToLoad["wallets"].(map[string]interface{})
-			for address, walletData := range walletsData {
-				walletBytes, _ := json.Marshal(walletData)
-				var w wallet.Wallet
-				json.Unmarshal(walletBytes, &w)
-				bc.Wallets[address] = &w
-			}
-		}
+// putRecord adds a record encoded as JSON to a batch
+func putRecord(batch storage.Batch, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", key, err)
 	}
+	batch.Put(key, data)
 	return nil
 }
+
+// Close closes the chain's store
+func (bc *Blockchain) Close() error {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.store.Close()
+}
@@ -0,0 +1,181 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+	"tpy-blockchain/internal/consensus"
+	"tpy-blockchain/internal/storage"
+	"tpy-blockchain/internal/wallet"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+func newTestWallet(t *testing.T) *wallet.Wallet {
+	t.Helper()
+	entropy, err := bip39.NewEntropy(128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := wallet.RecoverWallet(mnemonic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// newTestEngine returns a proof of work engine that seals at once and pays a block reward
+func newTestEngine() *consensus.ProofOfWork {
+	pow := consensus.NewProofOfWork(1)
+	pow.Reward = big.NewInt(1000)
+	return pow
+}
+
+func newTestChain(t *testing.T, dir string, engine consensus.Engine) *Blockchain {
+	t.Helper()
+	bc, err := NewBlockchainInDir(dir, engine)
+	if err != nil {
+		t.Fatalf("failed to open chain: %v", err)
+	}
+	t.Cleanup(func() { bc.Close() })
+	return bc
+}
+
+// signedTransfer returns a transfer of the native token that applies next on bc
+func signedTransfer(t *testing.T, bc *Blockchain, from *wallet.Wallet, to string, amount int64) *Transaction {
+	t.Helper()
+	tx := NewUnsignedTransaction(TxTypeTransfer, from.Address, to, big.NewInt(amount), "", "")
+	tx.SetVersion(bc.TxVersion())
+	tx.SetNonce(bc.NextNonce(from.Address))
+	bc.FillFees(tx)
+	if err := tx.Sign(from); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func addBlock(t *testing.T, bc *Blockchain, transactions []*Transaction, coinbase string) *Block {
+	t.Helper()
+	if err := bc.AddBlockWithCoinbase(transactions, coinbase); err != nil {
+		t.Fatalf("failed to add block %d: %v", len(bc.Blocks), err)
+	}
+	return bc.Blocks[len(bc.Blocks)-1]
+}
+
+func TestDisconnectAndReconnectRestoreStateRoot(t *testing.T) {
+	bc := newTestChain(t, t.TempDir(), newTestEngine())
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	addBlock(t, bc, nil, alice.Address)
+	parent := addBlock(t, bc, nil, alice.Address)
+	tip := addBlock(t, bc, []*Transaction{signedTransfer(t, bc, alice, bob.Address, 5)}, bob.Address)
+
+	disconnected, err := bc.DisconnectTip()
+	if err != nil {
+		t.Fatalf("failed to disconnect tip: %v", err)
+	}
+	if disconnected.Hash != tip.Hash {
+		t.Fatalf("disconnected block %s, expected the tip %s", disconnected.Hash, tip.Hash)
+	}
+	if root := bc.State.Root(); root != parent.StateRoot {
+		t.Fatalf("state root after undo is %s, expected %s", root, parent.StateRoot)
+	}
+	if balance := bc.Balances[bob.Address]; balance != nil && balance.Sign() != 0 {
+		t.Fatalf("receiver keeps balance %s after undo", balance)
+	}
+	if len(bc.Transactions) != 1 || bc.Transactions[0].Hash != tip.Transactions[1].Hash {
+		t.Fatalf("transfer of the disconnected block is not pending again: %v", bc.Transactions)
+	}
+
+	if err := bc.ConnectBlock(disconnected); err != nil {
+		t.Fatalf("failed to reconnect block: %v", err)
+	}
+	if root := bc.State.Root(); root != tip.StateRoot {
+		t.Fatalf("state root after redo is %s, expected %s", root, tip.StateRoot)
+	}
+	if len(bc.Transactions) != 0 {
+		t.Fatalf("%d transactions stay pending after reconnecting their block", len(bc.Transactions))
+	}
+}
+
+func TestReloadAppliesRedoAfterSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	bc := newTestChain(t, dir, newTestEngine())
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	addBlock(t, bc, nil, alice.Address)
+	if err := bc.Save(); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := bc.snapshot
+	for i := 0; i < 3; i++ {
+		addBlock(t, bc, []*Transaction{signedTransfer(t, bc, alice, bob.Address, 5)}, alice.Address)
+		if err := bc.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bc.snapshot != snapshot {
+		t.Fatalf("saving a few blocks stored the state at %d, expected it to stay at %d", bc.snapshot, snapshot)
+	}
+	root := bc.State.Root()
+	bc.Close()
+
+	bc = newTestChain(t, dir, newTestEngine())
+	if bc.Height() != 4 {
+		t.Fatalf("reloaded chain has height %d, expected 4", bc.Height())
+	}
+	if bc.State.Root() != root {
+		t.Fatalf("state root after reload is %s, expected %s", bc.State.Root(), root)
+	}
+	if _, err := bc.DisconnectTip(); err != nil {
+		t.Fatalf("failed to disconnect a reloaded block: %v", err)
+	}
+}
+
+func TestReorgToHeavierBranch(t *testing.T) {
+	engine := newTestEngine()
+	dir := t.TempDir()
+	a := newTestChain(t, dir, engine)
+	b, err := OpenBlockchainWithGenesis(storage.NewMemoryStore(), engine, a.Blocks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	for i := 0; i < 2; i++ {
+		addBlock(t, a, nil, alice.Address)
+	}
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		addBlock(t, b, nil, bob.Address)
+	}
+	addBlock(t, b, []*Transaction{signedTransfer(t, b, bob, alice.Address, 5)}, bob.Address)
+
+	// The tip arrives first and waits as an orphan, the next block is a
+	// lighter side branch, and the one completing the branch reorganizes
+	for _, height := range []int{3, 1, 2} {
+		if _, err := a.ProcessBlock(b.Blocks[height]); err != nil {
+			t.Fatalf("failed to process block %d: %v", height, err)
+		}
+	}
+	if a.Height() != 3 || a.Blocks[3].Hash != b.Blocks[3].Hash {
+		t.Fatalf("chain did not switch to the heavier branch")
+	}
+	if a.State.Root() != b.State.Root() {
+		t.Fatalf("state root after the reorg is %s, expected %s", a.State.Root(), b.State.Root())
+	}
+	if balance := a.Balances[alice.Address]; balance == nil || balance.Cmp(big.NewInt(5)) != 0 {
+		t.Fatalf("rewards of the abandoned branch were not undone, balance is %v", balance)
+	}
+
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	a = newTestChain(t, dir, engine)
+	if a.Height() != 3 || a.Blocks[3].Hash != b.Blocks[3].Hash || a.State.Root() != b.State.Root() {
+		t.Fatalf("reorganized chain did not reload")
+	}
+}
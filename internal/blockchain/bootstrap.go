@@ -0,0 +1,335 @@
+package blockchain
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"tpy-blockchain/internal/consensus"
+	"tpy-blockchain/internal/storage"
+	"tpy-blockchain/internal/wallet"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// A bootstrap file is a gzip stream of length-prefixed JSON records: a
+// BootstrapHeader followed by the blocks it lists in order. Nothing in the
+// file is trusted: it is only read once its SHA-256 digest matches a trusted
+// one, given by the user or by a BootstrapSignature of a trusted signer.
+const (
+	bootstrapVersion   = 1
+	maxBootstrapRecord = 64 << 20 // Largest record accepted on import
+	importSaveInterval = 500      // Blocks imported between saves
+)
+
+// BootstrapHeader describes the blocks of a bootstrap file
+type BootstrapHeader struct {
+	Version int    `json:"version"`
+	Genesis string `json:"genesis"` // Hash of the chain's genesis block
+	From    int    `json:"from"`
+	To      int    `json:"to"`
+}
+
+// BootstrapSignature is the detached signature of a bootstrap file
+type BootstrapSignature struct {
+	Digest    string `json:"digest"`    // Hex-encoded SHA-256 of the file
+	Signer    string `json:"signer"`    // Address of the signing wallet
+	Signature string `json:"signature"` // Of Digest, see wallet.Sign
+}
+
+// SignBootstrap signs the digest of a bootstrap file with a wallet
+func SignBootstrap(digest string, w *wallet.Wallet) (*BootstrapSignature, error) {
+	signature, err := w.Sign([]byte(digest))
+	if err != nil {
+		return nil, err
+	}
+	return &BootstrapSignature{Digest: digest, Signer: w.Address, Signature: signature}, nil
+}
+
+// Verify checks that the signature is by one of the trusted signers and
+// returns the digest it signs
+func (sig *BootstrapSignature) Verify(trusted []string) (string, error) {
+	signature, err := hex.DecodeString(sig.Signature)
+	if err != nil || len(signature) != crypto.SignatureLength {
+		return "", fmt.Errorf("malformed bootstrap signature")
+	}
+	pubKey, err := crypto.SigToPub(crypto.Keccak256([]byte(sig.Digest)), signature)
+	if err != nil {
+		return "", fmt.Errorf("bootstrap signature recovery failed: %v", err)
+	}
+	signer := crypto.PubkeyToAddress(*pubKey).Hex()
+	if !strings.EqualFold(signer, sig.Signer) {
+		return "", fmt.Errorf("bootstrap signature is by %s, not %s", signer, sig.Signer)
+	}
+	for _, address := range trusted {
+		if strings.EqualFold(address, signer) {
+			return sig.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("bootstrap file is signed by %s, which is not a trusted signer", signer)
+}
+
+// BootstrapDigest returns the hex-encoded SHA-256 digest of a bootstrap file
+func BootstrapDigest(r io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", fmt.Errorf("failed to read bootstrap file: %v", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyBootstrapDigest checks a bootstrap file against a trusted digest and
+// rewinds it to its start
+func verifyBootstrapDigest(r io.ReadSeeker, digest string) error {
+	actual, err := BootstrapDigest(r)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, digest) {
+		return fmt.Errorf("bootstrap file has digest %s, not the trusted %s", actual, digest)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read bootstrap file: %v", err)
+	}
+	return nil
+}
+
+// ImportResult counts the blocks of a bootstrap file by what an import did with them
+type ImportResult struct {
+	Skipped  int // Already in the chain
+	Imported int
+}
+
+// ExportBootstrap writes the blocks from height from to height to as a
+// bootstrap file and returns its digest
+func (bc *Blockchain) ExportBootstrap(w io.Writer, from, to int) (string, error) {
+	bc.mutex.Lock()
+	if from < 0 || to < from || to >= len(bc.Blocks) {
+		bc.mutex.Unlock()
+		return "", fmt.Errorf("invalid block range %d to %d, the chain has %d blocks", from, to, len(bc.Blocks))
+	}
+	// Connected blocks don't change, so they are written without the lock
+	blocks := append([]*Block(nil), bc.Blocks[from:to+1]...)
+	header := &BootstrapHeader{Version: bootstrapVersion, Genesis: bc.Blocks[0].Hash, From: from, To: to}
+	bc.mutex.Unlock()
+
+	hash := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(w, hash))
+	if err := writeBootstrapRecord(zw, header); err != nil {
+		return "", err
+	}
+	for _, block := range blocks {
+		if err := writeBootstrapRecord(zw, block); err != nil {
+			return "", err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return "", fmt.Errorf("failed to write bootstrap file: %v", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func writeBootstrapRecord(w io.Writer, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bootstrap record: %v", err)
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	if _, err := w.Write(length[:]); err != nil {
+		return fmt.Errorf("failed to write bootstrap file: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write bootstrap file: %v", err)
+	}
+	return nil
+}
+
+// bootstrapReader reads the records of a bootstrap file
+type bootstrapReader struct {
+	r      *bufio.Reader
+	header *BootstrapHeader
+	next   int // Height of the next block
+}
+
+// newBootstrapReader opens a bootstrap file and reads its header
+func newBootstrapReader(r io.Reader) (*bootstrapReader, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a bootstrap file: %v", err)
+	}
+	br := &bootstrapReader{r: bufio.NewReader(zr), header: &BootstrapHeader{}}
+	if err := br.readRecord(br.header); err != nil {
+		return nil, fmt.Errorf("failed to read bootstrap header: %v", err)
+	}
+	if br.header.Version != bootstrapVersion {
+		return nil, fmt.Errorf("unsupported bootstrap file version %d", br.header.Version)
+	}
+	if br.header.From < 0 || br.header.To < br.header.From {
+		return nil, fmt.Errorf("bootstrap file lists an invalid block range %d to %d", br.header.From, br.header.To)
+	}
+	br.next = br.header.From
+	return br, nil
+}
+
+func (br *bootstrapReader) readRecord(value interface{}) error {
+	var length [4]byte
+	if _, err := io.ReadFull(br.r, length[:]); err != nil {
+		return err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > maxBootstrapRecord {
+		return fmt.Errorf("record of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(br.r, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// nextBlock returns the next block of the file, nil after the last one. A
+// file that ends early or has data after the last block is rejected.
+func (br *bootstrapReader) nextBlock() (*Block, error) {
+	if br.next > br.header.To {
+		if _, err := br.r.ReadByte(); err != io.EOF {
+			return nil, fmt.Errorf("bootstrap file has data after block %d", br.header.To)
+		}
+		return nil, nil
+	}
+	var block Block
+	if err := br.readRecord(&block); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("bootstrap file ends before block %d", br.next)
+		}
+		return nil, fmt.Errorf("failed to read block %d: %v", br.next, err)
+	}
+	if block.Index != br.next {
+		return nil, fmt.Errorf("bootstrap file lists block %d where block %d belongs", block.Index, br.next)
+	}
+	br.next++
+	return &block, nil
+}
+
+// ReadBootstrapGenesis returns the genesis block of a bootstrap file that
+// starts at genesis, once the file matches the trusted digest
+func ReadBootstrapGenesis(r io.ReadSeeker, digest string) (*Block, error) {
+	if err := verifyBootstrapDigest(r, digest); err != nil {
+		return nil, err
+	}
+	br, err := newBootstrapReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if br.header.From != 0 {
+		return nil, fmt.Errorf("bootstrap file starts at block %d, not at genesis", br.header.From)
+	}
+	genesis, err := br.nextBlock()
+	if err != nil {
+		return nil, err
+	}
+	if genesis.Hash != br.header.Genesis {
+		return nil, fmt.Errorf("bootstrap file names genesis %s but holds %s", br.header.Genesis, genesis.Hash)
+	}
+	return genesis, nil
+}
+
+// OpenBlockchainWithGenesis creates a chain of the given genesis block, for
+// example one read with ReadBootstrapGenesis, in an empty store
+func OpenBlockchainWithGenesis(store storage.ChainStore, engine consensus.Engine, genesis *Block) (*Blockchain, error) {
+	height, err := store.Height()
+	if err != nil {
+		return nil, err
+	}
+	if height >= 0 {
+		return nil, fmt.Errorf("store already holds a chain up to block %d", height)
+	}
+	if genesis.Index != 0 || genesis.PreviousHash != "0" || len(genesis.Transactions) != 0 {
+		return nil, fmt.Errorf("block %d is not a genesis block", genesis.Index)
+	}
+	if genesis.Hash != CalculateHash(genesis) || genesis.MerkleRoot != TransactionsRoot(nil) || genesis.StateRoot != NewState().Root() {
+		return nil, fmt.Errorf("invalid genesis block %s", genesis.Hash)
+	}
+	if pow, ok := engine.(*consensus.ProofOfWork); ok {
+		if err := pow.VerifyGenesis(genesis.Header()); err != nil {
+			return nil, err
+		}
+	}
+
+	bc, err := newBlockchain(store, engine, genesis)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Genesis block %s saved.\n", genesis.Hash)
+	return bc, nil
+}
+
+// ImportBootstrap connects the blocks of a bootstrap file, validating each
+// one like a block received from a peer. Nothing is read from the file
+// before it matches the trusted digest. Blocks already in the chain are
+// skipped if they match, so an interrupted import is resumed by importing
+// the same file again. The chain is saved every importSaveInterval blocks
+// and when the import stops, also when ctx is cancelled. progress, if set,
+// is called after each imported block.
+func (bc *Blockchain) ImportBootstrap(ctx context.Context, r io.ReadSeeker, digest string, progress func(block *Block)) (*ImportResult, error) {
+	if err := verifyBootstrapDigest(r, digest); err != nil {
+		return nil, err
+	}
+	br, err := newBootstrapReader(r)
+	if err != nil {
+		return nil, err
+	}
+	if genesis := bc.GetBlocks()[0]; br.header.Genesis != genesis.Hash {
+		return nil, fmt.Errorf("bootstrap file is for the chain with genesis %s, not %s", br.header.Genesis, genesis.Hash)
+	}
+	if height := bc.Height(); br.header.From > height+1 {
+		return nil, fmt.Errorf("bootstrap file starts at block %d, but the chain ends at block %d", br.header.From, height)
+	}
+
+	result := &ImportResult{}
+	err = bc.importBlocks(ctx, br, result, progress)
+	if result.Imported > 0 {
+		if saveErr := bc.Save(); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}
+	return result, err
+}
+
+func (bc *Blockchain) importBlocks(ctx context.Context, br *bootstrapReader, result *ImportResult, progress func(block *Block)) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		block, err := br.nextBlock()
+		if err != nil || block == nil {
+			return err
+		}
+
+		if existing, err := bc.GetBlockByIndex(block.Index); err == nil {
+			if existing.Hash != block.Hash {
+				return fmt.Errorf("block %d of the bootstrap file is %s, but the chain has %s", block.Index, block.Hash, existing.Hash)
+			}
+			result.Skipped++
+			continue
+		}
+		if err := bc.ConnectBlock(block); err != nil {
+			return fmt.Errorf("block %d failed validation: %v", block.Index, err)
+		}
+		result.Imported++
+		if progress != nil {
+			progress(block)
+		}
+		if result.Imported%importSaveInterval == 0 {
+			if err := bc.Save(); err != nil {
+				return err
+			}
+		}
+	}
+}
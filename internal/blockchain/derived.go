@@ -0,0 +1,129 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"tpy-blockchain/internal/filters"
+	"tpy-blockchain/internal/index"
+	"tpy-blockchain/internal/storage"
+)
+
+// snapshotInterval is how many blocks a save may add before it stores the
+// state again. The state of the blocks since is loaded from their redo data.
+const snapshotInterval = 100
+
+// blockDerived is what the chain derives from a block. It is stored with the
+// block, so a save writes only the new blocks' share of the filters, indexes
+// and state.
+type blockDerived struct {
+	Filter  *filters.BlockFilter `json:"filter"`
+	Holders []*holderChange      `json:"holders,omitempty"` // Holder status the block set
+	Undo    *stateDiff           `json:"undo,omitempty"`    // Turns the state after the block into the one before
+	Redo    *stateDiff           `json:"redo,omitempty"`    // Turns the state before the block into the one after
+}
+
+// holderChange is whether an address holds a token after a block
+type holderChange struct {
+	Token   string `json:"token"`
+	Address string `json:"address"`
+	Holds   bool   `json:"holds"`
+}
+
+// keepDerived holds what was derived from a connected block until it is
+// stored, and while the block is among the latest undoDepth
+func (bc *Blockchain) keepDerived(height int, derived *blockDerived) {
+	if bc.derived == nil {
+		bc.derived = make(map[int]*blockDerived)
+	}
+	bc.derived[height] = derived
+	bc.pruneDerived()
+}
+
+// pruneDerived drops what was derived from stored blocks below the latest undoDepth
+func (bc *Blockchain) pruneDerived() {
+	for height := range bc.derived {
+		if height < bc.stored && height < len(bc.Blocks)-undoDepth {
+			delete(bc.derived, height)
+		}
+	}
+}
+
+// loadDerived reads the filters, indexes and undo data from what was stored
+// with each block, and brings the state from the snapshot up to the tip with
+// the redo data of the blocks after it. It returns the height of the first
+// block stored without derived data by an earlier version, or -1.
+func (bc *Blockchain) loadDerived(holders map[string]map[string]bool) (int, error) {
+	bc.filters = nil
+	bc.indexes = index.New()
+	for symbol, addresses := range holders {
+		for address := range addresses {
+			bc.indexes.SetHolder(symbol, address, true)
+		}
+	}
+	bc.derived = make(map[int]*blockDerived)
+
+	for _, block := range bc.Blocks {
+		data, err := bc.store.DerivedByHeight(block.Index)
+		if err == storage.ErrNotFound {
+			return block.Index, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read data derived from block %d: %v", block.Index, err)
+		}
+		var derived blockDerived
+		if err := json.Unmarshal(data, &derived); err != nil {
+			return 0, fmt.Errorf("failed to unmarshal data derived from block %d: %v", block.Index, err)
+		}
+		if derived.Filter == nil || derived.Filter.BlockIndex != block.Index || derived.Filter.BlockHash != block.Hash {
+			return 0, fmt.Errorf("data derived from block %d does not match the block", block.Index)
+		}
+
+		bc.filters = append(bc.filters, derived.Filter)
+		bc.indexTxs(block)
+		if block.Index > bc.snapshot {
+			bc.setHolders(derived.Holders)
+			// A block without redo data leaves the state behind, which the
+			// state root check reports
+			if derived.Redo != nil {
+				derived.Redo.apply(bc.State)
+			}
+		}
+		if block.Index >= len(bc.Blocks)-undoDepth {
+			bc.derived[block.Index] = &derived
+		}
+	}
+	bc.indexes.Height = len(bc.Blocks) - 1
+	return -1, nil
+}
+
+// snapshotDue reports whether a save has to store the state: every
+// snapshotInterval blocks, when a block at or below the snapshot is replaced,
+// and when tokens or wallets were added outside of blocks
+func (bc *Blockchain) snapshotDue() bool {
+	return bc.snapshotDirty || bc.stored <= bc.snapshot ||
+		len(bc.Blocks)-1 >= bc.snapshot+snapshotInterval || len(bc.Wallets) != bc.snapshotWallets
+}
+
+// putSnapshot adds the state at the tip, with the token holders, to a batch
+func (bc *Blockchain) putSnapshot(batch storage.Batch) error {
+	balances := make(map[string]string, len(bc.Balances))
+	for address, balance := range bc.Balances {
+		balances[address] = balance.String()
+	}
+	height := len(bc.Blocks) - 1
+	state := &chainState{
+		Height:   &height,
+		Tokens:   bc.Tokens,
+		Wallets:  bc.Wallets,
+		Balances: balances,
+		Nonces:   bc.Nonces,
+		Holders:  bc.indexes.Holders,
+	}
+	if err := putRecord(batch, stateRecord, state); err != nil {
+		return err
+	}
+	// Earlier versions kept all filters and indexes in these records
+	batch.Delete(filtersRecord)
+	batch.Delete(indexRecord)
+	return nil
+}
@@ -0,0 +1,146 @@
+package blockchain
+
+import (
+	"fmt"
+	"tpy-blockchain/internal/consensus"
+)
+
+// chainView exposes a sequence of blocks and the state after the last of
+// them to consensus engines. It does not lock, so views of the chain are only
+// used while the chain's mutex is held.
+type chainView struct {
+	blocks []*Block
+	state  *State
+}
+
+// view returns a view of the whole chain
+func (bc *Blockchain) view() chainView {
+	return chainView{blocks: bc.Blocks, state: bc.State}
+}
+
+func (v chainView) CurrentHeader() *consensus.Header {
+	if len(v.blocks) == 0 {
+		return nil
+	}
+	return v.blocks[len(v.blocks)-1].Header()
+}
+
+func (v chainView) GetHeaderByIndex(index int) *consensus.Header {
+	if index < 0 || index >= len(v.blocks) {
+		return nil
+	}
+	return v.blocks[index].Header()
+}
+
+func (v chainView) GetHeaderByHash(hash string) *consensus.Header {
+	for i := len(v.blocks) - 1; i >= 0; i-- {
+		if v.blocks[i].Hash == hash {
+			return v.blocks[i].Header()
+		}
+	}
+	return nil
+}
+
+func (v chainView) Config() *consensus.ChainConfig {
+	return v.state.config
+}
+
+// Validators returns the active validators of a token, weighted by the stake bonded to them
+func (v chainView) Validators(tokenSymbol string) []*consensus.Validator {
+	token, ok := v.state.Tokens[tokenSymbol]
+	if !ok {
+		return nil
+	}
+	stakers := token.Stakers()
+	validators := make([]*consensus.Validator, 0, len(stakers))
+	for _, address := range stakers {
+		validators = append(validators, &consensus.Validator{Address: address, Power: token.ValidatorPower(address)})
+	}
+	return validators
+}
+
+// Engine returns the consensus engine the chain validates blocks with
+func (bc *Blockchain) Engine() consensus.Engine {
+	return bc.engine
+}
+
+// rewardTransactions turns the engine's rewards for a header into mint
+// transactions of the given version
+func rewardTransactions(rewards []*consensus.Reward, version int) []*Transaction {
+	transactions := make([]*Transaction, 0, len(rewards))
+	for _, reward := range rewards {
+		data := ""
+		if reward.Staking {
+			data = MintDataStakingReward
+		}
+		tx := NewUnsignedTransaction(TxTypeMint, "", reward.Address, reward.Amount, reward.TokenSymbol, data)
+		if version != TxVersionLegacy {
+			tx.SetVersion(version)
+		}
+		transactions = append(transactions, tx)
+	}
+	return transactions
+}
+
+// verifyConsensus checks a block's version and resource limits, and its
+// header and rewards with the engine. The view holds the blocks before it.
+func verifyConsensus(engine consensus.Engine, view chainView, block *Block) error {
+	return verifyBlockRules(engine, view, block, engine.VerifyHeader)
+}
+
+// verifyBlockRules is verifyConsensus with the engine's header check
+// replaced by verifyHeader
+func verifyBlockRules(engine consensus.Engine, view chainView, block *Block, verifyHeader func(chain consensus.ChainReader, header *consensus.Header) error) error {
+	if err := view.Config().VerifyVersion(block.Header()); err != nil {
+		return err
+	}
+	if err := checkBlockLimits(view.Config(), block); err != nil {
+		return err
+	}
+	if err := verifyBaseFee(view.Config(), view.blocks[len(view.blocks)-1], block); err != nil {
+		return err
+	}
+	if err := verifyHeader(view, block.Header()); err != nil {
+		return err
+	}
+
+	// The block must start with exactly the rewards granted by the engine
+	expected := rewardTransactions(engine.Finalize(view, block.Header()), txVersion(view.Config(), block.Index))
+	if len(block.Transactions) < len(expected) {
+		return fmt.Errorf("block %d is missing its block rewards", block.Index)
+	}
+	for i, reward := range expected {
+		if block.Transactions[i].Hash != reward.Hash {
+			return fmt.Errorf("block %d has an invalid block reward at position %d", block.Index, i)
+		}
+	}
+	// Only the engine mints, so no mint may follow the rewards
+	for i, tx := range block.Transactions[len(expected):] {
+		if tx.Type == TxTypeMint {
+			return fmt.Errorf("block %d has an unexpected mint at position %d", block.Index, len(expected)+i)
+		}
+	}
+	return nil
+}
+
+// blockAuthor returns the author of a connected block, which was checked
+// when the block was verified
+func (bc *Blockchain) blockAuthor(block *Block) string {
+	author, err := bc.engine.Author(block.Header())
+	if err != nil {
+		return ""
+	}
+	return author
+}
+
+// CliqueSnapshot returns the proof-of-authority voting state at the tip
+func (bc *Blockchain) CliqueSnapshot() (*consensus.Snapshot, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	clique, ok := bc.engine.(*consensus.Clique)
+	if !ok {
+		return nil, fmt.Errorf("chain does not use proof-of-authority")
+	}
+	return clique.Snapshot(bc.view())
+}
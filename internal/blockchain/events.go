@@ -0,0 +1,36 @@
+package blockchain
+
+// blockFeedBuffer is how many connected blocks a subscriber can fall behind
+// before further blocks are dropped for it
+const blockFeedBuffer = 16
+
+// SubscribeBlocks announces every block connected to the chain on the returned
+// channel until the returned function is called. Slow subscribers miss blocks
+// rather than holding up the chain.
+func (bc *Blockchain) SubscribeBlocks() (<-chan *Block, func()) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	ch := make(chan *Block, blockFeedBuffer)
+	if bc.subscribers == nil {
+		bc.subscribers = make(map[chan *Block]bool)
+	}
+	bc.subscribers[ch] = true
+
+	unsubscribe := func() {
+		bc.mutex.Lock()
+		defer bc.mutex.Unlock()
+		delete(bc.subscribers, ch)
+	}
+	return ch, unsubscribe
+}
+
+// announceBlock sends a newly connected block to all subscribers
+func (bc *Blockchain) announceBlock(block *Block) {
+	for ch := range bc.subscribers {
+		select {
+		case ch <- block:
+		default:
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"tpy-blockchain/internal/consensus"
+)
+
+// Fee market parameters, see consensus.UpgradeFeeMarket. Fees are paid per
+// transaction from native balances.
+const (
+	// InitialBaseFee is the base fee of the first block of the fee market
+	InitialBaseFee = 10
+
+	// MinBaseFee is the lowest the base fee drops to when blocks are empty
+	MinBaseFee = 1
+
+	// BaseFeeChangeDenominator limits a base fee change to 1/8 per block
+	BaseFeeChangeDenominator = 8
+
+	// DefaultPriorityFee is the suggested tip when recent blocks paid none
+	DefaultPriorityFee = 1
+
+	// feeHistoryBlocks is how many recent blocks tips are suggested from
+	feeHistoryBlocks = 20
+)
+
+// FeeEstimate suggests the fees for a transaction in the next block
+type FeeEstimate struct {
+	Active      bool     `json:"active"`      // Whether the next block charges fees
+	BaseFee     *big.Int `json:"baseFee"`     // Base fee of the next block, burned
+	PriorityFee *big.Int `json:"priorityFee"` // Median tip paid in recent blocks
+	MaxFee      *big.Int `json:"maxFee"`      // Covers the tip and the base fee rising for several full blocks
+}
+
+// targetTransactions is the number of fee-paying transactions a block is
+// expected to hold. Fuller blocks raise the base fee and emptier ones lower it.
+func targetTransactions(config *consensus.ChainConfig) int {
+	target := config.BlockTransactionLimit() / 2
+	if target < 1 {
+		target = 1
+	}
+	return target
+}
+
+// paysFee reports whether a transaction is charged fees. Block rewards and
+// double-sign evidence are free.
+func paysFee(tx *Transaction) bool {
+	return hasSender(tx)
+}
+
+// feeTransactions counts the transactions of a block that paid fees
+func feeTransactions(block *Block) int {
+	count := 0
+	for _, tx := range block.Transactions {
+		if paysFee(tx) {
+			count++
+		}
+	}
+	return count
+}
+
+// calcBaseFee returns the base fee of the block after parent, or nil if the
+// fee market is not active at that height
+func calcBaseFee(config *consensus.ChainConfig, parent *Block) *big.Int {
+	if !config.IsActive(consensus.UpgradeFeeMarket, parent.Index+1) {
+		return nil
+	}
+	if parent.BaseFee == nil {
+		return big.NewInt(InitialBaseFee)
+	}
+
+	target := int64(targetTransactions(config))
+	used := int64(feeTransactions(parent))
+	baseFee := new(big.Int).Set(parent.BaseFee)
+	delta := new(big.Int).Mul(parent.BaseFee, big.NewInt(used-target))
+	delta.Quo(delta, big.NewInt(target*BaseFeeChangeDenominator))
+	if used > target && delta.Sign() == 0 {
+		delta.SetInt64(1)
+	}
+	baseFee.Add(baseFee, delta)
+	if baseFee.Cmp(big.NewInt(MinBaseFee)) < 0 {
+		baseFee.SetInt64(MinBaseFee)
+	}
+	return baseFee
+}
+
+// verifyBaseFee checks that a block carries the base fee that follows from its parent
+func verifyBaseFee(config *consensus.ChainConfig, parent, block *Block) error {
+	expected := calcBaseFee(config, parent)
+	if expected == nil && block.BaseFee == nil {
+		return nil
+	}
+	if expected == nil || block.BaseFee == nil || expected.Cmp(block.BaseFee) != 0 {
+		return fmt.Errorf("block %d has base fee %v, expected %v", block.Index, block.BaseFee, expected)
+	}
+	return nil
+}
+
+// effectiveTip returns the tip a transaction pays on top of baseFee
+func effectiveTip(tx *Transaction, baseFee *big.Int) *big.Int {
+	tip := new(big.Int).Sub(bigOrZero(tx.MaxFee), baseFee)
+	if tx.PriorityFee != nil && tx.PriorityFee.Cmp(tip) < 0 {
+		tip.Set(tx.PriorityFee)
+	}
+	if tip.Sign() < 0 || tx.PriorityFee == nil {
+		tip.SetInt64(0)
+	}
+	return tip
+}
+
+// chargeFee takes the base fee and tip of a transaction from the sender's
+// native balance. The base fee is burned and the tip paid to the block's
+// author, or burned too if the block has none.
+func (st *State) chargeFee(tx *Transaction) error {
+	if st.baseFee == nil {
+		return fmt.Errorf("block %d has no base fee", st.height)
+	}
+	if tx.MaxFee == nil || tx.MaxFee.Cmp(st.baseFee) < 0 {
+		return fmt.Errorf("transaction %s offers a fee of %v, below the base fee of %s", tx.Hash, tx.MaxFee, st.baseFee)
+	}
+	tip := effectiveTip(tx, st.baseFee)
+	total := new(big.Int).Add(st.baseFee, tip)
+	if err := debit(st.Balances, tx.Sender, total); err != nil {
+		return fmt.Errorf("cannot pay fee of %s: %v", total, err)
+	}
+	if st.author != "" && tip.Sign() > 0 {
+		credit(st.Balances, st.author, tip)
+	}
+	return nil
+}
+
+// burnedFees returns the native amount burned by the fees of a block: the
+// base fee of each fee-paying transaction, and the tips if nobody is paid them
+func burnedFees(block *Block, author string) *big.Int {
+	burned := big.NewInt(0)
+	if block.BaseFee == nil {
+		return burned
+	}
+	for _, tx := range block.Transactions {
+		if !paysFee(tx) {
+			continue
+		}
+		burned.Add(burned, block.BaseFee)
+		if author == "" {
+			burned.Add(burned, effectiveTip(tx, block.BaseFee))
+		}
+	}
+	return burned
+}
+
+// sortByTip orders transactions by the tip they pay on top of baseFee,
+// highest first, keeping the order of equal tips. A sender's transactions
+// keep their nonce order, so each is picked once those before it are.
+func sortByTip(transactions []*Transaction, baseFee *big.Int) []*Transaction {
+	// Queue each sender's transactions, evidence stands alone
+	var queues [][]*Transaction
+	bySender := make(map[string]int)
+	for _, tx := range transactions {
+		if !hasSender(tx) {
+			queues = append(queues, []*Transaction{tx})
+			continue
+		}
+		if i, ok := bySender[tx.Sender]; ok {
+			queues[i] = append(queues[i], tx)
+			continue
+		}
+		bySender[tx.Sender] = len(queues)
+		queues = append(queues, []*Transaction{tx})
+	}
+
+	tips := make(map[*Transaction]*big.Int, len(transactions))
+	for _, tx := range transactions {
+		tips[tx] = effectiveTip(tx, baseFee)
+	}
+	sorted := make([]*Transaction, 0, len(transactions))
+	for len(sorted) < len(transactions) {
+		best := -1
+		for i, queue := range queues {
+			if len(queue) > 0 && (best < 0 || tips[queue[0]].Cmp(tips[queues[best][0]]) > 0) {
+				best = i
+			}
+		}
+		sorted = append(sorted, queues[best][0])
+		queues[best] = queues[best][1:]
+	}
+	return sorted
+}
+
+// EstimateFees suggests fees for a transaction in the next block
+func (bc *Blockchain) EstimateFees() *FeeEstimate {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	baseFee := calcBaseFee(bc.config, tip)
+	if baseFee == nil {
+		return &FeeEstimate{BaseFee: big.NewInt(0), PriorityFee: big.NewInt(0), MaxFee: big.NewInt(0)}
+	}
+
+	var tips []*big.Int
+	for i := len(bc.Blocks) - 1; i > 0 && i >= len(bc.Blocks)-feeHistoryBlocks; i-- {
+		block := bc.Blocks[i]
+		if block.BaseFee == nil {
+			break
+		}
+		for _, tx := range block.Transactions {
+			if paysFee(tx) {
+				tips = append(tips, effectiveTip(tx, block.BaseFee))
+			}
+		}
+	}
+	priorityFee := big.NewInt(DefaultPriorityFee)
+	if len(tips) > 0 {
+		sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+		if median := tips[len(tips)/2]; median.Sign() > 0 {
+			priorityFee = median
+		}
+	}
+
+	// Twice the base fee stays valid through several full blocks in a row
+	maxFee := new(big.Int).Mul(baseFee, big.NewInt(2))
+	maxFee.Add(maxFee, priorityFee)
+	return &FeeEstimate{Active: true, BaseFee: baseFee, PriorityFee: priorityFee, MaxFee: maxFee}
+}
+
+// FillFees sets the estimated fees on an unsigned transaction that offers
+// none, if the next block charges fees
+func (bc *Blockchain) FillFees(tx *Transaction) {
+	if tx.MaxFee != nil {
+		return
+	}
+	if estimate := bc.EstimateFees(); estimate.Active {
+		tx.SetFees(estimate.MaxFee, estimate.PriorityFee)
+	}
+}
@@ -0,0 +1,121 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"tpy-blockchain/internal/filters"
+	"tpy-blockchain/internal/storage"
+)
+
+// blockAddresses lists the sender, receiver and token addresses touched by a
+// block. It only reads the block, so every node builds the same filter.
+func blockAddresses(block *Block) []string {
+	var addresses []string
+	for _, tx := range block.Transactions {
+		addresses = append(addresses, tx.Sender, tx.Receiver)
+		if tx.TokenSymbol != "" {
+			addresses = append(addresses, generateUniqueTokenAddress(tx.TokenSymbol))
+		}
+	}
+	return addresses
+}
+
+// newFilter computes the compact filter of the block after the filter chain
+func (bc *Blockchain) newFilter(block *Block) (*filters.BlockFilter, error) {
+	if block.Index != len(bc.filters) {
+		return nil, fmt.Errorf("filter chain is at %d, cannot add filter for block %d", len(bc.filters), block.Index)
+	}
+
+	prevHeader := filters.GenesisPrevHeader
+	if len(bc.filters) > 0 {
+		prevHeader = bc.filters[len(bc.filters)-1].Header
+	}
+	bf, err := filters.NewBlockFilter(block.Index, block.Hash, prevHeader, blockAddresses(block))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build filter for block %d: %v", block.Index, err)
+	}
+	return bf, nil
+}
+
+// buildFilter computes the compact filter of the block and appends it to the filter chain
+func (bc *Blockchain) buildFilter(block *Block) error {
+	bf, err := bc.newFilter(block)
+	if err != nil {
+		return err
+	}
+	bc.filters = append(bc.filters, bf)
+	return nil
+}
+
+// loadFilters reads the filters record of earlier versions and rebuilds the
+// filters if it doesn't match the blocks
+func (bc *Blockchain) loadFilters() error {
+	bc.filters = nil
+
+	data, err := bc.store.Get(filtersRecord)
+	if err == nil {
+		var stored []*filters.BlockFilter
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return fmt.Errorf("failed to unmarshal filters: %v", err)
+		}
+		if filtersMatchBlocks(stored, bc.Blocks) {
+			bc.filters = stored
+			return nil
+		}
+	} else if err != storage.ErrNotFound {
+		return fmt.Errorf("failed to read filters: %v", err)
+	}
+
+	fmt.Println("Rebuilding compact block filters...")
+	for _, block := range bc.Blocks {
+		if err := bc.buildFilter(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func filtersMatchBlocks(stored []*filters.BlockFilter, blocks []*Block) bool {
+	if len(stored) != len(blocks) {
+		return false
+	}
+	for i, bf := range stored {
+		if bf.BlockIndex != i || bf.BlockHash != blocks[i].Hash {
+			return false
+		}
+	}
+	return true
+}
+
+// GetFilter returns the compact filter of the block at the given index
+func (bc *Blockchain) GetFilter(index int) (*filters.BlockFilter, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if index < 0 || index >= len(bc.filters) {
+		return nil, fmt.Errorf("filter for block %d not found", index)
+	}
+	return bc.filters[index], nil
+}
+
+// GetFilterHeaders returns the filter headers of blocks from..to inclusive
+func (bc *Blockchain) GetFilterHeaders(from, to int) ([]string, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if from < 0 || from >= len(bc.filters) {
+		return nil, fmt.Errorf("filter for block %d not found", from)
+	}
+	if to >= len(bc.filters) {
+		to = len(bc.filters) - 1
+	}
+	if to < from {
+		return nil, fmt.Errorf("invalid filter range %d-%d", from, to)
+	}
+
+	headers := make([]string, 0, to-from+1)
+	for _, bf := range bc.filters[from : to+1] {
+		headers = append(headers, bf.Header)
+	}
+	return headers, nil
+}
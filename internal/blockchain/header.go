@@ -0,0 +1,64 @@
+package blockchain
+
+import (
+	"tpy-blockchain/internal/consensus"
+	"tpy-blockchain/pkg/crypto"
+)
+
+// BlockHeader holds the fields of a block that are covered by its hash.
+// Light clients sync and verify headers without downloading block bodies.
+type BlockHeader = consensus.Header
+
+// Header returns the header of the block
+func (block *Block) Header() *BlockHeader {
+	return &BlockHeader{
+		Version:      block.Version,
+		Index:        block.Index,
+		Timestamp:    block.Timestamp,
+		Nonce:        block.Nonce,
+		PreviousHash: block.PreviousHash,
+		MerkleRoot:   block.MerkleRoot,
+		StateRoot:    block.StateRoot,
+		Difficulty:   block.Difficulty,
+		Coinbase:     block.Coinbase,
+		BaseFee:      block.BaseFee,
+		Extra:        block.Extra,
+		Hash:         block.Hash,
+		Commit:       block.Commit,
+	}
+}
+
+// applyHeader copies the header fields, e.g. a seal, back into the block
+func (block *Block) applyHeader(header *BlockHeader) {
+	block.Version = header.Version
+	block.Index = header.Index
+	block.Timestamp = header.Timestamp
+	block.Nonce = header.Nonce
+	block.PreviousHash = header.PreviousHash
+	block.MerkleRoot = header.MerkleRoot
+	block.StateRoot = header.StateRoot
+	block.Difficulty = header.Difficulty
+	block.Coinbase = header.Coinbase
+	block.BaseFee = header.BaseFee
+	block.Extra = header.Extra
+	block.Hash = header.Hash
+	block.Commit = header.Commit
+}
+
+// CalculateHeaderHash computes the block hash from header fields only
+func CalculateHeaderHash(header *BlockHeader) string {
+	return header.ComputeHash()
+}
+
+// TransactionsRoot computes the Merkle root over the hashes of the given transactions
+func TransactionsRoot(transactions []*Transaction) string {
+	return crypto.MerkleRoot(transactionHashes(transactions))
+}
+
+func transactionHashes(transactions []*Transaction) []string {
+	hashes := make([]string, len(transactions))
+	for i, tx := range transactions {
+		hashes[i] = tx.Hash
+	}
+	return hashes
+}
@@ -0,0 +1,175 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"tpy-blockchain/internal/index"
+	"tpy-blockchain/internal/storage"
+)
+
+// txAddresses lists the addresses a transaction touches
+func txAddresses(tx *Transaction) []string {
+	return []string{tx.Sender, tx.Receiver}
+}
+
+// indexBlock records the transactions of a connected block and the holder
+// status of the addresses whose token balances its state diff changed,
+// returning the holder status it set
+func (bc *Blockchain) indexBlock(block *Block, redo *stateDiff) []*holderChange {
+	bc.indexTxs(block)
+	changes := holderChanges(redo)
+	bc.setHolders(changes)
+	bc.indexes.Height = block.Index
+	return changes
+}
+
+// indexTxs records the transactions of a block, which needs nothing but the block
+func (bc *Blockchain) indexTxs(block *Block) {
+	for position, tx := range block.Transactions {
+		location := &index.TxLocation{
+			BlockIndex: block.Index,
+			BlockHash:  block.Hash,
+			Position:   position,
+		}
+		bc.indexes.AddTx(tx.Hash, location, txAddresses(tx))
+	}
+}
+
+// unindexBlock removes the transactions of a rolled back block and restores
+// the holder status its undo data changed
+func (bc *Blockchain) unindexBlock(block *Block, undo *stateDiff) {
+	for position := len(block.Transactions) - 1; position >= 0; position-- {
+		tx := block.Transactions[position]
+		bc.indexes.RemoveTx(tx.Hash, txAddresses(tx))
+	}
+	bc.setHolders(holderChanges(undo))
+	bc.indexes.Height = block.Index - 1
+}
+
+// holderChanges returns the holder status of every address whose token
+// balance a state diff changes. This covers transfers as well as stake that
+// returns, slashing and anything else that moves token balances.
+func holderChanges(diff *stateDiff) []*holderChange {
+	if diff == nil {
+		return nil
+	}
+	var changes []*holderChange
+	for symbol, td := range diff.Tokens {
+		for address, balance := range td.Balances {
+			changes = append(changes, &holderChange{Token: symbol, Address: address, Holds: balance != nil && balance.Sign() > 0})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Token != changes[j].Token {
+			return changes[i].Token < changes[j].Token
+		}
+		return changes[i].Address < changes[j].Address
+	})
+	return changes
+}
+
+// setHolders records holder status in the index
+func (bc *Blockchain) setHolders(changes []*holderChange) {
+	for _, change := range changes {
+		bc.indexes.SetHolder(change.Token, change.Address, change.Holds)
+	}
+}
+
+// rebuildIndexes recreates all indexes from the blocks and the holders from current state
+func (bc *Blockchain) rebuildIndexes() {
+	bc.indexes = index.New()
+	for _, block := range bc.Blocks {
+		bc.indexTxs(block)
+	}
+	for symbol, token := range bc.Tokens {
+		for address, balance := range token.Balances {
+			if balance != nil && balance.Sign() > 0 {
+				bc.indexes.SetHolder(symbol, address, true)
+			}
+		}
+	}
+	bc.indexes.Height = len(bc.Blocks) - 1
+}
+
+// loadIndexes reads the index record of earlier versions and rebuilds the
+// indexes if it is missing or stale
+func (bc *Blockchain) loadIndexes() error {
+	data, err := bc.store.Get(indexRecord)
+	if err != nil && err != storage.ErrNotFound {
+		return fmt.Errorf("failed to read index: %v", err)
+	}
+	if err == nil {
+		idx := index.New()
+		if err := json.Unmarshal(data, idx); err != nil {
+			fmt.Printf("Ignoring stored index: %v\n", err)
+		} else if idx.Height == len(bc.Blocks)-1 {
+			bc.indexes = idx
+			return nil
+		}
+	}
+
+	fmt.Println("Rebuilding address and transaction indexes...")
+	bc.rebuildIndexes()
+	return nil
+}
+
+// GetTransaction returns a confirmed transaction and its location
+func (bc *Blockchain) GetTransaction(txHash string) (*Transaction, *index.TxLocation, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	tx, location, ok := bc.locateTx(txHash)
+	if !ok {
+		return nil, nil, fmt.Errorf("transaction %s not found", txHash)
+	}
+	return tx, location, nil
+}
+
+// GetAddressTransactions returns a page of confirmed transactions touching
+// an address, newest first, and the total number of such transactions
+func (bc *Blockchain) GetAddressTransactions(address string, offset, limit int) ([]*Transaction, int) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if store, ok := bc.queryStore(); ok {
+		refs, total, err := store.AddressTxs(address, offset, limit)
+		if err == nil {
+			transactions := make([]*Transaction, 0, len(refs))
+			for _, ref := range refs {
+				if tx, ok := bc.txAt(ref); ok {
+					transactions = append(transactions, tx)
+				}
+			}
+			return transactions, total
+		}
+		fmt.Printf("Falling back to in-memory index: %v\n", err)
+	}
+
+	hashes, total := bc.indexes.AddressTxs(address, offset, limit)
+	transactions := make([]*Transaction, 0, len(hashes))
+	for _, hash := range hashes {
+		location, _ := bc.indexes.TxLocation(hash)
+		transactions = append(transactions, bc.Blocks[location.BlockIndex].Transactions[location.Position])
+	}
+	return transactions, total
+}
+
+// GetTokenHolders returns a page of addresses holding a token and the total number of holders
+func (bc *Blockchain) GetTokenHolders(tokenSymbol string, offset, limit int) ([]string, int, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if _, ok := bc.Tokens[tokenSymbol]; !ok {
+		return nil, 0, fmt.Errorf("token %s not found", tokenSymbol)
+	}
+	if store, ok := bc.queryStore(); ok {
+		holders, total, err := store.TokenHolders(tokenSymbol, offset, limit)
+		if err == nil {
+			return holders, total, nil
+		}
+		fmt.Printf("Falling back to in-memory index: %v\n", err)
+	}
+	holders, total := bc.indexes.TokenHolders(tokenSymbol, offset, limit)
+	return holders, total, nil
+}
@@ -0,0 +1,155 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"tpy-blockchain/internal/storage"
+)
+
+// journalLimit is how many journal entries a save leaves before it stores
+// the pool in their place
+const journalLimit = 1000
+
+// DroppedTransaction is a journaled pending transaction that was not restored
+type DroppedTransaction struct {
+	Hash   string `json:"hash"`
+	Reason string `json:"reason"`
+}
+
+// PendingRestore reports what LoadPending did with the journaled transactions
+type PendingRestore struct {
+	Restored int                   `json:"restored"`
+	Stale    []*DroppedTransaction `json:"stale"`   // Already confirmed or replaced
+	Invalid  []*DroppedTransaction `json:"invalid"` // No longer valid against the current state
+}
+
+// LoadPending restores the pending transactions stored before the node
+// stopped and journals each transaction accepted from then on. Each
+// transaction is validated again against the current state and the chain
+// config, so it should be called once the config is set. Transactions that
+// were confirmed meanwhile are stale, and those that no longer apply are
+// invalid; both are dropped and reported.
+func (bc *Blockchain) LoadPending() (*PendingRestore, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	journaled, err := bc.readPendingJournal()
+	if err != nil {
+		return nil, err
+	}
+	// Pending transactions saved in chain files by earlier versions are
+	// validated like journaled ones
+	journaled = append(bc.Transactions, journaled...)
+	bc.Transactions = []*Transaction{}
+	bc.journalPending = false
+
+	restore := &PendingRestore{Stale: []*DroppedTransaction{}, Invalid: []*DroppedTransaction{}}
+	replaced := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, tx := range journaled {
+		if seen[tx.Hash] {
+			continue
+		}
+		seen[tx.Hash] = true
+		if _, ok := bc.indexes.TxLocation(tx.Hash); ok {
+			restore.Stale = append(restore.Stale, &DroppedTransaction{Hash: tx.Hash, Reason: "already in a block"})
+			continue
+		}
+		if hasSender(tx) && tx.Nonce <= bc.Nonces[tx.Sender] {
+			restore.Stale = append(restore.Stale, &DroppedTransaction{Hash: tx.Hash, Reason: fmt.Sprintf("nonce %d already used", tx.Nonce)})
+			continue
+		}
+		old, err := bc.addTransaction(tx)
+		if err != nil {
+			restore.Invalid = append(restore.Invalid, &DroppedTransaction{Hash: tx.Hash, Reason: err.Error()})
+			continue
+		}
+		if old != nil {
+			replaced[old.Hash] = true
+		}
+	}
+	restore.Restored = len(bc.Transactions)
+	// The journal keeps replaced transactions before their replacements
+	for hash := range replaced {
+		restore.Stale = append(restore.Stale, &DroppedTransaction{Hash: hash, Reason: "replaced"})
+	}
+
+	batch := bc.store.NewBatch()
+	if err := bc.compactJournal(batch); err != nil {
+		return nil, err
+	}
+	if err := batch.Write(); err != nil {
+		return nil, fmt.Errorf("failed to store pending transactions: %v", err)
+	}
+	bc.journaled = 0
+	bc.journalPending = true
+	return restore, nil
+}
+
+// journalEntry is the key of the nth transaction appended to the journal
+// since the pool was last stored
+func journalEntry(n int) string {
+	return fmt.Sprintf("%s-%d", pendingRecord, n)
+}
+
+// readPendingJournal reads the stored pool followed by the transactions
+// appended to the journal since, in the order they were accepted
+func (bc *Blockchain) readPendingJournal() ([]*Transaction, error) {
+	var transactions []*Transaction
+	data, err := bc.store.Get(pendingRecord)
+	if err != nil && err != storage.ErrNotFound {
+		return nil, fmt.Errorf("failed to read pending transactions: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &transactions); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal pending transactions: %v", err)
+		}
+	}
+
+	bc.journaled = 0
+	for {
+		data, err := bc.store.Get(journalEntry(bc.journaled + 1))
+		if err == storage.ErrNotFound {
+			return transactions, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pending transaction: %v", err)
+		}
+		var tx Transaction
+		if err := json.Unmarshal(data, &tx); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal pending transaction: %v", err)
+		}
+		transactions = append(transactions, &tx)
+		bc.journaled++
+	}
+}
+
+// journalTransaction appends an accepted transaction to the journal. A
+// replacement is appended like any other transaction; the transactions it
+// replaced or evicted are dropped again when the journal is loaded.
+func (bc *Blockchain) journalTransaction(tx *Transaction) error {
+	if !bc.journalPending {
+		return nil
+	}
+	batch := bc.store.NewBatch()
+	if err := putRecord(batch, journalEntry(bc.journaled+1), tx); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return fmt.Errorf("failed to journal pending transaction: %v", err)
+	}
+	bc.journaled++
+	return nil
+}
+
+// compactJournal adds the pool to a batch in place of the journal entries.
+// The caller resets journaled once the batch is written.
+func (bc *Blockchain) compactJournal(batch storage.Batch) error {
+	if err := putRecord(batch, pendingRecord, bc.Transactions); err != nil {
+		return err
+	}
+	for n := 1; n <= bc.journaled; n++ {
+		batch.Delete(journalEntry(n))
+	}
+	return nil
+}
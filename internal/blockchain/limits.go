@@ -0,0 +1,52 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"tpy-blockchain/internal/consensus"
+)
+
+// sealReserve is the room a template leaves for the header fields that are
+// filled in after its transactions are chosen, such as the roots, hash, nonce
+// and signature
+const sealReserve = 512
+
+// Size returns the serialized size of the block in bytes. The BFT commit
+// certificate is not counted, as it is added after the block is agreed on.
+func (block *Block) Size() int {
+	cp := *block
+	cp.Commit = nil
+	data, _ := json.Marshal(&cp)
+	return len(data)
+}
+
+// Size returns the serialized size of the transaction in bytes
+func (tx *Transaction) Size() int {
+	data, _ := json.Marshal(tx)
+	return len(data)
+}
+
+// checkTxLimits checks a transaction against the chain's size limit
+func checkTxLimits(config *consensus.ChainConfig, tx *Transaction) error {
+	if size := tx.Size(); size > config.TxSizeLimit() {
+		return fmt.Errorf("transaction %s is %d bytes, more than the limit of %d", tx.Hash, size, config.TxSizeLimit())
+	}
+	return nil
+}
+
+// checkBlockLimits checks a block's size, transaction count and transaction
+// sizes against the chain's limits
+func checkBlockLimits(config *consensus.ChainConfig, block *Block) error {
+	if count := len(block.Transactions); count > config.BlockTransactionLimit() {
+		return fmt.Errorf("block %d has %d transactions, more than the limit of %d", block.Index, count, config.BlockTransactionLimit())
+	}
+	for _, tx := range block.Transactions {
+		if err := checkTxLimits(config, tx); err != nil {
+			return fmt.Errorf("block %d: %v", block.Index, err)
+		}
+	}
+	if size := block.Size(); size > config.BlockSizeLimit() {
+		return fmt.Errorf("block %d is %d bytes, more than the limit of %d", block.Index, size, config.BlockSizeLimit())
+	}
+	return nil
+}
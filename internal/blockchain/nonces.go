@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// ReplacementFeeBump is the percentage by which a transaction has to raise
+// both fees of the pending transaction with the same sender and nonce to
+// replace it
+const ReplacementFeeBump = 10
+
+// bumpedFee returns the lowest fee that replaces a transaction offering fee
+func bumpedFee(fee *big.Int) *big.Int {
+	bump := new(big.Int).Mul(bigOrZero(fee), big.NewInt(ReplacementFeeBump))
+	bump.Quo(bump, big.NewInt(100))
+	if bump.Sign() == 0 {
+		bump.SetInt64(1)
+	}
+	return bump.Add(bump, bigOrZero(fee))
+}
+
+// checkReplacement checks that a transaction offers high enough fees to
+// replace the pending one with the same sender and nonce
+func checkReplacement(pending, tx *Transaction) error {
+	maxFee, priorityFee := bumpedFee(pending.MaxFee), bumpedFee(pending.PriorityFee)
+	if bigOrZero(tx.MaxFee).Cmp(maxFee) < 0 || bigOrZero(tx.PriorityFee).Cmp(priorityFee) < 0 {
+		return fmt.Errorf("replacing transaction %s requires a max fee of at least %s and a priority fee of at least %s", pending.Hash, maxFee, priorityFee)
+	}
+	return nil
+}
+
+// pendingByNonce returns the position of the pending transaction with the
+// given sender and nonce, or -1
+func (bc *Blockchain) pendingByNonce(sender string, nonce uint64) int {
+	for i, pending := range bc.Transactions {
+		if pending.Nonce == nonce && pending.Sender == sender {
+			return i
+		}
+	}
+	return -1
+}
+
+// NextNonce returns the nonce of the next transaction from address, counting
+// its pending transactions
+func (bc *Blockchain) NextNonce(address string) uint64 {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	nonce := bc.Nonces[address]
+	for _, pending := range bc.Transactions {
+		if pending.Sender == address && pending.Nonce > nonce {
+			nonce = pending.Nonce
+		}
+	}
+	return nonce + 1
+}
+
+// TxVersion returns the version of the transactions the next block accepts,
+// to be set with SetVersion before signing
+func (bc *Blockchain) TxVersion() int {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.nextTxVersion()
+}
+
+func (bc *Blockchain) nextTxVersion() int {
+	return txVersion(bc.config, len(bc.Blocks))
+}
+
+// NewCancellation returns an unsigned transaction that cancels the sender's
+// pending transaction with the given nonce, to be signed and submitted with
+// CancelTransaction. It offers the estimated fees, raised to the minimum bump
+// over the pending transaction.
+func (bc *Blockchain) NewCancellation(sender string, nonce uint64) (*Transaction, error) {
+	estimate := bc.EstimateFees()
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	i := bc.pendingByNonce(sender, nonce)
+	if nonce == 0 || i < 0 {
+		return nil, fmt.Errorf("no pending transaction from %s with nonce %d", sender, nonce)
+	}
+	pending := bc.Transactions[i]
+
+	maxFee, priorityFee := bumpedFee(pending.MaxFee), bumpedFee(pending.PriorityFee)
+	if estimate.Active && estimate.MaxFee.Cmp(maxFee) > 0 {
+		maxFee = estimate.MaxFee
+	}
+	if estimate.Active && estimate.PriorityFee.Cmp(priorityFee) > 0 {
+		priorityFee = estimate.PriorityFee
+	}
+
+	cancel := NewCancelTransaction(sender, nonce)
+	cancel.SetVersion(bc.nextTxVersion())
+	cancel.SetFees(maxFee, priorityFee)
+	return cancel, nil
+}
+
+// CancelTransaction submits a signed cancellation, a transfer of nothing from
+// the sender to itself, in place of the sender's pending transaction with the
+// same nonce. It returns the replaced transaction.
+func (bc *Blockchain) CancelTransaction(cancel *Transaction) (*Transaction, error) {
+	if !cancel.isCancellation() {
+		return nil, fmt.Errorf("transaction %s is not a cancellation", cancel.Hash)
+	}
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if bc.pendingByNonce(cancel.Sender, cancel.Nonce) < 0 {
+		return nil, fmt.Errorf("no pending transaction from %s with nonce %d", cancel.Sender, cancel.Nonce)
+	}
+	return bc.addTransaction(cancel)
+}
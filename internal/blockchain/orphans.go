@@ -0,0 +1,401 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+	"tpy-blockchain/internal/consensus"
+)
+
+const (
+	// DefaultMaxOrphans is how many orphan blocks are kept by default
+	DefaultMaxOrphans = 100
+
+	// DefaultOrphanExpiry is how long an orphan block waits for its parent by default
+	DefaultOrphanExpiry = time.Hour
+)
+
+// BlockResult reports what ProcessBlock did with a block
+type BlockResult struct {
+	Connected    []*Block // The block and the orphans it led to, in the order they were connected
+	Disconnected []*Block // Blocks of the former chain a reorganization removed, tip first
+	Orphan       bool     // The block's parent is unknown, so it was kept in the orphan pool
+	Side         bool     // The block is on a branch no heavier than the chain, so it was kept in the pool
+	Missing      string   // Hash of the block to request so the orphan can be connected
+}
+
+// OrphanInfo describes a block waiting in the orphan pool
+type OrphanInfo struct {
+	Index        int       `json:"index"`
+	Hash         string    `json:"hash"`
+	PreviousHash string    `json:"previousHash"`
+	Received     time.Time `json:"received"`
+}
+
+type orphan struct {
+	block    *Block
+	received time.Time
+}
+
+// orphanPool holds blocks that arrived before their parent, and blocks of
+// branches lighter than the chain, until they are connected or expire. It is
+// guarded by the chain's mutex.
+type orphanPool struct {
+	maxOrphans int
+	expiry     time.Duration
+	orphans    map[string]*orphan  // By block hash
+	byParent   map[string][]string // Orphan hashes by the hash of their parent
+}
+
+func newOrphanPool() *orphanPool {
+	return &orphanPool{
+		maxOrphans: DefaultMaxOrphans,
+		expiry:     DefaultOrphanExpiry,
+		orphans:    make(map[string]*orphan),
+		byParent:   make(map[string][]string),
+	}
+}
+
+// add stores an orphan. Beyond the size limit, the orphans farthest from the
+// tip at height are evicted, the latest first among equally far ones, so
+// blocks made up far ahead cannot push out those about to connect. It
+// reports whether the block itself was kept.
+func (p *orphanPool) add(block *Block, height int) bool {
+	p.orphans[block.Hash] = &orphan{block: block, received: time.Now()}
+	p.byParent[block.PreviousHash] = append(p.byParent[block.PreviousHash], block.Hash)
+
+	distance := func(o *orphan) int {
+		if o.block.Index > height {
+			return o.block.Index - height
+		}
+		return height - o.block.Index
+	}
+	for len(p.orphans) > p.maxOrphans {
+		var farthest *orphan
+		for _, o := range p.orphans {
+			if farthest == nil || distance(o) > distance(farthest) ||
+				(distance(o) == distance(farthest) && o.received.After(farthest.received)) {
+				farthest = o
+			}
+		}
+		p.remove(farthest.block.Hash)
+	}
+	_, kept := p.orphans[block.Hash]
+	return kept
+}
+
+// remove drops an orphan
+func (p *orphanPool) remove(hash string) {
+	o, ok := p.orphans[hash]
+	if !ok {
+		return
+	}
+	delete(p.orphans, hash)
+
+	siblings := p.byParent[o.block.PreviousHash]
+	for i, sibling := range siblings {
+		if sibling == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.byParent, o.block.PreviousHash)
+	} else {
+		p.byParent[o.block.PreviousHash] = siblings
+	}
+}
+
+// removeDescendants drops the orphans that build on the given block
+func (p *orphanPool) removeDescendants(hash string) {
+	for _, child := range append([]string(nil), p.byParent[hash]...) {
+		p.removeDescendants(child)
+		p.remove(child)
+	}
+}
+
+// expire drops orphans that have waited longer than the expiry, and those at
+// or below floor, which can no longer take part in a reorganization
+func (p *orphanPool) expire(floor int) {
+	for hash, o := range p.orphans {
+		if time.Since(o.received) > p.expiry || o.block.Index <= floor {
+			p.remove(hash)
+		}
+	}
+}
+
+// branch returns the heaviest chain of pooled blocks building on the given
+// block, and its weight
+func (p *orphanPool) branch(hash string, engine consensus.Engine) ([]*Block, *big.Int) {
+	var best []*Block
+	bestWeight := big.NewInt(0)
+	for _, child := range p.byParent[hash] {
+		block := p.orphans[child].block
+		rest, weight := p.branch(child, engine)
+		weight.Add(weight, engine.Weight(block.Header()))
+		if best == nil || weight.Cmp(bestWeight) > 0 {
+			best, bestWeight = append([]*Block{block}, rest...), weight
+		}
+	}
+	return best, bestWeight
+}
+
+// missing returns the hash of the unknown block the orphan ultimately waits
+// for, following its parents through the pool
+func (p *orphanPool) missing(block *Block) string {
+	parent := block.PreviousHash
+	for {
+		o, ok := p.orphans[parent]
+		if !ok {
+			return parent
+		}
+		parent = o.block.PreviousHash
+	}
+}
+
+// SetOrphanLimits sets how many orphan blocks are kept and how long each
+// waits for its parent
+func (bc *Blockchain) SetOrphanLimits(maxOrphans int, expiry time.Duration) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	bc.orphanPool().maxOrphans = maxOrphans
+	bc.orphanPool().expiry = expiry
+}
+
+// SetBlockRequester sets a function that is called with the hash of a
+// missing parent whenever an orphan block arrives, e.g. to fetch it from a peer
+func (bc *Blockchain) SetBlockRequester(request func(hash string)) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	bc.requestBlock = request
+}
+
+// ProcessBlock connects a block received from elsewhere, e.g. another node.
+// A block that extends the tip is connected along with any orphans that build
+// on it. A block whose parent is unknown is kept as an orphan and its missing
+// ancestor is requested. A block on another branch is kept too, and once that
+// branch is heavier than the chain the chain reorganizes onto it. Only blocks
+// whose seal verifies are kept. Connected blocks still have to be saved.
+func (bc *Blockchain) ProcessBlock(block *Block) (*BlockResult, error) {
+	bc.mutex.Lock()
+	result, err := bc.processBlock(block)
+	request := bc.requestBlock
+	bc.mutex.Unlock()
+
+	if err == nil && result.Orphan && request != nil {
+		request(result.Missing)
+	}
+	return result, err
+}
+
+func (bc *Blockchain) processBlock(block *Block) (*BlockResult, error) {
+	pool := bc.orphanPool()
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	// Blocks deeper than the undo data can no longer be reorganized onto
+	floor := tip.Index - undoDepth
+	pool.expire(floor)
+
+	if block.Hash != CalculateHash(block) {
+		return nil, fmt.Errorf("block %d hash mismatch", block.Index)
+	}
+	if _, ok := pool.orphans[block.Hash]; ok || bc.view().GetHeaderByHash(block.Hash) != nil {
+		return nil, fmt.Errorf("block %s is already known", block.Hash)
+	}
+
+	if block.PreviousHash == tip.Hash {
+		bc.reportDoubleSign(block)
+		if err := bc.connectBlock(block); err != nil {
+			return nil, err
+		}
+		return &BlockResult{Connected: append([]*Block{block}, bc.connectOrphans(block.Hash)...)}, nil
+	}
+
+	if parent := bc.view().GetHeaderByHash(block.PreviousHash); parent != nil {
+		if _, final := bc.engine.(*consensus.Tendermint); final {
+			return nil, fmt.Errorf("block %d builds on block %d, not on the tip %d, and committed blocks are final", block.Index, parent.Index, tip.Index)
+		}
+		if parent.Index < floor {
+			return nil, fmt.Errorf("block %d builds on block %d, deeper than the latest %d blocks", block.Index, parent.Index, undoDepth)
+		}
+		if block.Index != parent.Index+1 {
+			return nil, fmt.Errorf("block %d does not follow its parent %d", block.Index, parent.Index)
+		}
+	} else if block.Index <= floor {
+		return nil, fmt.Errorf("block %d is too far below the tip and its parent is unknown", block.Index)
+	}
+
+	// Limits and the seal can be checked without the parent, which keeps
+	// oversized and fabricated blocks out of the pool. Engines that cannot
+	// check a seal that way get no blocks pooled.
+	if err := checkBlockLimits(bc.config, block); err != nil {
+		return nil, err
+	}
+	verifier, ok := bc.engine.(consensus.SealVerifier)
+	if !ok {
+		return nil, fmt.Errorf("block %d does not build on the tip and its seal cannot be verified", block.Index)
+	}
+	if err := verifier.VerifySeal(bc.view(), block.Header()); err != nil {
+		return nil, err
+	}
+	if !pool.add(block, tip.Index) {
+		return nil, fmt.Errorf("block %d is farther from the tip than every block in the full orphan pool", block.Index)
+	}
+
+	missing := pool.missing(block)
+	fork := bc.view().GetHeaderByHash(missing)
+	if fork == nil {
+		fmt.Printf("Received orphan block %d, waiting for block %s\n", block.Index, missing)
+		return &BlockResult{Orphan: true, Missing: missing}, nil
+	}
+	return bc.reorganize(fork)
+}
+
+// reorganize switches the chain to the heaviest pooled branch off the block
+// fork when it outweighs the chain's blocks above fork. The disconnected
+// blocks go to the pool, so their branch can win back. If a branch block
+// fails to connect, the branch is dropped and the former chain restored.
+func (bc *Blockchain) reorganize(fork *consensus.Header) (*BlockResult, error) {
+	pool := bc.orphanPool()
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	if _, final := bc.engine.(*consensus.Tendermint); final && fork.Index < tip.Index {
+		pool.removeDescendants(fork.Hash)
+		return nil, fmt.Errorf("branch at block %d conflicts with committed blocks, which are final", fork.Index)
+	}
+	branch, weight := pool.branch(fork.Hash, bc.engine)
+	current := big.NewInt(0)
+	for _, block := range bc.Blocks[fork.Index+1:] {
+		current.Add(current, bc.engine.Weight(block.Header()))
+	}
+	if weight.Cmp(current) <= 0 {
+		fmt.Printf("Received block on a side branch at block %d, weight %s against %s\n", fork.Index, weight, current)
+		return &BlockResult{Side: true}, nil
+	}
+
+	var disconnected []*Block
+	for len(bc.Blocks)-1 > fork.Index {
+		block, err := bc.disconnectTip()
+		if err != nil {
+			bc.restoreChain(fork.Index, disconnected)
+			return nil, err
+		}
+		disconnected = append(disconnected, block)
+	}
+
+	var connected []*Block
+	for _, block := range branch {
+		pool.remove(block.Hash)
+		bc.reportDoubleSign(block)
+		if err := bc.connectBlock(block); err != nil {
+			pool.removeDescendants(block.Hash)
+			bc.restoreChain(fork.Index, disconnected)
+			return nil, fmt.Errorf("reorganization onto block %d failed: %v", block.Index, err)
+		}
+		connected = append(connected, block)
+	}
+	for _, block := range disconnected {
+		pool.add(block, bc.Blocks[len(bc.Blocks)-1].Index)
+	}
+	fmt.Printf("Reorganized at block %d: disconnected %d blocks, connected %d\n", fork.Index, len(disconnected), len(connected))
+	connected = append(connected, bc.connectOrphans(connected[len(connected)-1].Hash)...)
+	return &BlockResult{Connected: connected, Disconnected: disconnected}, nil
+}
+
+// restoreChain rolls the chain back to height and connects the blocks a
+// failed reorganization disconnected, given tip first
+func (bc *Blockchain) restoreChain(height int, disconnected []*Block) {
+	for len(bc.Blocks)-1 > height {
+		if _, err := bc.disconnectTip(); err != nil {
+			fmt.Printf("Failed to restore the chain: %v\n", err)
+			return
+		}
+	}
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		if err := bc.connectBlock(disconnected[i]); err != nil {
+			fmt.Printf("Failed to restore block %d: %v\n", disconnected[i].Index, err)
+			return
+		}
+	}
+}
+
+// connectOrphans connects the orphans that build on the given block, and
+// those that build on them in turn. Orphans that fail to connect are dropped
+// together with their descendants.
+func (bc *Blockchain) connectOrphans(hash string) []*Block {
+	pool := bc.orphanPool()
+	var connected []*Block
+	for parents := []string{hash}; len(parents) > 0; {
+		parent := parents[0]
+		parents = parents[1:]
+
+		for _, child := range append([]string(nil), pool.byParent[parent]...) {
+			block := pool.orphans[child].block
+			pool.remove(child)
+			if block.PreviousHash != bc.Blocks[len(bc.Blocks)-1].Hash {
+				// A sibling was connected first
+				pool.removeDescendants(child)
+				continue
+			}
+			bc.reportDoubleSign(block)
+			if err := bc.connectBlock(block); err != nil {
+				fmt.Printf("Dropped orphan block %d: %v\n", block.Index, err)
+				pool.removeDescendants(child)
+				continue
+			}
+			connected = append(connected, block)
+			parents = append(parents, child)
+		}
+	}
+	return connected
+}
+
+// Orphans returns the blocks waiting in the orphan pool, lowest first
+func (bc *Blockchain) Orphans() []*OrphanInfo {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	pool := bc.orphanPool()
+	infos := make([]*OrphanInfo, 0, len(pool.orphans))
+	for _, o := range pool.orphans {
+		infos = append(infos, &OrphanInfo{
+			Index:        o.block.Index,
+			Hash:         o.block.Hash,
+			PreviousHash: o.block.PreviousHash,
+			Received:     o.received,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Index != infos[j].Index {
+			return infos[i].Index < infos[j].Index
+		}
+		return infos[i].Hash < infos[j].Hash
+	})
+	return infos
+}
+
+// MissingBlocks returns the hashes of the unknown blocks that orphans wait for
+func (bc *Blockchain) MissingBlocks() []string {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	pool := bc.orphanPool()
+	seen := make(map[string]bool)
+	missing := []string{}
+	for _, o := range pool.orphans {
+		hash := pool.missing(o.block)
+		if !seen[hash] {
+			seen[hash] = true
+			missing = append(missing, hash)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// orphanPool returns the chain's orphan pool, creating it on first use
+func (bc *Blockchain) orphanPool() *orphanPool {
+	if bc.orphans == nil {
+		bc.orphans = newOrphanPool()
+	}
+	return bc.orphans
+}
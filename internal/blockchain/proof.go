@@ -0,0 +1,216 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"tpy-blockchain/pkg/crypto"
+)
+
+// ErrStateMismatch is returned for a balance proof when the node's state does
+// not match the state root of its tip, which a reindex repairs
+var ErrStateMismatch = errors.New("state does not match the state root of the tip; the node needs a reindex")
+
+// TxProof proves that a transaction is included in a block
+type TxProof struct {
+	TxHash     string                   `json:"txHash"`
+	BlockIndex int                      `json:"blockIndex"`
+	BlockHash  string                   `json:"blockHash"`
+	Position   int                      `json:"position"`
+	Count      int                      `json:"count"` // Transactions in the block
+	Branch     []crypto.MerkleProofStep `json:"branch"`
+}
+
+// BalanceProof proves the balance of an address in the state committed by a block
+type BalanceProof struct {
+	Address     string                   `json:"address"`
+	TokenSymbol string                   `json:"tokenSymbol,omitempty"`
+	Balance     string                   `json:"balance"`
+	BlockIndex  int                      `json:"blockIndex"`
+	BlockHash   string                   `json:"blockHash"`
+	Position    int                      `json:"position"` // Of the balance among the sorted state entries
+	Count       int                      `json:"count"`    // State entries
+	Branch      []crypto.MerkleProofStep `json:"branch"`
+}
+
+// Verify checks the transaction proof against a trusted header
+func (proof *TxProof) Verify(header *BlockHeader) error {
+	if header.Index != proof.BlockIndex || header.Hash != proof.BlockHash {
+		return fmt.Errorf("proof is for block %d (%s), not header %d (%s)", proof.BlockIndex, proof.BlockHash, header.Index, header.Hash)
+	}
+	if !crypto.VerifyMerkleProof(proof.TxHash, proof.Position, proof.Count, proof.Branch, header.MerkleRoot) {
+		return fmt.Errorf("transaction %s is not included in block %d", proof.TxHash, header.Index)
+	}
+	return nil
+}
+
+// Verify checks the balance proof against a trusted header
+func (proof *BalanceProof) Verify(header *BlockHeader) error {
+	if header.Index != proof.BlockIndex || header.Hash != proof.BlockHash {
+		return fmt.Errorf("proof is for block %d (%s), not header %d (%s)", proof.BlockIndex, proof.BlockHash, header.Index, header.Hash)
+	}
+	balance, ok := new(big.Int).SetString(proof.Balance, 10)
+	if !ok {
+		return fmt.Errorf("invalid balance %q", proof.Balance)
+	}
+	leaf := balanceLeaf(BalanceKey(proof.TokenSymbol, proof.Address), balance)
+	if !crypto.VerifyMerkleProof(leaf, proof.Position, proof.Count, proof.Branch, header.StateRoot) {
+		return fmt.Errorf("balance of %s does not match state of block %d", proof.Address, header.Index)
+	}
+	return nil
+}
+
+// BalanceKey names an entry in the state tree. Native balances use the bare address.
+func BalanceKey(tokenSymbol, address string) string {
+	if tokenSymbol == "" {
+		return address
+	}
+	return tokenSymbol + ":" + address
+}
+
+func balanceLeaf(key string, balance *big.Int) string {
+	return crypto.HashSHA256(key + "=" + balance.String())
+}
+
+// stateEntries flattens native and token balances, token stake and account
+// nonces into state tree entries
+func stateEntries(st *State) map[string]*big.Int {
+	balances, tokens := st.Balances, st.Tokens
+	entries := make(map[string]*big.Int)
+	for address, nonce := range st.Nonces {
+		entries["nonce:"+address] = new(big.Int).SetUint64(nonce)
+	}
+	for address, balance := range balances {
+		if balance != nil {
+			entries[BalanceKey("", address)] = balance
+		}
+	}
+	for symbol, token := range tokens {
+		for address, balance := range token.Balances {
+			if balance != nil {
+				entries[BalanceKey(symbol, address)] = balance
+			}
+		}
+		for delegator, delegations := range token.Delegations {
+			for validator, stake := range delegations {
+				entries["stake:"+BalanceKey(symbol, delegator)+":"+validator] = stake
+			}
+		}
+		for _, u := range token.Unbonding {
+			key := fmt.Sprintf("unbonding:%s:%s:%d", BalanceKey(symbol, u.Address), u.Validator, u.Release)
+			entries[key] = addBig(new(big.Int).Set(bigOrZero(entries[key])), u.Amount)
+		}
+		for validator, rate := range token.Commission {
+			entries["commission:"+BalanceKey(symbol, validator)] = big.NewInt(int64(rate))
+		}
+		for validator, reward := range token.PendingRewards {
+			entries["pool:"+BalanceKey(symbol, validator)] = reward
+		}
+		for address, reward := range token.Accrued {
+			entries["rewards:"+BalanceKey(symbol, address)] = reward
+		}
+		for address := range token.Jailed {
+			entries["jailed:"+BalanceKey(symbol, address)] = big.NewInt(1)
+		}
+	}
+	return entries
+}
+
+// stateTree returns the sorted keys and leaves of the state tree
+func stateTree(entries map[string]*big.Int) ([]string, []string) {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	leaves := make([]string, len(keys))
+	for i, key := range keys {
+		leaves[i] = balanceLeaf(key, entries[key])
+	}
+	return keys, leaves
+}
+
+// GetHeaders returns the headers of blocks from..to inclusive
+func (bc *Blockchain) GetHeaders(from, to int) ([]*BlockHeader, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	if from < 0 || from >= len(bc.Blocks) {
+		return nil, fmt.Errorf("block with index %d not found", from)
+	}
+	if to >= len(bc.Blocks) {
+		to = len(bc.Blocks) - 1
+	}
+	if to < from {
+		return nil, fmt.Errorf("invalid header range %d-%d", from, to)
+	}
+
+	headers := make([]*BlockHeader, 0, to-from+1)
+	for _, block := range bc.Blocks[from : to+1] {
+		headers = append(headers, block.Header())
+	}
+	return headers, nil
+}
+
+// GetTxProof builds an inclusion proof for the transaction with the given hash
+func (bc *Blockchain) GetTxProof(txHash string) (*TxProof, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	location, ok := bc.indexes.TxLocation(txHash)
+	if !ok {
+		return nil, fmt.Errorf("transaction %s not found", txHash)
+	}
+	block := bc.Blocks[location.BlockIndex]
+	branch, err := crypto.MerkleProof(transactionHashes(block.Transactions), location.Position)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build proof: %v", err)
+	}
+	return &TxProof{
+		TxHash:     txHash,
+		BlockIndex: block.Index,
+		BlockHash:  block.Hash,
+		Position:   location.Position,
+		Count:      len(block.Transactions),
+		Branch:     branch,
+	}, nil
+}
+
+// GetBalanceProof builds a proof of an address balance against the latest block's state root
+func (bc *Blockchain) GetBalanceProof(address, tokenSymbol string) (*BalanceProof, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	keys, leaves := stateTree(stateEntries(bc.State))
+	if crypto.MerkleRoot(leaves) != tip.StateRoot {
+		return nil, ErrStateMismatch
+	}
+
+	key := BalanceKey(tokenSymbol, address)
+	position := sort.SearchStrings(keys, key)
+	if position == len(keys) || keys[position] != key {
+		return nil, fmt.Errorf("address %s not found", address)
+	}
+	branch, err := crypto.MerkleProof(leaves, position)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build proof: %v", err)
+	}
+
+	balance := bc.Balances[address]
+	if tokenSymbol != "" {
+		balance = bc.Tokens[tokenSymbol].Balances[address]
+	}
+	return &BalanceProof{
+		Address:     address,
+		TokenSymbol: tokenSymbol,
+		Balance:     balance.String(),
+		BlockIndex:  tip.Index,
+		BlockHash:   tip.Hash,
+		Position:    position,
+		Count:       len(leaves),
+		Branch:      branch,
+	}, nil
+}
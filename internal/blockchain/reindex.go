@@ -0,0 +1,133 @@
+package blockchain
+
+import (
+	"fmt"
+	"tpy-blockchain/internal/common"
+	"tpy-blockchain/internal/consensus"
+)
+
+// ReplayError reports the first block that failed while replaying the chain
+type ReplayError struct {
+	BlockIndex int
+	Err        error
+}
+
+func (e *ReplayError) Error() string {
+	return fmt.Sprintf("block %d: %v", e.BlockIndex, e.Err)
+}
+
+// validateBlockLinkage checks a block's hash, transaction root and link to its parent
+func validateBlockLinkage(block, previous *Block) error {
+	if block.Index != previous.Index+1 || block.PreviousHash != previous.Hash {
+		return fmt.Errorf("block %d does not extend block %d", block.Index, previous.Index)
+	}
+	if block.Hash != CalculateHash(block) {
+		return fmt.Errorf("block %d hash mismatch", block.Index)
+	}
+	if block.MerkleRoot != TransactionsRoot(block.Transactions) {
+		return fmt.Errorf("block %d transaction root mismatch", block.Index)
+	}
+	return nil
+}
+
+// ReplayBlocks rebuilds state from nothing by validating and applying
+// blocks[0..height] under the given consensus engine and upgrades. Token definitions are
+// kept, but their balances and governance state are derived from the blocks only.
+func ReplayBlocks(blocks []*Block, height int, tokens map[string]*common.UtilityToken, engine consensus.Engine, config *consensus.ChainConfig) (*State, error) {
+	st, _, err := replayBlocks(blocks, height, tokens, engine, config, 0)
+	return st, err
+}
+
+// replayBlocks is ReplayBlocks that also returns the state changes of the
+// latest keepDiffs blocks by height
+func replayBlocks(blocks []*Block, height int, tokens map[string]*common.UtilityToken, engine consensus.Engine, config *consensus.ChainConfig, keepDiffs int) (*State, map[int]*blockDerived, error) {
+	if height < 0 || height >= len(blocks) {
+		return nil, nil, fmt.Errorf("height %d out of range", height)
+	}
+
+	st := NewState()
+	st.config = config
+	derived := make(map[int]*blockDerived)
+	for symbol, token := range tokens {
+		definition := token.Copy()
+		definition.ResetState()
+		st.Tokens[symbol] = definition
+	}
+
+	for i := 0; i <= height; i++ {
+		block := blocks[i]
+		if i == 0 {
+			if block.Index != 0 || block.Hash != CalculateHash(block) {
+				return nil, nil, &ReplayError{BlockIndex: 0, Err: fmt.Errorf("invalid genesis block")}
+			}
+		} else if err := validateBlockLinkage(block, blocks[i-1]); err != nil {
+			return nil, nil, &ReplayError{BlockIndex: i, Err: err}
+		} else if err := verifyConsensus(engine, chainView{blocks: blocks[:i], state: st}, block); err != nil {
+			return nil, nil, &ReplayError{BlockIndex: i, Err: err}
+		}
+		var prev *State
+		if i > 0 && i > height-keepDiffs {
+			prev = st.Copy()
+		}
+		if err := st.applyBlock(block, engine); err != nil {
+			return nil, nil, &ReplayError{BlockIndex: i, Err: err}
+		}
+		if st.Root() != block.StateRoot {
+			return nil, nil, &ReplayError{BlockIndex: i, Err: fmt.Errorf("state root mismatch")}
+		}
+		if prev != nil {
+			derived[i] = &blockDerived{Undo: diffState(st, prev), Redo: diffState(prev, st)}
+		}
+	}
+	return st, derived, nil
+}
+
+// Reindex discards all derived state and rebuilds balances, token and
+// governance state, filters, indexes and pending transactions by replaying every
+// block from genesis. On failure the chain is left unchanged. The next save
+// stores the state and writes the latest blocks again with their new undo data.
+func (bc *Blockchain) Reindex() error {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	st, derived, err := replayBlocks(bc.Blocks, len(bc.Blocks)-1, bc.Tokens, bc.engine, bc.config, undoDepth)
+	if err != nil {
+		return err
+	}
+	bc.State = st
+	bc.derived = derived
+
+	bc.filters = nil
+	for _, block := range bc.Blocks {
+		if err := bc.buildFilter(block); err != nil {
+			return err
+		}
+		if d, ok := derived[block.Index]; ok {
+			d.Filter = bc.filters[block.Index]
+			d.Holders = holderChanges(d.Redo)
+		}
+	}
+	bc.rebuildIndexes()
+	if bc.stored > len(bc.Blocks)-undoDepth {
+		bc.stored = len(bc.Blocks) - undoDepth
+		if bc.stored < 1 {
+			bc.stored = 1
+		}
+	}
+	bc.snapshotDirty = true
+
+	// Keep only the pending transactions that are still valid on the rebuilt state
+	pendingState := bc.newPendingState()
+	pending := bc.Transactions[:0]
+	for _, tx := range bc.Transactions {
+		trial := pendingState.Copy()
+		if err := trial.applyTransaction(tx); err != nil {
+			fmt.Printf("Dropping pending transaction %s: %v\n", tx.Hash, err)
+			continue
+		}
+		pendingState = trial
+		pending = append(pending, tx)
+	}
+	bc.Transactions = pending
+	return nil
+}
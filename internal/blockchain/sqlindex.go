@@ -0,0 +1,332 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"tpy-blockchain/internal/index"
+	"tpy-blockchain/internal/storage"
+)
+
+// Receipt is what a confirmed transaction paid
+type Receipt struct {
+	TxHash       string `json:"txHash"`
+	BlockIndex   int    `json:"blockIndex"`
+	BlockHash    string `json:"blockHash"`
+	Position     int    `json:"position"`
+	FeeBurned    string `json:"feeBurned"`    // Base fee burned
+	Tip          string `json:"tip"`          // Priority fee paid to the block's author
+	TipRecipient string `json:"tipRecipient"` // The block's author, "" if the tip was burned
+}
+
+// ProposalVote is a vote cast on a token proposal
+type ProposalVote struct {
+	Voter      string `json:"voter"`
+	Yes        bool   `json:"yes"`
+	BlockIndex int    `json:"blockIndex"`
+	Position   int    `json:"position"`
+}
+
+// TokenProposal is a governance proposal of a token with its votes
+type TokenProposal struct {
+	ID          string          `json:"id"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Status      string          `json:"status"`
+	YesVotes    string          `json:"yesVotes"`
+	NoVotes     string          `json:"noVotes"`
+	Votes       []*ProposalVote `json:"votes"`
+}
+
+// queryStore returns the store's tables if they hold every block of the
+// chain, so queries on them match the chain in memory
+func (bc *Blockchain) queryStore() (storage.IndexedStore, bool) {
+	store, ok := bc.store.(storage.IndexedStore)
+	if !ok || bc.indexed != len(bc.Blocks) {
+		return nil, false
+	}
+	return store, true
+}
+
+// loadIndexedHeight reads how many leading blocks the store's tables hold
+func (bc *Blockchain) loadIndexedHeight() error {
+	store, ok := bc.store.(storage.IndexedStore)
+	if !ok {
+		return nil
+	}
+	height, err := store.IndexedHeight()
+	if err != nil {
+		return err
+	}
+	bc.indexed = height + 1
+	if bc.indexed > len(bc.Blocks) {
+		bc.indexed = len(bc.Blocks)
+	}
+	return nil
+}
+
+// writeIndexed adds the blocks missing from the store's tables, the balances
+// and the proposals to a batch of an IndexedStore
+func (bc *Blockchain) writeIndexed(batch storage.IndexBatch) {
+	from := bc.indexed
+	if bc.stored < from {
+		from = bc.stored
+	}
+	for _, block := range bc.Blocks[from:] {
+		batch.IndexBlock(blockRow(block, bc.blockAuthor(block)))
+	}
+	batch.SetBalances(bc.balanceRows())
+	batch.SetProposals(bc.proposalRows())
+}
+
+// blockRow returns the table rows of a block with the given author
+func blockRow(block *Block, author string) *storage.BlockRow {
+	row := &storage.BlockRow{
+		Height:       block.Index,
+		Hash:         block.Hash,
+		PreviousHash: block.PreviousHash,
+		Version:      block.Version,
+		Timestamp:    block.Timestamp,
+		MerkleRoot:   block.MerkleRoot,
+		StateRoot:    block.StateRoot,
+		Difficulty:   block.Difficulty,
+		Nonce:        block.Nonce,
+		Coinbase:     block.Coinbase,
+		Author:       author,
+		BaseFee:      bigString(block.BaseFee),
+		Extra:        block.Extra,
+	}
+	for position, tx := range block.Transactions {
+		burned, tip := txFees(tx, block.BaseFee)
+		row.Transactions = append(row.Transactions, &storage.TxRow{
+			Position:    position,
+			Hash:        tx.Hash,
+			Type:        tx.Type,
+			Sender:      tx.Sender,
+			Receiver:    tx.Receiver,
+			Amount:      bigOrZero(tx.Amount).String(),
+			TokenSymbol: tx.TokenSymbol,
+			Data:        tx.Data,
+			Nonce:       tx.Nonce,
+			MaxFee:      bigString(tx.MaxFee),
+			PriorityFee: bigString(tx.PriorityFee),
+			FeeBurned:   burned.String(),
+			Tip:         tip.String(),
+		})
+		if proposalID, yes, ok := parseVote(tx); ok {
+			row.Votes = append(row.Votes, &storage.VoteRow{
+				Height:     block.Index,
+				Position:   position,
+				Token:      tx.TokenSymbol,
+				ProposalID: proposalID,
+				Voter:      tx.Sender,
+				Yes:        yes,
+			})
+		}
+	}
+	return row
+}
+
+// txFees returns the base fee burned and the tip paid by a confirmed transaction
+func txFees(tx *Transaction, baseFee *big.Int) (*big.Int, *big.Int) {
+	if baseFee == nil || !paysFee(tx) {
+		return big.NewInt(0), big.NewInt(0)
+	}
+	return baseFee, effectiveTip(tx, baseFee)
+}
+
+// parseVote returns the proposal and choice of a vote transaction
+func parseVote(tx *Transaction) (string, bool, bool) {
+	if tx.Type != TxTypeVote {
+		return "", false, false
+	}
+	separator := strings.LastIndex(tx.Data, ":")
+	if separator < 0 {
+		return "", false, false
+	}
+	return tx.Data[:separator], tx.Data[separator+1:] == "yes", true
+}
+
+// bigString formats an optional amount, "" if it is not set
+func bigString(n *big.Int) string {
+	if n == nil {
+		return ""
+	}
+	return n.String()
+}
+
+// balanceRows lists the native balances and the balances of every token
+func (bc *Blockchain) balanceRows() []*storage.BalanceRow {
+	rows := make([]*storage.BalanceRow, 0, len(bc.Balances))
+	for address, balance := range bc.Balances {
+		rows = append(rows, &storage.BalanceRow{Address: address, Balance: balance.String()})
+	}
+	for symbol, token := range bc.Tokens {
+		for address, balance := range token.Balances {
+			rows = append(rows, &storage.BalanceRow{Address: address, Token: symbol, Balance: bigOrZero(balance).String()})
+		}
+	}
+	return rows
+}
+
+// proposalRows lists the proposals of every token
+func (bc *Blockchain) proposalRows() []*storage.ProposalRow {
+	var rows []*storage.ProposalRow
+	for symbol, token := range bc.Tokens {
+		for _, p := range token.Proposals {
+			rows = append(rows, &storage.ProposalRow{
+				Token:       symbol,
+				ID:          p.ID,
+				Title:       p.Title,
+				Description: p.Description,
+				Status:      p.Status,
+				YesVotes:    bigOrZero(p.YesVotes).String(),
+				NoVotes:     bigOrZero(p.NoVotes).String(),
+			})
+		}
+	}
+	return rows
+}
+
+// txAt returns the transaction at a location the store's tables returned,
+// checking that the location is in the chain
+func (bc *Blockchain) txAt(ref *storage.TxRef) (*Transaction, bool) {
+	if ref.Height < 0 || ref.Height >= len(bc.Blocks) {
+		return nil, false
+	}
+	block := bc.Blocks[ref.Height]
+	if block.Hash != ref.BlockHash || ref.Position < 0 || ref.Position >= len(block.Transactions) {
+		return nil, false
+	}
+	return block.Transactions[ref.Position], true
+}
+
+// locateTx finds a confirmed transaction in the store's tables when they are
+// current, otherwise in the in-memory indexes
+func (bc *Blockchain) locateTx(txHash string) (*Transaction, *index.TxLocation, bool) {
+	if store, ok := bc.queryStore(); ok {
+		ref, err := store.TxLocation(txHash)
+		if err == nil {
+			if tx, ok := bc.txAt(ref); ok {
+				return tx, &index.TxLocation{BlockIndex: ref.Height, BlockHash: ref.BlockHash, Position: ref.Position}, true
+			}
+		} else if err != storage.ErrNotFound {
+			fmt.Printf("Falling back to in-memory index: %v\n", err)
+		} else {
+			return nil, nil, false
+		}
+	}
+	location, ok := bc.indexes.TxLocation(txHash)
+	if !ok {
+		return nil, nil, false
+	}
+	return bc.Blocks[location.BlockIndex].Transactions[location.Position], location, true
+}
+
+// GetReceipt returns the receipt of a confirmed transaction
+func (bc *Blockchain) GetReceipt(txHash string) (*Receipt, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	tx, location, ok := bc.locateTx(txHash)
+	if !ok {
+		return nil, fmt.Errorf("transaction %s not found", txHash)
+	}
+	if store, ok := bc.queryStore(); ok {
+		row, err := store.Receipt(location.BlockIndex, location.Position)
+		if err == nil {
+			return &Receipt{
+				TxHash:       txHash,
+				BlockIndex:   row.Height,
+				BlockHash:    row.BlockHash,
+				Position:     row.Position,
+				FeeBurned:    row.FeeBurned,
+				Tip:          row.Tip,
+				TipRecipient: row.TipRecipient,
+			}, nil
+		}
+		fmt.Printf("Falling back to in-memory receipt: %v\n", err)
+	}
+
+	block := bc.Blocks[location.BlockIndex]
+	burned, tip := txFees(tx, block.BaseFee)
+	return &Receipt{
+		TxHash:       txHash,
+		BlockIndex:   location.BlockIndex,
+		BlockHash:    location.BlockHash,
+		Position:     location.Position,
+		FeeBurned:    burned.String(),
+		Tip:          tip.String(),
+		TipRecipient: bc.blockAuthor(block),
+	}, nil
+}
+
+// GetTokenProposals returns the governance proposals of a token with their votes
+func (bc *Blockchain) GetTokenProposals(tokenSymbol string) ([]*TokenProposal, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	token, ok := bc.Tokens[tokenSymbol]
+	if !ok {
+		return nil, fmt.Errorf("token %s not found", tokenSymbol)
+	}
+	if store, ok := bc.queryStore(); ok {
+		proposals, err := storedProposals(store, tokenSymbol)
+		if err == nil {
+			return proposals, nil
+		}
+		fmt.Printf("Falling back to in-memory proposals: %v\n", err)
+	}
+
+	// Votes are only recorded in the blocks, so they are collected by a scan
+	votes := make(map[string][]*ProposalVote)
+	for _, block := range bc.Blocks {
+		for position, tx := range block.Transactions {
+			if proposalID, yes, ok := parseVote(tx); ok && tx.TokenSymbol == tokenSymbol {
+				votes[proposalID] = append(votes[proposalID], &ProposalVote{Voter: tx.Sender, Yes: yes, BlockIndex: block.Index, Position: position})
+			}
+		}
+	}
+	proposals := make([]*TokenProposal, 0, len(token.Proposals))
+	for _, p := range token.Proposals {
+		proposals = append(proposals, &TokenProposal{
+			ID:          p.ID,
+			Title:       p.Title,
+			Description: p.Description,
+			Status:      p.Status,
+			YesVotes:    bigOrZero(p.YesVotes).String(),
+			NoVotes:     bigOrZero(p.NoVotes).String(),
+			Votes:       append([]*ProposalVote{}, votes[p.ID]...),
+		})
+	}
+	return proposals, nil
+}
+
+// storedProposals reads the proposals of a token and their votes from the store's tables
+func storedProposals(store storage.IndexedStore, tokenSymbol string) ([]*TokenProposal, error) {
+	rows, err := store.Proposals(tokenSymbol)
+	if err != nil {
+		return nil, err
+	}
+	proposals := make([]*TokenProposal, 0, len(rows))
+	for _, row := range rows {
+		voteRows, err := store.Votes(tokenSymbol, row.ID)
+		if err != nil {
+			return nil, err
+		}
+		votes := make([]*ProposalVote, 0, len(voteRows))
+		for _, v := range voteRows {
+			votes = append(votes, &ProposalVote{Voter: v.Voter, Yes: v.Yes, BlockIndex: v.Height, Position: v.Position})
+		}
+		proposals = append(proposals, &TokenProposal{
+			ID:          row.ID,
+			Title:       row.Title,
+			Description: row.Description,
+			Status:      row.Status,
+			YesVotes:    row.YesVotes,
+			NoVotes:     row.NoVotes,
+			Votes:       votes,
+		})
+	}
+	return proposals, nil
+}
@@ -0,0 +1,220 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"tpy-blockchain/internal/common"
+	"tpy-blockchain/internal/consensus"
+)
+
+const (
+	// UnbondingPeriod is the number of blocks unstaked tokens stay locked
+	UnbondingPeriod = 100
+
+	// DoubleSignSlashPercent is the share of stake burned for signing two blocks at the same height
+	DoubleSignSlashPercent = 5
+
+	// RewardEpoch is the number of blocks after which validator reward pools are distributed
+	RewardEpoch = 10
+)
+
+// Evidence proves that a validator signed two different blocks at the same height
+type Evidence struct {
+	A *BlockHeader `json:"a"`
+	B *BlockHeader `json:"b"`
+}
+
+// NewEvidenceTransaction creates a transaction reporting a double-signing validator of a stake token
+func NewEvidenceTransaction(reporter, tokenSymbol string, a, b *BlockHeader) (*Transaction, error) {
+	data, err := json.Marshal(&Evidence{A: a, B: b})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode evidence: %v", err)
+	}
+	return NewUnsignedTransaction(TxTypeEvidence, reporter, "", big.NewInt(0), tokenSymbol, string(data)), nil
+}
+
+// applyStaking applies staking, delegation, reward and evidence transactions to a token's stake
+func (st *State) applyStaking(tx *Transaction) error {
+	token, ok := st.Tokens[tx.TokenSymbol]
+	if !ok {
+		return fmt.Errorf("token %s not found", tx.TokenSymbol)
+	}
+
+	switch tx.Type {
+	case TxTypeStake, TxTypeUnstake, TxTypeDelegate, TxTypeUndelegate, TxTypeRedelegate:
+		if tx.Amount == nil || tx.Amount.Sign() <= 0 {
+			return fmt.Errorf("%s amount must be positive", tx.Type)
+		}
+	}
+
+	switch tx.Type {
+	case TxTypeStake:
+		return token.Delegate(tx.Sender, tx.Sender, tx.Amount)
+	case TxTypeUnstake:
+		return token.Undelegate(tx.Sender, tx.Sender, tx.Amount, st.height+UnbondingPeriod)
+	case TxTypeDelegate:
+		return token.Delegate(tx.Sender, tx.Receiver, tx.Amount)
+	case TxTypeUndelegate:
+		return token.Undelegate(tx.Sender, tx.Receiver, tx.Amount, st.height+UnbondingPeriod)
+	case TxTypeRedelegate:
+		return token.Redelegate(tx.Sender, tx.Data, tx.Receiver, tx.Amount)
+	case TxTypeSetCommission:
+		rate, err := strconv.Atoi(tx.Data)
+		if err != nil {
+			return fmt.Errorf("invalid commission rate %q", tx.Data)
+		}
+		return token.SetCommission(tx.Sender, rate)
+	case TxTypeClaimRewards:
+		_, err := token.ClaimRewards(tx.Sender)
+		return err
+	default:
+		var evidence Evidence
+		if err := json.Unmarshal([]byte(tx.Data), &evidence); err != nil || evidence.A == nil || evidence.B == nil {
+			return fmt.Errorf("invalid evidence data")
+		}
+		if evidence.A.Index > st.height {
+			return fmt.Errorf("evidence is for future block %d", evidence.A.Index)
+		}
+		validator, err := consensus.VerifyDoubleSign(evidence.A, evidence.B)
+		if err != nil {
+			return fmt.Errorf("invalid evidence: %v", err)
+		}
+		if token.Jailed[validator] {
+			return fmt.Errorf("validator %s is already jailed", validator)
+		}
+		if token.ValidatorPower(validator).Sign() == 0 {
+			return fmt.Errorf("validator %s has no stake to slash", validator)
+		}
+		token.Slash(validator, DoubleSignSlashPercent)
+		return nil
+	}
+}
+
+// Validators returns the proof-of-stake validator set that proposes the next block
+func (bc *Blockchain) Validators() ([]*consensus.Validator, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	pos, ok := bc.engine.(*consensus.ProofOfStake)
+	if !ok {
+		return nil, fmt.Errorf("chain does not use proof-of-stake")
+	}
+	return pos.Validators(bc.view()), nil
+}
+
+// DelegatorInfo describes an address's stake and staking rewards in a token
+type DelegatorInfo struct {
+	Address        string              `json:"address"`
+	TokenSymbol    string              `json:"tokenSymbol"`
+	Stake          string              `json:"stake"`       // All stake bonded by the address
+	Delegations    map[string]string   `json:"delegations"` // Stake by validator
+	Unbonding      []*common.Unbonding `json:"unbonding"`
+	Rewards        string              `json:"rewards"`        // Distributed rewards that can be claimed
+	PendingRewards string              `json:"pendingRewards"` // Share of the current epoch's rewards, paid out at its end
+}
+
+// ValidatorInfo describes a validator of a token
+type ValidatorInfo struct {
+	Address     string `json:"address"`
+	TokenSymbol string `json:"tokenSymbol"`
+	SelfStake   string `json:"selfStake"`
+	Power       string `json:"power"`      // All stake bonded to the validator
+	Commission  int    `json:"commission"` // In basis points
+	Delegators  int    `json:"delegators"`
+	RewardPool  string `json:"rewardPool"` // Rewards of the current epoch, not yet distributed
+	Jailed      bool   `json:"jailed"`
+}
+
+// GetDelegator returns the stake, delegations and rewards of an address
+func (bc *Blockchain) GetDelegator(address, tokenSymbol string) (*DelegatorInfo, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	token, ok := bc.Tokens[tokenSymbol]
+	if !ok {
+		return nil, fmt.Errorf("token %s not found", tokenSymbol)
+	}
+
+	info := &DelegatorInfo{
+		Address:     address,
+		TokenSymbol: tokenSymbol,
+		Stake:       token.StakeOf(address).String(),
+		Delegations: make(map[string]string),
+		Unbonding:   []*common.Unbonding{},
+		Rewards:     bigOrZero(token.Accrued[address]).String(),
+	}
+	for validator, stake := range token.DelegationsOf(address) {
+		info.Delegations[validator] = stake.String()
+	}
+	for _, u := range token.Unbonding {
+		if u.Address == address {
+			info.Unbonding = append(info.Unbonding, u)
+		}
+	}
+
+	// Distributing on a copy gives the share of the current epoch earned so far
+	distributed := token.Copy()
+	distributed.DistributeRewards()
+	pending := new(big.Int).Sub(bigOrZero(distributed.Accrued[address]), bigOrZero(token.Accrued[address]))
+	info.PendingRewards = pending.String()
+	return info, nil
+}
+
+// GetValidator returns the stake, commission and reward pool of a validator
+func (bc *Blockchain) GetValidator(address, tokenSymbol string) (*ValidatorInfo, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+
+	token, ok := bc.Tokens[tokenSymbol]
+	if !ok {
+		return nil, fmt.Errorf("token %s not found", tokenSymbol)
+	}
+	if token.SelfStake(address).Sign() == 0 && !token.Jailed[address] {
+		return nil, fmt.Errorf("%s is not a validator", address)
+	}
+
+	delegators := 0
+	for _, delegations := range token.Delegations {
+		if _, ok := delegations[address]; ok {
+			delegators++
+		}
+	}
+	return &ValidatorInfo{
+		Address:     address,
+		TokenSymbol: tokenSymbol,
+		SelfStake:   token.SelfStake(address).String(),
+		Power:       token.ValidatorPower(address).String(),
+		Commission:  token.Commission[address],
+		Delegators:  delegators,
+		RewardPool:  bigOrZero(token.PendingRewards[address]).String(),
+		Jailed:      token.Jailed[address],
+	}, nil
+}
+
+// reportDoubleSign queues evidence when a block conflicts with the block the
+// chain already has at its height and both are signed by the same validator
+func (bc *Blockchain) reportDoubleSign(block *Block) {
+	pos, ok := bc.engine.(*consensus.ProofOfStake)
+	if !ok || block.Index <= 0 || block.Index >= len(bc.Blocks) {
+		return
+	}
+	existing := bc.Blocks[block.Index]
+	if existing.Hash == block.Hash {
+		return
+	}
+	validator, err := consensus.VerifyDoubleSign(existing.Header(), block.Header())
+	if err != nil {
+		return
+	}
+
+	tx, err := NewEvidenceTransaction("", pos.StakeToken, existing.Header(), block.Header())
+	if err != nil {
+		return
+	}
+	if _, err := bc.addTransaction(tx); err != nil {
+		return
+	}
+	fmt.Printf("Detected double-signing by %s at block %d, evidence queued\n", validator, block.Index)
+}
@@ -0,0 +1,264 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"tpy-blockchain/internal/common"
+	"tpy-blockchain/internal/consensus"
+	"tpy-blockchain/pkg/crypto"
+)
+
+// State holds everything derived from applying blocks: native balances and
+// token balances and governance. It is only changed by connecting blocks.
+type State struct {
+	Balances map[string]*big.Int             `json:"balances"`
+	Tokens   map[string]*common.UtilityToken `json:"tokens"`
+	Nonces   map[string]uint64               `json:"nonces"` // Nonce of each sender's latest confirmed transaction
+	height   int                             // Index of the block being applied
+	config   *consensus.ChainConfig          // Upgrades that change how transactions apply
+	baseFee  *big.Int                        // Base fee of the block being applied
+	author   string                          // Receives the tips of the block being applied, see consensus.Engine.Author
+}
+
+// NewState creates an empty state
+func NewState() *State {
+	return &State{
+		Balances: make(map[string]*big.Int),
+		Tokens:   make(map[string]*common.UtilityToken),
+		Nonces:   make(map[string]uint64),
+	}
+}
+
+// Copy returns a deep copy of the state
+func (st *State) Copy() *State {
+	cp := NewState()
+	cp.height = st.height
+	cp.config = st.config
+	cp.baseFee = st.baseFee
+	cp.author = st.author
+	for address, balance := range st.Balances {
+		if balance != nil {
+			cp.Balances[address] = new(big.Int).Set(balance)
+		}
+	}
+	for symbol, token := range st.Tokens {
+		cp.Tokens[symbol] = token.Copy()
+	}
+	for address, nonce := range st.Nonces {
+		cp.Nonces[address] = nonce
+	}
+	return cp
+}
+
+// Root computes the Merkle root of the balances in the state
+func (st *State) Root() string {
+	_, leaves := stateTree(stateEntries(st))
+	return crypto.MerkleRoot(leaves)
+}
+
+// balancesFor returns the balance map holding the given token, native balances for ""
+func (st *State) balancesFor(tokenSymbol string) (map[string]*big.Int, error) {
+	if tokenSymbol == "" {
+		return st.Balances, nil
+	}
+	token, ok := st.Tokens[tokenSymbol]
+	if !ok {
+		return nil, fmt.Errorf("token %s not found", tokenSymbol)
+	}
+	if token.Balances == nil {
+		token.Balances = make(map[string]*big.Int)
+	}
+	return token.Balances, nil
+}
+
+// credit adds amount to the balance of address
+func credit(balances map[string]*big.Int, address string, amount *big.Int) {
+	if _, ok := balances[address]; !ok {
+		balances[address] = big.NewInt(0)
+	}
+	balances[address].Add(balances[address], amount)
+}
+
+// debit subtracts amount from the balance of address
+func debit(balances map[string]*big.Int, address string, amount *big.Int) error {
+	balance, ok := balances[address]
+	if !ok || balance.Cmp(amount) < 0 {
+		return fmt.Errorf("insufficient balance for sender %s", address)
+	}
+	balance.Sub(balance, amount)
+	return nil
+}
+
+// addBig returns total + amount, treating a nil total as zero
+func addBig(total, amount *big.Int) *big.Int {
+	if total == nil {
+		total = big.NewInt(0)
+	}
+	return total.Add(total, amount)
+}
+
+// applyTransaction applies the effects of a transaction to the state.
+// On error the state may be partially modified, so callers apply to a copy.
+func (st *State) applyTransaction(tx *Transaction) error {
+	if required := txVersion(st.config, st.height); tx.Version != required {
+		return fmt.Errorf("transaction %s has version %d, version %d is required", tx.Hash, tx.Version, required)
+	}
+	if tx.Hash != tx.calculateHash() {
+		return fmt.Errorf("transaction hash mismatch: %s", tx.Hash)
+	}
+	if hasSender(tx) {
+		if err := tx.verifySignature(); err != nil {
+			return err
+		}
+		if expected := st.Nonces[tx.Sender] + 1; tx.Nonce != expected {
+			return fmt.Errorf("transaction %s has nonce %d, expected %d", tx.Hash, tx.Nonce, expected)
+		}
+		st.Nonces[tx.Sender] = tx.Nonce
+	}
+	if paysFee(tx) && st.upgradeActive(consensus.UpgradeFeeMarket) {
+		if err := st.chargeFee(tx); err != nil {
+			return err
+		}
+	}
+
+	switch tx.Type {
+	case TxTypeTransfer:
+		if tx.isCancellation() {
+			// Only uses up the nonce and pays the fee
+			return nil
+		}
+		if tx.Amount == nil || tx.Amount.Sign() <= 0 {
+			return fmt.Errorf("transaction amount must be positive")
+		}
+		balances, err := st.balancesFor(tx.TokenSymbol)
+		if err != nil {
+			return err
+		}
+		if err := debit(balances, tx.Sender, tx.Amount); err != nil {
+			return err
+		}
+		credit(balances, tx.Receiver, tx.Amount)
+	case TxTypeMint:
+		if tx.Amount == nil || tx.Amount.Sign() <= 0 {
+			return fmt.Errorf("mint amount must be positive")
+		}
+		balances, err := st.balancesFor(tx.TokenSymbol)
+		if err != nil {
+			return err
+		}
+		token, ok := st.Tokens[tx.TokenSymbol]
+		if ok {
+			issued := new(big.Int).Add(token.Issued(), tx.Amount)
+			if token.TotalSupply != nil && token.TotalSupply.Sign() > 0 && issued.Cmp(token.TotalSupply) > 0 {
+				return fmt.Errorf("mint would exceed the total supply of %s", tx.TokenSymbol)
+			}
+			token.Minted = addBig(token.Minted, tx.Amount)
+		}
+		if tx.Data == MintDataStakingReward {
+			if !ok {
+				return fmt.Errorf("staking rewards must be paid in a token")
+			}
+			token.AddReward(tx.Receiver, tx.Amount)
+		} else {
+			credit(balances, tx.Receiver, tx.Amount)
+		}
+	case TxTypeBurn:
+		if tx.Amount == nil || tx.Amount.Sign() <= 0 {
+			return fmt.Errorf("burn amount must be positive")
+		}
+		balances, err := st.balancesFor(tx.TokenSymbol)
+		if err != nil {
+			return err
+		}
+		if err := debit(balances, tx.Sender, tx.Amount); err != nil {
+			return err
+		}
+		if token, ok := st.Tokens[tx.TokenSymbol]; ok {
+			token.Burned = addBig(token.Burned, tx.Amount)
+		}
+	case TxTypeProposal, TxTypeVote, TxTypeCloseProposal:
+		return st.applyGovernance(tx)
+	case TxTypeStake, TxTypeUnstake, TxTypeEvidence, TxTypeDelegate, TxTypeUndelegate,
+		TxTypeRedelegate, TxTypeSetCommission, TxTypeClaimRewards:
+		return st.applyStaking(tx)
+	default:
+		return fmt.Errorf("unknown transaction type %q", tx.Type)
+	}
+	return nil
+}
+
+// applyGovernance applies proposal, vote and close transactions to the token's governance state
+func (st *State) applyGovernance(tx *Transaction) error {
+	token, ok := st.Tokens[tx.TokenSymbol]
+	if !ok {
+		return fmt.Errorf("token %s not found", tx.TokenSymbol)
+	}
+
+	switch tx.Type {
+	case TxTypeProposal:
+		parts := strings.SplitN(tx.Data, "\n", 2)
+		proposal := &common.Proposal{
+			ID:       tx.Hash,
+			Title:    parts[0],
+			Votes:    make(map[string]*big.Int),
+			YesVotes: big.NewInt(0),
+			NoVotes:  big.NewInt(0),
+			Status:   "Active",
+		}
+		if len(parts) == 2 {
+			proposal.Description = parts[1]
+		}
+		token.Proposals = append(token.Proposals, proposal)
+		return nil
+	case TxTypeVote:
+		separator := strings.LastIndex(tx.Data, ":")
+		if separator < 0 {
+			return fmt.Errorf("invalid vote data %q", tx.Data)
+		}
+		proposalID, choice := tx.Data[:separator], tx.Data[separator+1:]
+		if choice != "yes" && choice != "no" {
+			return fmt.Errorf("invalid vote choice %q", choice)
+		}
+		return token.Vote(proposalID, tx.Sender, choice == "yes")
+	default:
+		return token.CloseProposal(tx.Data)
+	}
+}
+
+// beginBlock prepares the state for the block with the given header and
+// author, releasing matured unbonding stake and distributing staking rewards
+// at the start of each epoch
+func (st *State) beginBlock(header *BlockHeader, author string) {
+	index := header.Index
+	st.height = index
+	st.baseFee = header.BaseFee
+	st.author = author
+	for _, token := range st.Tokens {
+		token.ReleaseUnbonded(index)
+		if index > 0 && index%RewardEpoch == 0 {
+			token.DistributeRewards()
+		}
+	}
+}
+
+// upgradeActive reports whether the named upgrade applies to the block being applied
+func (st *State) upgradeActive(name string) bool {
+	return st.config.IsActive(name, st.height)
+}
+
+// applyBlock applies all transactions of a block in order, paying tips to
+// the block's author as named by the engine
+func (st *State) applyBlock(block *Block, engine consensus.Engine) error {
+	author, err := engine.Author(block.Header())
+	if err != nil {
+		return err
+	}
+	st.beginBlock(block.Header(), author)
+	for i, tx := range block.Transactions {
+		if err := st.applyTransaction(tx); err != nil {
+			return fmt.Errorf("transaction %d (%s): %v", i, tx.Hash, err)
+		}
+	}
+	return nil
+}
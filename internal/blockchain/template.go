@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"tpy-blockchain/internal/consensus"
+)
+
+// BlockTemplate is a block that is prepared and executed on top of the tip
+// but not yet sealed. It keeps a snapshot of the chain it was built on, so it
+// can be sealed without holding the chain's lock.
+type BlockTemplate struct {
+	Header       *BlockHeader
+	Transactions []*Transaction
+	view         chainView
+}
+
+// NewBlockTemplate prepares a block on top of the tip that pays any block
+// reward to coinbase and includes the pending transactions that still apply
+func (bc *Blockchain) NewBlockTemplate(coinbase string) (*BlockTemplate, error) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.newTemplate(bc.Transactions, coinbase, true)
+}
+
+// newTemplate prepares a block with the given transactions after the
+// engine's rewards. Transactions that fail to apply or don't fit in the
+// block's limits are left out if skipInvalid is set, and fail the template otherwise.
+func (bc *Blockchain) newTemplate(transactions []*Transaction, coinbase string, skipInvalid bool) (*BlockTemplate, error) {
+	view := chainView{blocks: append([]*Block(nil), bc.Blocks...), state: bc.State}
+	header := &consensus.Header{
+		Version:      bc.config.BlockVersion(len(bc.Blocks)),
+		Index:        len(bc.Blocks),
+		Timestamp:    time.Now().String(),
+		PreviousHash: bc.Blocks[len(bc.Blocks)-1].Hash,
+		Coinbase:     coinbase,
+		BaseFee:      calcBaseFee(bc.config, bc.Blocks[len(bc.Blocks)-1]),
+	}
+	if err := bc.engine.Prepare(view, header); err != nil {
+		return nil, fmt.Errorf("failed to prepare block: %v", err)
+	}
+
+	included := rewardTransactions(bc.engine.Finalize(view, header), txVersion(bc.config, header.Index))
+	newState := bc.State.Copy()
+	author, err := bc.engine.Author(header)
+	if err != nil {
+		return nil, err
+	}
+	newState.beginBlock(header, author)
+	for _, tx := range included {
+		if err := newState.applyTransaction(tx); err != nil {
+			return nil, fmt.Errorf("invalid block reward %s: %v", tx.Hash, err)
+		}
+	}
+	// Transactions paying higher tips go first
+	if skipInvalid && header.BaseFee != nil {
+		transactions = sortByTip(transactions, header.BaseFee)
+	}
+
+	// Each further transaction adds its size plus a separator to the block
+	size := (&BlockTemplate{Transactions: included}).Block(header).Size() + sealReserve
+	for _, tx := range transactions {
+		if !skipInvalid {
+			if err := newState.applyTransaction(tx); err != nil {
+				return nil, fmt.Errorf("invalid transaction %s: %v", tx.Hash, err)
+			}
+			included = append(included, tx)
+			continue
+		}
+
+		txSize := tx.Size() + 1
+		if len(included) >= bc.config.BlockTransactionLimit() {
+			break
+		}
+		if checkTxLimits(bc.config, tx) != nil || size+txSize > bc.config.BlockSizeLimit() {
+			continue
+		}
+
+		// Apply to a copy so a failing transaction leaves no partial changes
+		trial := newState.Copy()
+		if err := trial.applyTransaction(tx); err != nil {
+			continue
+		}
+		newState = trial
+		included = append(included, tx)
+		size += txSize
+	}
+	header.MerkleRoot = TransactionsRoot(included)
+	header.StateRoot = newState.Root()
+
+	template := &BlockTemplate{Header: header, Transactions: included, view: view}
+	if !skipInvalid {
+		// Fail before sealing rather than when the block is connected
+		block := template.Block(header)
+		if err := checkBlockLimits(bc.config, block); err != nil {
+			return nil, err
+		}
+	}
+	return template, nil
+}
+
+// SealBlock seals a template with the consensus engine, e.g. by mining it,
+// and returns the block. It stops with ctx's error if ctx is cancelled. The
+// block still has to be connected with ConnectBlock.
+func (bc *Blockchain) SealBlock(ctx context.Context, template *BlockTemplate) (*Block, error) {
+	header := template.Header.Copy()
+	if err := bc.engine.Seal(ctx, template.view, header); err != nil {
+		return nil, fmt.Errorf("failed to seal block: %v", err)
+	}
+	return template.Block(header), nil
+}
+
+// Block returns the block of the template with a header sealed outside the
+// chain, e.g. by an external miner. The block still has to be connected with ConnectBlock.
+func (template *BlockTemplate) Block(header *BlockHeader) *Block {
+	block := &Block{Transactions: template.Transactions}
+	block.applyHeader(header)
+	return block
+}
@@ -3,14 +3,44 @@ package blockchain
 import (
 	"crypto/ecdsa"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"math/big"
+	"strconv"
+	"tpy-blockchain/internal/consensus"
 	"tpy-blockchain/internal/wallet"
 
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
+// Transaction types. Transfers leave Type empty so existing hashes stay valid.
+const (
+    TxTypeTransfer      = ""
+    TxTypeMint          = "mint"           // Credits new tokens to Receiver, only valid inside blocks
+    TxTypeBurn          = "burn"           // Destroys tokens held by Sender
+    TxTypeProposal      = "proposal"       // Opens a governance proposal, Data is "title\ndescription"
+    TxTypeVote          = "vote"           // Votes on a proposal, Data is "proposalID:yes" or "proposalID:no"
+    TxTypeCloseProposal = "close_proposal" // Closes a proposal, Data is the proposal ID
+    TxTypeStake         = "stake"          // Bonds Amount of Sender's tokens to Sender itself as validator
+    TxTypeUnstake       = "unstake"        // Starts unbonding Amount of Sender's self-stake
+    TxTypeEvidence      = "evidence"       // Reports a double-signing validator, Data is the JSON encoded Evidence
+    TxTypeDelegate      = "delegate"       // Bonds Amount of Sender's tokens to the validator Receiver
+    TxTypeUndelegate    = "undelegate"     // Starts unbonding Amount of Sender's stake with the validator Receiver
+    TxTypeRedelegate    = "redelegate"     // Moves Amount of Sender's stake from the validator in Data to Receiver
+    TxTypeSetCommission = "set_commission" // Sets Sender's validator commission, Data is the rate in basis points
+    TxTypeClaimRewards  = "claim_rewards"  // Moves Sender's distributed staking rewards into its balance
+
+    // MintDataStakingReward marks a mint that pays a validator's reward pool instead of its balance
+    MintDataStakingReward = "staking_reward"
+)
+
+// Transaction versions, which select how the hash is calculated
+const (
+    TxVersionLegacy    = 0 // Colon-joined fields, before consensus.UpgradeCanonicalTxHash
+    TxVersionCanonical = 1 // Every field length-prefixed in a fixed order
+)
+
 type Transaction struct {
     Sender      string
     Receiver    string
@@ -18,6 +48,12 @@ type Transaction struct {
     Signature   string
     Hash        string
     TokenSymbol string
+    Type        string `json:",omitempty"`
+    Data        string `json:",omitempty"`
+    MaxFee      *big.Int `json:",omitempty"` // Most the sender pays in base fee and tip, from native balance
+    Nonce       uint64   `json:",omitempty"` // Sender's count of transactions including this one, 0 for mints and evidence
+    PriorityFee *big.Int `json:",omitempty"` // Most tip offered to the block producer
+    Version     int      `json:",omitempty"` // How the hash is calculated, see TxVersionCanonical
 }
 
 // NewTransaction creates a new transaction, validates balances, and ensures token compatibility.
@@ -48,13 +84,145 @@ func NewTransaction(senderWallet *wallet.Wallet, receiver string, amount *big.In
     return tx, nil
 }
 
+// NewUnsignedTransaction creates a transaction that carries no signature. Only
+// mints and evidence are valid without one, others have to be signed with Sign.
+func NewUnsignedTransaction(txType, sender, receiver string, amount *big.Int, tokenSymbol, data string) *Transaction {
+    tx := &Transaction{
+        Sender:      sender,
+        Receiver:    receiver,
+        Amount:      amount,
+        TokenSymbol: tokenSymbol,
+        Type:        txType,
+        Data:        data,
+    }
+    tx.Hash = tx.calculateHash()
+    return tx
+}
+
+// NewCancelTransaction creates a transaction that replaces the sender's
+// pending transaction with the given nonce by a transfer of nothing to itself
+func NewCancelTransaction(sender string, nonce uint64) *Transaction {
+    tx := NewUnsignedTransaction(TxTypeTransfer, sender, sender, big.NewInt(0), "", "")
+    tx.SetNonce(nonce)
+    return tx
+}
+
+// SetNonce sets the transaction's nonce and updates the hash. Signed
+// transactions have to be signed again afterwards.
+func (tx *Transaction) SetNonce(nonce uint64) {
+    tx.Nonce = nonce
+    tx.Hash = tx.calculateHash()
+}
+
+// Sign signs the transaction's hash with the sender's wallet. The hash covers
+// the nonce and fees, so they have to be set before.
+func (tx *Transaction) Sign(w *wallet.Wallet) error {
+    if w.Address != tx.Sender {
+        return fmt.Errorf("wallet %s cannot sign for sender %s", w.Address, tx.Sender)
+    }
+    signature, err := w.Sign([]byte(tx.Hash))
+    if err != nil {
+        return fmt.Errorf("failed to sign transaction: %v", err)
+    }
+    tx.Signature = signature
+    return nil
+}
+
+// hasSender reports whether a transaction is sent, and so signed, by its
+// Sender. Block rewards and double-sign evidence are not.
+func hasSender(tx *Transaction) bool {
+    return tx.Type != TxTypeMint && tx.Type != TxTypeEvidence
+}
+
+// verifySignature checks that the transaction's hash is signed by the key of
+// its sender, see wallet.Sign
+func (tx *Transaction) verifySignature() error {
+    signature, err := hex.DecodeString(tx.Signature)
+    if err != nil || len(signature) != crypto.SignatureLength {
+        return fmt.Errorf("transaction %s is not signed", tx.Hash)
+    }
+    pubKey, err := crypto.SigToPub(crypto.Keccak256([]byte(tx.Hash)), signature)
+    if err != nil {
+        return fmt.Errorf("transaction %s has an invalid signature: %v", tx.Hash, err)
+    }
+    if signer := crypto.PubkeyToAddress(*pubKey).Hex(); signer != tx.Sender {
+        return fmt.Errorf("transaction %s is signed by %s, not by its sender %s", tx.Hash, signer, tx.Sender)
+    }
+    return nil
+}
+
+// isCancellation reports whether the transaction only uses up its nonce
+func (tx *Transaction) isCancellation() bool {
+    return tx.Type == TxTypeTransfer && tx.Nonce > 0 && tx.Sender == tx.Receiver && tx.Amount != nil && tx.Amount.Sign() == 0
+}
+
+// SetVersion sets how the transaction's hash is calculated and updates the
+// hash. Signed transactions have to be signed again afterwards.
+func (tx *Transaction) SetVersion(version int) {
+    tx.Version = version
+    tx.Hash = tx.calculateHash()
+}
+
+// txVersion returns the version transactions must have in the block at height
+func txVersion(config *consensus.ChainConfig, height int) int {
+    if config.IsActive(consensus.UpgradeCanonicalTxHash, height) {
+        return TxVersionCanonical
+    }
+    return TxVersionLegacy
+}
+
+// SetFees sets the fees the sender is willing to pay and updates the hash.
+// Signed transactions have to be signed again afterwards.
+func (tx *Transaction) SetFees(maxFee, priorityFee *big.Int) {
+    tx.MaxFee = maxFee
+    tx.PriorityFee = priorityFee
+    tx.Hash = tx.calculateHash()
+}
+
 func (tx *Transaction) calculateHash() string {
+    if tx.Version != TxVersionLegacy {
+        return tx.canonicalHash()
+    }
     record := fmt.Sprintf("%s:%s:%s:%s", tx.Sender, tx.Receiver, tx.Amount.String(), tx.TokenSymbol)
+    if tx.Type != TxTypeTransfer {
+        record += fmt.Sprintf(":%s:%s", tx.Type, tx.Data)
+    }
+    if tx.MaxFee != nil {
+        record += fmt.Sprintf(":fee:%s:%s", tx.MaxFee, bigOrZero(tx.PriorityFee))
+    }
+    if tx.Nonce > 0 {
+        record += fmt.Sprintf(":nonce:%d", tx.Nonce)
+    }
     h := sha256.New()
     h.Write([]byte(record))
     return hex.EncodeToString(h.Sum(nil))
 }
 
+// canonicalHash hashes every field of the transaction but the hash and
+// signature, each prefixed with its length, so no two transactions share a
+// record. Nil amounts are empty, unlike zero.
+func (tx *Transaction) canonicalHash() string {
+    h := sha256.New()
+    for _, field := range []string{
+        strconv.Itoa(tx.Version),
+        tx.Sender,
+        tx.Receiver,
+        bigString(tx.Amount),
+        tx.TokenSymbol,
+        tx.Type,
+        tx.Data,
+        bigString(tx.MaxFee),
+        strconv.FormatUint(tx.Nonce, 10),
+        bigString(tx.PriorityFee),
+    } {
+        var length [4]byte
+        binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+        h.Write(length[:])
+        h.Write([]byte(field))
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
 func VerifySignature(publicKey *ecdsa.PublicKey, signatureHex string, data []byte) bool {
     sigBytes, err := hex.DecodeString(signatureHex)
     if err != nil {
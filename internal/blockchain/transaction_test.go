@@ -0,0 +1,75 @@
+package blockchain
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"tpy-blockchain/internal/consensus"
+)
+
+func TestCanonicalHashSeparatesFields(t *testing.T) {
+	// Both join to "a:b:1:" in the legacy record
+	first := NewUnsignedTransaction(TxTypeTransfer, "a:b", "", big.NewInt(1), "", "")
+	second := NewUnsignedTransaction(TxTypeTransfer, "a", "b:", big.NewInt(1), "", "")
+	if first.Hash != second.Hash {
+		t.Fatalf("expected the legacy hashes to collide")
+	}
+
+	first.SetVersion(TxVersionCanonical)
+	second.SetVersion(TxVersionCanonical)
+	if first.Hash == second.Hash {
+		t.Fatalf("canonical hashes of different transactions collide")
+	}
+	if legacy := NewUnsignedTransaction(TxTypeTransfer, "a:b", "", big.NewInt(1), "", ""); legacy.Hash == first.Hash {
+		t.Fatalf("canonical and legacy hash of a transaction are the same")
+	}
+
+	zero := NewUnsignedTransaction(TxTypeTransfer, "a", "b", big.NewInt(0), "", "")
+	zero.SetVersion(TxVersionCanonical)
+	none := NewUnsignedTransaction(TxTypeTransfer, "a", "b", nil, "", "")
+	none.SetVersion(TxVersionCanonical)
+	if zero.Hash == none.Hash {
+		t.Fatalf("zero and nil amounts hash the same")
+	}
+}
+
+func TestTransactionVersionFollowsUpgrade(t *testing.T) {
+	bc := newTestChain(t, t.TempDir(), newTestEngine())
+	config, err := consensus.NewChainConfig([]*consensus.Upgrade{{Name: consensus.UpgradeCanonicalTxHash, Height: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.SetChainConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	addBlock(t, bc, nil, alice.Address)
+
+	early := signedTransfer(t, bc, alice, bob.Address, 5)
+	early.SetVersion(TxVersionCanonical)
+	if err := early.Sign(alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlockWithCoinbase([]*Transaction{early}, alice.Address); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Fatalf("canonical transaction before the upgrade was not rejected for its version: %v", err)
+	}
+	addBlock(t, bc, []*Transaction{signedTransfer(t, bc, alice, bob.Address, 5)}, alice.Address)
+
+	if bc.TxVersion() != TxVersionCanonical {
+		t.Fatalf("next block takes version %d transactions, expected %d", bc.TxVersion(), TxVersionCanonical)
+	}
+	late := signedTransfer(t, bc, alice, bob.Address, 5)
+	late.SetVersion(TxVersionLegacy)
+	if err := late.Sign(alice); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.AddBlockWithCoinbase([]*Transaction{late}, alice.Address); err == nil || !strings.Contains(err.Error(), "version") {
+		t.Fatalf("legacy transaction after the upgrade was not rejected for its version: %v", err)
+	}
+	block := addBlock(t, bc, []*Transaction{signedTransfer(t, bc, alice, bob.Address, 5)}, alice.Address)
+	for _, tx := range block.Transactions {
+		if tx.Version != TxVersionCanonical {
+			t.Fatalf("transaction %s of block %d has version %d", tx.Hash, block.Index, tx.Version)
+		}
+	}
+}
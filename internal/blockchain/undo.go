@@ -0,0 +1,262 @@
+package blockchain
+
+import (
+	"math/big"
+	"reflect"
+	"tpy-blockchain/internal/common"
+)
+
+// undoDepth is how many of the latest blocks can be disconnected, and so the
+// deepest a chain can be reorganized without replaying it
+const undoDepth = 100
+
+// stateDiff holds the entries a block changed in the state, as the values of
+// the state it turns into, so disconnecting a block or loading the state
+// after it costs as much as connecting it
+type stateDiff struct {
+	Balances map[string]*big.Int   `json:"balances,omitempty"` // Native balances, nil where the target has none
+	Nonces   map[string]uint64     `json:"nonces,omitempty"`   // Zero where the target has none
+	Tokens   map[string]*tokenDiff `json:"tokens,omitempty"`
+}
+
+// tokenDiff holds the changes to one token, entry by entry. Lists are
+// recorded whole, and only when they change.
+type tokenDiff struct {
+	Removed        bool                           `json:"removed,omitempty"` // The target has no such token
+	Token          *common.UtilityToken           `json:"token,omitempty"`   // The fields that are neither maps nor lists, nil if unchanged
+	Proposals      []*common.Proposal             `json:"proposals"`         // Nil if unchanged
+	Unbonding      []*common.Unbonding            `json:"unbonding"`         // Nil if unchanged
+	Balances       map[string]*big.Int            `json:"balances,omitempty"`
+	VotingPower    map[string]*big.Int            `json:"votingPower,omitempty"`
+	PendingRewards map[string]*big.Int            `json:"pendingRewards,omitempty"`
+	Accrued        map[string]*big.Int            `json:"accrued,omitempty"`
+	Delegations    map[string]map[string]*big.Int `json:"delegations,omitempty"` // Per delegator, as balances are
+	Commission     map[string]*int                `json:"commission,omitempty"`  // Nil where the target has none
+	Jailed         map[string]bool                `json:"jailed,omitempty"`      // False where the target has none
+}
+
+// diffState records the entries of to that differ in from, so applying the
+// diff to from gives to. Neither state may be changed afterwards, as the diff
+// shares values with to.
+func diffState(from, to *State) *stateDiff {
+	diff := &stateDiff{
+		Balances: diffBalances(from.Balances, to.Balances),
+		Nonces:   make(map[string]uint64),
+		Tokens:   make(map[string]*tokenDiff),
+	}
+	for address, nonce := range from.Nonces {
+		if to.Nonces[address] != nonce {
+			diff.Nonces[address] = to.Nonces[address]
+		}
+	}
+	for address, nonce := range to.Nonces {
+		if _, ok := from.Nonces[address]; !ok {
+			diff.Nonces[address] = nonce
+		}
+	}
+
+	for symbol, token := range from.Tokens {
+		if _, ok := to.Tokens[symbol]; !ok {
+			diff.Tokens[symbol] = &tokenDiff{Removed: true}
+			continue
+		}
+		if td := diffToken(token, to.Tokens[symbol]); td != nil {
+			diff.Tokens[symbol] = td
+		}
+	}
+	for symbol, target := range to.Tokens {
+		if _, ok := from.Tokens[symbol]; !ok {
+			diff.Tokens[symbol] = diffToken(nil, target)
+		}
+	}
+	return diff
+}
+
+// diffToken returns the changes that turn from into to, nil if there are
+// none. A nil from is a token the source state lacks.
+func diffToken(from, to *common.UtilityToken) *tokenDiff {
+	source := from
+	if source == nil {
+		source = &common.UtilityToken{}
+	}
+	td := &tokenDiff{
+		Balances:       diffBalances(source.Balances, to.Balances),
+		VotingPower:    diffBalances(source.VotingPower, to.VotingPower),
+		PendingRewards: diffBalances(source.PendingRewards, to.PendingRewards),
+		Accrued:        diffBalances(source.Accrued, to.Accrued),
+		Delegations:    make(map[string]map[string]*big.Int),
+		Commission:     make(map[string]*int),
+		Jailed:         make(map[string]bool),
+	}
+	if fields := withoutEntries(to); from == nil || !reflect.DeepEqual(fields, withoutEntries(from)) {
+		td.Token = fields
+	}
+	if len(source.Proposals)+len(to.Proposals) > 0 && !reflect.DeepEqual(source.Proposals, to.Proposals) {
+		td.Proposals = append([]*common.Proposal{}, to.Proposals...)
+	}
+	if len(source.Unbonding)+len(to.Unbonding) > 0 && !reflect.DeepEqual(source.Unbonding, to.Unbonding) {
+		td.Unbonding = append([]*common.Unbonding{}, to.Unbonding...)
+	}
+
+	for delegator, delegations := range source.Delegations {
+		if changed := diffBalances(delegations, to.Delegations[delegator]); len(changed) > 0 {
+			td.Delegations[delegator] = changed
+		}
+	}
+	for delegator, delegations := range to.Delegations {
+		if _, ok := source.Delegations[delegator]; !ok && len(delegations) > 0 {
+			td.Delegations[delegator] = diffBalances(nil, delegations)
+		}
+	}
+	for validator, rate := range source.Commission {
+		if target, ok := to.Commission[validator]; !ok {
+			td.Commission[validator] = nil
+		} else if target != rate {
+			td.Commission[validator] = &target
+		}
+	}
+	for validator, rate := range to.Commission {
+		if _, ok := source.Commission[validator]; !ok {
+			td.Commission[validator] = &rate
+		}
+	}
+	for address := range source.Jailed {
+		if !to.Jailed[address] {
+			td.Jailed[address] = false
+		}
+	}
+	for address := range to.Jailed {
+		if !source.Jailed[address] {
+			td.Jailed[address] = true
+		}
+	}
+
+	if td.Token == nil && td.Proposals == nil && td.Unbonding == nil && len(td.Balances) == 0 && len(td.VotingPower) == 0 &&
+		len(td.PendingRewards) == 0 && len(td.Accrued) == 0 && len(td.Delegations) == 0 && len(td.Commission) == 0 && len(td.Jailed) == 0 {
+		return nil
+	}
+	return td
+}
+
+// diffBalances returns the balances of to that differ in from, with nil for
+// those only from has
+func diffBalances(from, to map[string]*big.Int) map[string]*big.Int {
+	diff := make(map[string]*big.Int)
+	for address, balance := range from {
+		if target, ok := to[address]; !ok || target.Cmp(balance) != 0 {
+			diff[address] = target
+		}
+	}
+	for address, target := range to {
+		if _, ok := from[address]; !ok {
+			diff[address] = target
+		}
+	}
+	return diff
+}
+
+// withoutEntries returns a shallow copy of a token without its maps and lists
+func withoutEntries(token *common.UtilityToken) *common.UtilityToken {
+	rest := *token
+	rest.Balances, rest.VotingPower, rest.PendingRewards, rest.Accrued = nil, nil, nil, nil
+	rest.Delegations, rest.Commission, rest.Jailed = nil, nil, nil
+	rest.Proposals, rest.Unbonding = nil, nil
+	return &rest
+}
+
+// apply turns st, which must be the state the diff was taken from, into its target
+func (diff *stateDiff) apply(st *State) {
+	st.Balances = applyBalances(st.Balances, diff.Balances)
+	for address, nonce := range diff.Nonces {
+		if nonce == 0 {
+			delete(st.Nonces, address)
+		} else {
+			st.Nonces[address] = nonce
+		}
+	}
+
+	for symbol, td := range diff.Tokens {
+		if td.Removed {
+			delete(st.Tokens, symbol)
+			continue
+		}
+		st.Tokens[symbol] = td.apply(st.Tokens[symbol])
+	}
+}
+
+// apply returns the target of the token diff, which is token changed in
+// place unless its fields change. A nil token is one the source lacks.
+func (td *tokenDiff) apply(token *common.UtilityToken) *common.UtilityToken {
+	if token == nil {
+		token = td.Token.Copy()
+	} else if td.Token != nil {
+		fields := td.Token.Copy()
+		fields.Balances, fields.VotingPower, fields.PendingRewards, fields.Accrued = token.Balances, token.VotingPower, token.PendingRewards, token.Accrued
+		fields.Delegations, fields.Commission, fields.Jailed = token.Delegations, token.Commission, token.Jailed
+		fields.Proposals, fields.Unbonding = token.Proposals, token.Unbonding
+		token = fields
+	}
+	if td.Proposals != nil || td.Unbonding != nil {
+		// Copied, so changes to the state leave the diff alone
+		lists := (&common.UtilityToken{Proposals: td.Proposals, Unbonding: td.Unbonding}).Copy()
+		if td.Proposals != nil {
+			token.Proposals = lists.Proposals
+		}
+		if td.Unbonding != nil {
+			token.Unbonding = lists.Unbonding
+		}
+	}
+
+	token.Balances = applyBalances(token.Balances, td.Balances)
+	token.VotingPower = applyBalances(token.VotingPower, td.VotingPower)
+	token.PendingRewards = applyBalances(token.PendingRewards, td.PendingRewards)
+	token.Accrued = applyBalances(token.Accrued, td.Accrued)
+	if len(td.Delegations) > 0 && token.Delegations == nil {
+		token.Delegations = make(map[string]map[string]*big.Int)
+	}
+	for delegator, changed := range td.Delegations {
+		delegations := applyBalances(token.Delegations[delegator], changed)
+		if len(delegations) == 0 {
+			delete(token.Delegations, delegator)
+		} else {
+			token.Delegations[delegator] = delegations
+		}
+	}
+	if len(td.Commission) > 0 && token.Commission == nil {
+		token.Commission = make(map[string]int)
+	}
+	for validator, rate := range td.Commission {
+		if rate == nil {
+			delete(token.Commission, validator)
+		} else {
+			token.Commission[validator] = *rate
+		}
+	}
+	if len(td.Jailed) > 0 && token.Jailed == nil {
+		token.Jailed = make(map[string]bool)
+	}
+	for address, jailed := range td.Jailed {
+		if jailed {
+			token.Jailed[address] = true
+		} else {
+			delete(token.Jailed, address)
+		}
+	}
+	return token
+}
+
+// applyBalances sets the changed balances, creating the map if it is nil,
+// and returns it
+func applyBalances(balances, diff map[string]*big.Int) map[string]*big.Int {
+	if balances == nil {
+		balances = make(map[string]*big.Int)
+	}
+	for address, target := range diff {
+		if target == nil {
+			delete(balances, address)
+		} else {
+			balances[address] = new(big.Int).Set(target)
+		}
+	}
+	return balances
+}
@@ -0,0 +1,90 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+	"tpy-blockchain/internal/common"
+)
+
+func testToken(symbol string) *common.UtilityToken {
+	return &common.UtilityToken{
+		Name:        symbol,
+		Symbol:      symbol,
+		TotalSupply: big.NewInt(1000),
+		Balances:    map[string]*big.Int{"alice": big.NewInt(600), "bob": big.NewInt(400)},
+		VotingPower: map[string]*big.Int{"alice": big.NewInt(100)},
+		Proposals:   []*common.Proposal{{ID: "1", Title: "first", Votes: map[string]*big.Int{}, YesVotes: big.NewInt(0), NoVotes: big.NewInt(0), Status: "open"}},
+		Delegations: map[string]map[string]*big.Int{"alice": {"alice": big.NewInt(100)}},
+		Commission:  map[string]int{"alice": 500},
+		Minted:      big.NewInt(1000),
+	}
+}
+
+// roundTrip stores and loads a diff as blocks' derived data is
+func roundTrip(t *testing.T, diff *stateDiff) *stateDiff {
+	t.Helper()
+	data, err := json.Marshal(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded stateDiff
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatal(err)
+	}
+	return &loaded
+}
+
+func TestStateDiffUndoAndRedo(t *testing.T) {
+	before := NewState()
+	before.Balances["alice"] = big.NewInt(50)
+	before.Balances["carol"] = big.NewInt(7)
+	before.Nonces["alice"] = 3
+	before.Tokens["AAA"] = testToken("AAA")
+	before.Tokens["OLD"] = testToken("OLD")
+
+	after := before.Copy()
+	after.Balances["alice"] = big.NewInt(40)
+	after.Balances["bob"] = big.NewInt(10)
+	delete(after.Balances, "carol")
+	after.Nonces["alice"] = 4
+	after.Nonces["bob"] = 1
+	token := after.Tokens["AAA"]
+	token.Balances["bob"] = big.NewInt(300)
+	delete(token.Balances, "alice")
+	token.Delegations["bob"] = map[string]*big.Int{"alice": big.NewInt(100)}
+	delete(token.Delegations, "alice")
+	delete(token.Commission, "alice")
+	token.Jailed = map[string]bool{"alice": true}
+	token.Proposals[0].Status = "closed"
+	token.Unbonding = []*common.Unbonding{{Address: "alice", Validator: "alice", Amount: big.NewInt(100), Release: 9}}
+	token.Burned = big.NewInt(300)
+	delete(after.Tokens, "OLD")
+	after.Tokens["NEW"] = testToken("NEW")
+
+	redo := roundTrip(t, diffState(before, after))
+	undo := roundTrip(t, diffState(after, before))
+	if len(redo.Tokens["AAA"].Balances) != 2 {
+		t.Fatalf("redo data holds %d balances of AAA, expected only the 2 that changed", len(redo.Tokens["AAA"].Balances))
+	}
+	if redo.Tokens["AAA"].VotingPower != nil {
+		t.Fatalf("redo data holds the unchanged voting power of AAA")
+	}
+
+	st := before.Copy()
+	redo.apply(st)
+	if st.Root() != after.Root() {
+		t.Fatalf("redo gives state root %s, expected %s", st.Root(), after.Root())
+	}
+	undo.apply(st)
+	if st.Root() != before.Root() {
+		t.Fatalf("undo gives state root %s, expected %s", st.Root(), before.Root())
+	}
+
+	// Changing the state afterwards leaves the diff alone
+	st.Tokens["AAA"].Proposals[0].Status = "changed"
+	redo.apply(st)
+	if st.Root() != after.Root() {
+		t.Fatalf("second redo gives state root %s, expected %s", st.Root(), after.Root())
+	}
+}
@@ -10,14 +10,23 @@ import (
 )
 
 type UtilityToken struct {
-    Name         string
-    Symbol       string
-    TotalSupply  *big.Int
-    Decimals     uint
-    Balances     map[string]*big.Int
-    VotingPower  map[string]*big.Int
-    Proposals    []*Proposal
-    Address      string
+    Name           string
+    Symbol         string
+    TotalSupply    *big.Int
+    Decimals       uint
+    Balances       map[string]*big.Int
+    VotingPower    map[string]*big.Int            // Stake each address has bonded, to itself or to validators
+    Proposals      []*Proposal
+    Address        string
+    Minted         *big.Int                       // Sum of all mints, derived from the blocks
+    Burned         *big.Int                       // Sum of all burns, derived from the blocks
+    Slashed        *big.Int                       // Part of Burned taken from stake by slashing
+    Delegations    map[string]map[string]*big.Int // Stake by delegator and validator, self-stake is delegated to oneself
+    Commission     map[string]int                 // Validator commission in basis points
+    PendingRewards map[string]*big.Int            // Validator rewards of the current epoch, not yet distributed
+    Accrued        map[string]*big.Int            // Distributed rewards each address can claim
+    Unbonding      []*Unbonding                   // Stake waiting to return to balances
+    Jailed         map[string]bool                // Validators removed from the set for misbehaviour
 }
 
 type Proposal struct {
@@ -153,3 +162,87 @@ func (token *UtilityToken) CloseProposal(proposalID string) error {
     }
     return nil
 }
+
+// Copy returns a deep copy of the token, including balances and proposals.
+func (token *UtilityToken) Copy() *UtilityToken {
+    cp := *token
+    if token.TotalSupply != nil {
+        cp.TotalSupply = new(big.Int).Set(token.TotalSupply)
+    }
+    if token.Minted != nil {
+        cp.Minted = new(big.Int).Set(token.Minted)
+    }
+    if token.Burned != nil {
+        cp.Burned = new(big.Int).Set(token.Burned)
+    }
+    if token.Slashed != nil {
+        cp.Slashed = new(big.Int).Set(token.Slashed)
+    }
+    cp.Balances = copyBigIntMap(token.Balances)
+    cp.VotingPower = copyBigIntMap(token.VotingPower)
+    cp.Delegations = make(map[string]map[string]*big.Int, len(token.Delegations))
+    for delegator, delegations := range token.Delegations {
+        cp.Delegations[delegator] = copyBigIntMap(delegations)
+    }
+    cp.Commission = make(map[string]int, len(token.Commission))
+    for validator, rate := range token.Commission {
+        cp.Commission[validator] = rate
+    }
+    cp.PendingRewards = copyBigIntMap(token.PendingRewards)
+    cp.Accrued = copyBigIntMap(token.Accrued)
+    cp.Unbonding = make([]*Unbonding, len(token.Unbonding))
+    for i, u := range token.Unbonding {
+        cp.Unbonding[i] = &Unbonding{Address: u.Address, Validator: u.Validator, Amount: new(big.Int).Set(u.Amount), Release: u.Release}
+    }
+    cp.Jailed = make(map[string]bool, len(token.Jailed))
+    for address := range token.Jailed {
+        cp.Jailed[address] = true
+    }
+    cp.Proposals = make([]*Proposal, len(token.Proposals))
+    for i, p := range token.Proposals {
+        proposal := *p
+        proposal.Votes = copyBigIntMap(p.Votes)
+        proposal.YesVotes = new(big.Int).Set(p.YesVotes)
+        proposal.NoVotes = new(big.Int).Set(p.NoVotes)
+        cp.Proposals[i] = &proposal
+    }
+    return &cp
+}
+
+// ResetState clears everything derived from transactions, keeping the token definition.
+func (token *UtilityToken) ResetState() {
+    token.Balances = make(map[string]*big.Int)
+    token.VotingPower = make(map[string]*big.Int)
+    token.Proposals = []*Proposal{}
+    token.Minted = big.NewInt(0)
+    token.Burned = big.NewInt(0)
+    token.Slashed = big.NewInt(0)
+    token.Delegations = make(map[string]map[string]*big.Int)
+    token.Commission = make(map[string]int)
+    token.PendingRewards = make(map[string]*big.Int)
+    token.Accrued = make(map[string]*big.Int)
+    token.Unbonding = []*Unbonding{}
+    token.Jailed = make(map[string]bool)
+}
+
+// Issued returns the supply currently in existence, minted minus burned.
+func (token *UtilityToken) Issued() *big.Int {
+    issued := new(big.Int)
+    if token.Minted != nil {
+        issued.Add(issued, token.Minted)
+    }
+    if token.Burned != nil {
+        issued.Sub(issued, token.Burned)
+    }
+    return issued
+}
+
+func copyBigIntMap(m map[string]*big.Int) map[string]*big.Int {
+    cp := make(map[string]*big.Int, len(m))
+    for k, v := range m {
+        if v != nil {
+            cp[k] = new(big.Int).Set(v)
+        }
+    }
+    return cp
+}
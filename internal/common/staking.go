@@ -0,0 +1,320 @@
+package common
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+// MaxCommission is the highest commission rate in basis points
+const MaxCommission = 10000
+
+// Unbonding is stake that is being withdrawn from a validator. It returns
+// to its owner's balance once the chain reaches the Release height.
+type Unbonding struct {
+	Address   string
+	Validator string
+	Amount    *big.Int
+	Release   int
+}
+
+// Delegate bonds amount of the delegator's balance to a validator. Bonding
+// to oneself is staking and makes the address a validator; others can only
+// delegate to addresses that are active validators.
+func (token *UtilityToken) Delegate(delegator, validator string, amount *big.Int) error {
+	if delegator != validator && !token.IsValidator(validator) {
+		return fmt.Errorf("%s is not an active validator", validator)
+	}
+	balance, ok := token.Balances[delegator]
+	if !ok || balance.Cmp(amount) < 0 {
+		return fmt.Errorf("insufficient balance to stake")
+	}
+	balance.Sub(balance, amount)
+	token.addDelegation(delegator, validator, amount)
+	return nil
+}
+
+// Undelegate starts unbonding amount of the delegator's stake with a
+// validator until the release height
+func (token *UtilityToken) Undelegate(delegator, validator string, amount *big.Int, release int) error {
+	if err := token.subDelegation(delegator, validator, amount); err != nil {
+		return err
+	}
+	token.Unbonding = append(token.Unbonding, &Unbonding{
+		Address:   delegator,
+		Validator: validator,
+		Amount:    new(big.Int).Set(amount),
+		Release:   release,
+	})
+	return nil
+}
+
+// Redelegate moves amount of the delegator's stake from one validator to
+// another without unbonding
+func (token *UtilityToken) Redelegate(delegator, from, to string, amount *big.Int) error {
+	if from == to {
+		return fmt.Errorf("cannot redelegate to the same validator")
+	}
+	if !token.IsValidator(to) {
+		return fmt.Errorf("%s is not an active validator", to)
+	}
+	if err := token.subDelegation(delegator, from, amount); err != nil {
+		return err
+	}
+	token.addDelegation(delegator, to, amount)
+	return nil
+}
+
+// SetCommission sets the share of rewards, in basis points, that a validator
+// keeps before distributing the rest to its delegators
+func (token *UtilityToken) SetCommission(validator string, rate int) error {
+	if token.SelfStake(validator).Sign() == 0 {
+		return fmt.Errorf("%s is not a validator", validator)
+	}
+	if rate < 0 || rate > MaxCommission {
+		return fmt.Errorf("commission must be between 0 and %d basis points", MaxCommission)
+	}
+	if token.Commission == nil {
+		token.Commission = make(map[string]int)
+	}
+	token.Commission[validator] = rate
+	return nil
+}
+
+// AddReward adds a block reward to the validator's pool for the current epoch
+func (token *UtilityToken) AddReward(validator string, amount *big.Int) {
+	if token.PendingRewards == nil {
+		token.PendingRewards = make(map[string]*big.Int)
+	}
+	addTo(token.PendingRewards, validator, amount)
+}
+
+// DistributeRewards splits every validator's reward pool into its commission
+// and shares proportional to each delegation, and credits them as claimable
+// rewards. Rounding remainders go to the validator.
+func (token *UtilityToken) DistributeRewards() {
+	if token.Accrued == nil {
+		token.Accrued = make(map[string]*big.Int)
+	}
+	validators := make([]string, 0, len(token.PendingRewards))
+	for validator := range token.PendingRewards {
+		validators = append(validators, validator)
+	}
+	sort.Strings(validators)
+
+	for _, validator := range validators {
+		pool := token.PendingRewards[validator]
+		power := token.ValidatorPower(validator)
+		if power.Sign() == 0 {
+			addTo(token.Accrued, validator, pool)
+			continue
+		}
+
+		commission := new(big.Int).Mul(pool, big.NewInt(int64(token.Commission[validator])))
+		commission.Div(commission, big.NewInt(MaxCommission))
+		shared := new(big.Int).Sub(pool, commission)
+		remainder := new(big.Int).Set(shared)
+		for _, delegator := range token.delegatorsOf(validator) {
+			share := new(big.Int).Mul(shared, token.Delegations[delegator][validator])
+			share.Div(share, power)
+			addTo(token.Accrued, delegator, share)
+			remainder.Sub(remainder, share)
+		}
+		addTo(token.Accrued, validator, commission.Add(commission, remainder))
+	}
+	token.PendingRewards = make(map[string]*big.Int)
+}
+
+// ClaimRewards moves the address's distributed rewards into its balance and returns the amount
+func (token *UtilityToken) ClaimRewards(address string) (*big.Int, error) {
+	accrued, ok := token.Accrued[address]
+	if !ok || accrued.Sign() == 0 {
+		return nil, fmt.Errorf("no rewards to claim")
+	}
+	delete(token.Accrued, address)
+	if token.Balances == nil {
+		token.Balances = make(map[string]*big.Int)
+	}
+	addTo(token.Balances, address, accrued)
+	return accrued, nil
+}
+
+// ReleaseUnbonded returns all unbonding stake that matures at or before height to its owners' balances
+func (token *UtilityToken) ReleaseUnbonded(height int) {
+	pending := token.Unbonding[:0]
+	for _, u := range token.Unbonding {
+		if u.Release > height {
+			pending = append(pending, u)
+			continue
+		}
+		if token.Balances == nil {
+			token.Balances = make(map[string]*big.Int)
+		}
+		addTo(token.Balances, u.Address, u.Amount)
+	}
+	token.Unbonding = pending
+}
+
+// Slash burns percent of all stake bonded to a validator, including stake
+// that is still unbonding from it, and jails the validator. It returns the amount burned.
+func (token *UtilityToken) Slash(validator string, percent int64) *big.Int {
+	slashed := new(big.Int)
+	cut := func(amount *big.Int) *big.Int {
+		c := new(big.Int).Div(new(big.Int).Mul(amount, big.NewInt(percent)), big.NewInt(100))
+		slashed.Add(slashed, c)
+		return c
+	}
+
+	for _, delegator := range token.delegatorsOf(validator) {
+		token.subDelegation(delegator, validator, cut(token.Delegations[delegator][validator]))
+	}
+	for _, u := range token.Unbonding {
+		if u.Validator == validator {
+			u.Amount.Sub(u.Amount, cut(u.Amount))
+		}
+	}
+
+	if token.Jailed == nil {
+		token.Jailed = make(map[string]bool)
+	}
+	token.Jailed[validator] = true
+	if token.Burned == nil {
+		token.Burned = new(big.Int)
+	}
+	if token.Slashed == nil {
+		token.Slashed = new(big.Int)
+	}
+	token.Burned.Add(token.Burned, slashed)
+	token.Slashed.Add(token.Slashed, slashed)
+	return slashed
+}
+
+// StakeOf returns all stake the address has bonded, to itself or others
+func (token *UtilityToken) StakeOf(address string) *big.Int {
+	if stake, ok := token.VotingPower[address]; ok {
+		return new(big.Int).Set(stake)
+	}
+	return new(big.Int)
+}
+
+// SelfStake returns the stake a validator has bonded to itself
+func (token *UtilityToken) SelfStake(validator string) *big.Int {
+	if stake, ok := token.Delegations[validator][validator]; ok {
+		return new(big.Int).Set(stake)
+	}
+	return new(big.Int)
+}
+
+// ValidatorPower returns the total stake bonded to a validator
+func (token *UtilityToken) ValidatorPower(validator string) *big.Int {
+	power := new(big.Int)
+	for _, delegations := range token.Delegations {
+		if stake, ok := delegations[validator]; ok {
+			power.Add(power, stake)
+		}
+	}
+	return power
+}
+
+// DelegationsOf returns the stake the delegator has bonded to each validator
+func (token *UtilityToken) DelegationsOf(delegator string) map[string]*big.Int {
+	return copyBigIntMap(token.Delegations[delegator])
+}
+
+// IsValidator reports whether the address has stake bonded to itself and is not jailed
+func (token *UtilityToken) IsValidator(address string) bool {
+	return token.SelfStake(address).Sign() > 0 && !token.Jailed[address]
+}
+
+// Bonded returns the sum of all stake, including stake that is still unbonding
+func (token *UtilityToken) Bonded() *big.Int {
+	bonded := new(big.Int)
+	for _, stake := range token.VotingPower {
+		bonded.Add(bonded, stake)
+	}
+	for _, u := range token.Unbonding {
+		bonded.Add(bonded, u.Amount)
+	}
+	return bonded
+}
+
+// Unclaimed returns the sum of all rewards that are not yet claimed
+func (token *UtilityToken) Unclaimed() *big.Int {
+	unclaimed := new(big.Int)
+	for _, reward := range token.PendingRewards {
+		unclaimed.Add(unclaimed, reward)
+	}
+	for _, reward := range token.Accrued {
+		unclaimed.Add(unclaimed, reward)
+	}
+	return unclaimed
+}
+
+// Stakers returns the active validators, sorted
+func (token *UtilityToken) Stakers() []string {
+	stakers := make([]string, 0, len(token.Delegations))
+	for address := range token.Delegations {
+		if token.IsValidator(address) {
+			stakers = append(stakers, address)
+		}
+	}
+	sort.Strings(stakers)
+	return stakers
+}
+
+// delegatorsOf returns the addresses with stake bonded to a validator, sorted
+func (token *UtilityToken) delegatorsOf(validator string) []string {
+	var delegators []string
+	for delegator, delegations := range token.Delegations {
+		if _, ok := delegations[validator]; ok {
+			delegators = append(delegators, delegator)
+		}
+	}
+	sort.Strings(delegators)
+	return delegators
+}
+
+// addDelegation bonds amount from delegator to validator and adds it to the delegator's voting power
+func (token *UtilityToken) addDelegation(delegator, validator string, amount *big.Int) {
+	if token.Delegations == nil {
+		token.Delegations = make(map[string]map[string]*big.Int)
+	}
+	if token.Delegations[delegator] == nil {
+		token.Delegations[delegator] = make(map[string]*big.Int)
+	}
+	if token.VotingPower == nil {
+		token.VotingPower = make(map[string]*big.Int)
+	}
+	addTo(token.Delegations[delegator], validator, amount)
+	addTo(token.VotingPower, delegator, amount)
+}
+
+// subDelegation unbonds amount from delegator's stake with validator and its voting power
+func (token *UtilityToken) subDelegation(delegator, validator string, amount *big.Int) error {
+	stake, ok := token.Delegations[delegator][validator]
+	if !ok || stake.Cmp(amount) < 0 {
+		return fmt.Errorf("insufficient stake with %s", validator)
+	}
+	stake.Sub(stake, amount)
+	if stake.Sign() == 0 {
+		delete(token.Delegations[delegator], validator)
+		if len(token.Delegations[delegator]) == 0 {
+			delete(token.Delegations, delegator)
+		}
+	}
+
+	power := token.VotingPower[delegator]
+	power.Sub(power, amount)
+	if power.Sign() == 0 {
+		delete(token.VotingPower, delegator)
+	}
+	return nil
+}
+
+// addTo adds amount to m[key], creating the entry if needed
+func addTo(m map[string]*big.Int, key string, amount *big.Int) {
+	if _, ok := m[key]; !ok {
+		m[key] = new(big.Int)
+	}
+	m[key].Add(m[key], amount)
+}
@@ -0,0 +1,171 @@
+package consensus
+
+import (
+	"fmt"
+	"sort"
+)
+
+// BaseBlockVersion is the version of blocks before any upgrade raises it.
+// Blocks from before versioning have version 0 and count as this version.
+const BaseBlockVersion = 1
+
+// Upgrades known to this version of the node, scheduled by name in the chain config
+const (
+	// UpgradeFeeMarket charges every transaction a base fee, which is burned
+	// and adjusts to how full blocks are, plus a tip for the block producer
+	UpgradeFeeMarket = "fee_market"
+	// UpgradeCanonicalTxHash requires transactions of version 1, whose hash
+	// covers every field in an unambiguous encoding
+	UpgradeCanonicalTxHash = "canonical_tx_hash"
+)
+
+// Default resource limits of blocks, used when a chain config sets none
+const (
+	DefaultMaxBlockSize         = 1 << 20 // Serialized bytes
+	DefaultMaxBlockTransactions = 5000
+	DefaultMaxTxSize            = 16 << 10 // Serialized bytes
+)
+
+// Upgrade is a named change to the consensus rules that applies to every
+// block from its activation height on. All nodes of a network must agree on
+// the upgrades, and an activation height must not change once it is reached.
+type Upgrade struct {
+	Name    string `json:"name"`
+	Height  int    `json:"height"`            // First block the new rules apply to
+	Version int    `json:"version,omitempty"` // Minimum block version from Height on, 0 to keep it unchanged
+}
+
+// ChainConfig lists the upgrades of a chain and the resource limits of its
+// blocks. Validation and state transition code checks IsActive before
+// applying changed rules. A nil config has no upgrades and the default limits.
+type ChainConfig struct {
+	Upgrades             []*Upgrade `json:"upgrades"`
+	MaxBlockSize         int        `json:"maxBlockSize,omitempty"`         // Serialized bytes of a block, DefaultMaxBlockSize if 0
+	MaxBlockTransactions int        `json:"maxBlockTransactions,omitempty"` // Transactions in a block including rewards, DefaultMaxBlockTransactions if 0
+	MaxTxSize            int        `json:"maxTxSize,omitempty"`            // Serialized bytes of a transaction, DefaultMaxTxSize if 0
+}
+
+// NewChainConfig creates a chain config with the given upgrades, checking
+// that names are unique and that block versions never go down
+func NewChainConfig(upgrades []*Upgrade) (*ChainConfig, error) {
+	sorted := append([]*Upgrade(nil), upgrades...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Height < sorted[j].Height })
+
+	names := make(map[string]bool)
+	version := BaseBlockVersion
+	for _, upgrade := range sorted {
+		if upgrade.Name == "" {
+			return nil, fmt.Errorf("upgrade at height %d has no name", upgrade.Height)
+		}
+		if names[upgrade.Name] {
+			return nil, fmt.Errorf("upgrade %s is listed twice", upgrade.Name)
+		}
+		names[upgrade.Name] = true
+		if upgrade.Height < 1 {
+			return nil, fmt.Errorf("upgrade %s must activate after the genesis block", upgrade.Name)
+		}
+		if upgrade.Version != 0 && upgrade.Version < version {
+			return nil, fmt.Errorf("upgrade %s lowers the block version to %d", upgrade.Name, upgrade.Version)
+		}
+		if upgrade.Version > version {
+			version = upgrade.Version
+		}
+	}
+	return &ChainConfig{Upgrades: sorted}, nil
+}
+
+// SetLimits sets the resource limits of blocks, 0 for the default of each
+func (c *ChainConfig) SetLimits(maxBlockSize, maxBlockTransactions, maxTxSize int) error {
+	if maxBlockSize < 0 || maxBlockTransactions < 0 || maxTxSize < 0 {
+		return fmt.Errorf("block limits must not be negative")
+	}
+	limits := &ChainConfig{MaxBlockSize: maxBlockSize, MaxBlockTransactions: maxBlockTransactions, MaxTxSize: maxTxSize}
+	if limits.TxSizeLimit() > limits.BlockSizeLimit() {
+		return fmt.Errorf("transaction size limit %d exceeds the block size limit %d", limits.TxSizeLimit(), limits.BlockSizeLimit())
+	}
+	c.MaxBlockSize = maxBlockSize
+	c.MaxBlockTransactions = maxBlockTransactions
+	c.MaxTxSize = maxTxSize
+	return nil
+}
+
+// BlockSizeLimit returns the maximum serialized size of a block in bytes
+func (c *ChainConfig) BlockSizeLimit() int {
+	if c == nil || c.MaxBlockSize == 0 {
+		return DefaultMaxBlockSize
+	}
+	return c.MaxBlockSize
+}
+
+// BlockTransactionLimit returns the maximum number of transactions in a block
+func (c *ChainConfig) BlockTransactionLimit() int {
+	if c == nil || c.MaxBlockTransactions == 0 {
+		return DefaultMaxBlockTransactions
+	}
+	return c.MaxBlockTransactions
+}
+
+// TxSizeLimit returns the maximum serialized size of a transaction in bytes
+func (c *ChainConfig) TxSizeLimit() int {
+	if c == nil || c.MaxTxSize == 0 {
+		return DefaultMaxTxSize
+	}
+	return c.MaxTxSize
+}
+
+// IsActive reports whether the named upgrade applies to the block at height
+func (c *ChainConfig) IsActive(name string, height int) bool {
+	if c == nil {
+		return false
+	}
+	for _, upgrade := range c.Upgrades {
+		if upgrade.Name == name {
+			return height >= upgrade.Height
+		}
+	}
+	return false
+}
+
+// ActiveUpgrades returns the names of the upgrades that apply at height
+func (c *ChainConfig) ActiveUpgrades(height int) []string {
+	active := []string{}
+	if c == nil {
+		return active
+	}
+	for _, upgrade := range c.Upgrades {
+		if height >= upgrade.Height {
+			active = append(active, upgrade.Name)
+		}
+	}
+	return active
+}
+
+// BlockVersion returns the version of new blocks at height
+func (c *ChainConfig) BlockVersion(height int) int {
+	version := BaseBlockVersion
+	if c == nil {
+		return version
+	}
+	for _, upgrade := range c.Upgrades {
+		if height >= upgrade.Height && upgrade.Version > version {
+			version = upgrade.Version
+		}
+	}
+	return version
+}
+
+// VerifyVersion checks that a header's version is at least the one required
+// at its height. The genesis block is exempt.
+func (c *ChainConfig) VerifyVersion(header *Header) error {
+	if header.Index == 0 {
+		return nil
+	}
+	version := header.Version
+	if version == 0 {
+		version = BaseBlockVersion
+	}
+	if required := c.BlockVersion(header.Index); version < required {
+		return fmt.Errorf("block %d has version %d, but version %d is required", header.Index, version, required)
+	}
+	return nil
+}
@@ -0,0 +1,314 @@
+package consensus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+)
+
+const (
+	// DefaultEpoch is the number of blocks after which pending votes are discarded
+	DefaultEpoch = 30000
+
+	nonceDropVote = 0 // Header nonce voting to remove the coinbase from the signers
+	nonceAuthVote = 1 // Header nonce voting to add the coinbase to the signers
+
+	diffNoTurn = 1 // Difficulty of blocks sealed out of turn
+	diffInTurn = 2 // Difficulty of blocks sealed by the in-turn signer
+
+	inmemorySnapshots = 128 // Number of snapshots to keep in memory
+)
+
+// ErrUnauthorizedSigner is returned when sealing with a key outside the signer set
+var ErrUnauthorizedSigner = errors.New("unauthorized signer")
+
+// Clique is a proof-of-authority engine. A set of signers takes turns
+// sealing blocks by signing their headers, and signers vote other addresses
+// in or out through the coinbase and nonce of the blocks they seal.
+type Clique struct {
+	Epoch   int      // Blocks between checkpoints that reset pending votes
+	signers []string // Signer set at genesis
+
+	signer string   // Address this node seals blocks with
+	signFn SignerFn // Signs headers for signer
+
+	proposals map[string]bool      // Votes this node casts in its blocks, address to authorize
+	snapshots map[string]*Snapshot // Snapshots by block hash
+	mutex     sync.Mutex
+}
+
+// NewClique creates a proof-of-authority engine with the genesis signer set
+func NewClique(signers []string, epoch int) *Clique {
+	if epoch <= 0 {
+		epoch = DefaultEpoch
+	}
+	normalized := make([]string, 0, len(signers))
+	for _, signer := range signers {
+		normalized = append(normalized, normalizeAddress(signer))
+	}
+	sort.Strings(normalized)
+
+	return &Clique{
+		Epoch:     epoch,
+		signers:   normalized,
+		proposals: make(map[string]bool),
+		snapshots: make(map[string]*Snapshot),
+	}
+}
+
+// Authorize sets the key this node seals blocks with
+func (c *Clique) Authorize(signer string, signFn SignerFn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.signer = normalizeAddress(signer)
+	c.signFn = signFn
+}
+
+// Propose makes this node vote to add (authorize) or remove an address in the blocks it seals
+func (c *Clique) Propose(address string, authorize bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.proposals[normalizeAddress(address)] = authorize
+}
+
+// Discard stops voting on an address
+func (c *Clique) Discard(address string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.proposals, normalizeAddress(address))
+}
+
+// Proposals returns the votes this node is casting
+func (c *Clique) Proposals() map[string]bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	proposals := make(map[string]bool, len(c.proposals))
+	for address, authorize := range c.proposals {
+		proposals[address] = authorize
+	}
+	return proposals
+}
+
+// Snapshot returns the voting state after the chain's current header
+func (c *Clique) Snapshot(chain ChainReader) (*Snapshot, error) {
+	current := chain.CurrentHeader()
+	if current == nil {
+		return nil, fmt.Errorf("chain has no headers")
+	}
+	return c.snapshot(chain, current.Index)
+}
+
+// Prepare sets the difficulty for this node's turn and adds one of its votes
+func (c *Clique) Prepare(chain ChainReader, header *Header) error {
+	snap, err := c.snapshot(chain, header.Index-1)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	header.Coinbase = ""
+	header.Nonce = nonceDropVote
+	if header.Index%c.Epoch != 0 {
+		addresses := make([]string, 0, len(c.proposals))
+		for address, authorize := range c.proposals {
+			if snap.validVote(address, authorize) {
+				addresses = append(addresses, address)
+			}
+		}
+		if len(addresses) > 0 {
+			// Rotate through the open proposals block by block
+			sort.Strings(addresses)
+			header.Coinbase = addresses[header.Index%len(addresses)]
+			if c.proposals[header.Coinbase] {
+				header.Nonce = nonceAuthVote
+			}
+		}
+	}
+
+	header.Difficulty = diffNoTurn
+	if snap.inturn(header.Index, c.signer) {
+		header.Difficulty = diffInTurn
+	}
+	return nil
+}
+
+// Finalize grants no rewards, authorities are not paid for sealing
+func (c *Clique) Finalize(chain ChainReader, header *Header) []*Reward {
+	return nil
+}
+
+// Seal signs the header with this node's key and stores the signature in Extra
+func (c *Clique) Seal(ctx context.Context, chain ChainReader, header *Header) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	signer, signFn := c.signer, c.signFn
+	c.mutex.Unlock()
+	if signFn == nil {
+		return fmt.Errorf("no signing key configured: %v", ErrUnauthorizedSigner)
+	}
+
+	snap, err := c.snapshot(chain, header.Index-1)
+	if err != nil {
+		return err
+	}
+	if !snap.Signers[signer] {
+		return fmt.Errorf("%s: %v", signer, ErrUnauthorizedSigner)
+	}
+	if snap.recentlySigned(header.Index, signer) {
+		return fmt.Errorf("%s signed recently, must wait for others", signer)
+	}
+
+	signature, err := signFn([]byte(header.SealHash()))
+	if err != nil {
+		return fmt.Errorf("failed to sign header: %v", err)
+	}
+	header.Extra = signature
+	header.Hash = header.ComputeHash()
+	return nil
+}
+
+// VerifyHeader checks the header's timestamp and its signature against the
+// signer set at its parent
+func (c *Clique) VerifyHeader(chain ChainReader, header *Header) error {
+	if header.Hash != header.ComputeHash() {
+		return fmt.Errorf("block %d hash mismatch", header.Index)
+	}
+	if header.Index == 0 {
+		return nil
+	}
+	if err := VerifyTimestamp(chain, header); err != nil {
+		return err
+	}
+	if header.Nonce != nonceAuthVote && header.Nonce != nonceDropVote {
+		return fmt.Errorf("block %d has an invalid vote nonce %d", header.Index, header.Nonce)
+	}
+	if header.Coinbase != "" {
+		if header.Index%c.Epoch == 0 {
+			return fmt.Errorf("checkpoint block %d must not vote", header.Index)
+		}
+		if header.Coinbase != normalizeAddress(header.Coinbase) {
+			return fmt.Errorf("block %d votes on malformed address %s", header.Index, header.Coinbase)
+		}
+	}
+
+	snap, err := c.snapshot(chain, header.Index-1)
+	if err != nil {
+		return err
+	}
+	signer, err := recoverSigner(header)
+	if err != nil {
+		return err
+	}
+	if !snap.Signers[signer] {
+		return fmt.Errorf("block %d sealed by %s: %v", header.Index, signer, ErrUnauthorizedSigner)
+	}
+	if snap.recentlySigned(header.Index, signer) {
+		return fmt.Errorf("block %d sealed by recent signer %s", header.Index, signer)
+	}
+
+	inturn := snap.inturn(header.Index, signer)
+	if inturn && header.Difficulty != diffInTurn {
+		return fmt.Errorf("block %d is in turn but has difficulty %d", header.Index, header.Difficulty)
+	}
+	if !inturn && header.Difficulty != diffNoTurn {
+		return fmt.Errorf("block %d is out of turn but has difficulty %d", header.Index, header.Difficulty)
+	}
+	return nil
+}
+
+// VerifySeal checks that the header is signed by a signer of the chain's
+// current header. Turns and recent signers depend on the parent, so they are
+// left to VerifyHeader.
+func (c *Clique) VerifySeal(chain ChainReader, header *Header) error {
+	if header.Hash != header.ComputeHash() {
+		return fmt.Errorf("block %d hash mismatch", header.Index)
+	}
+	current := chain.CurrentHeader()
+	if current == nil {
+		return fmt.Errorf("block %d cannot be verified without a chain", header.Index)
+	}
+	snap, err := c.snapshot(chain, current.Index)
+	if err != nil {
+		return err
+	}
+	signer, err := recoverSigner(header)
+	if err != nil {
+		return err
+	}
+	if !snap.Signers[signer] {
+		return fmt.Errorf("block %d sealed by %s: %v", header.Index, signer, ErrUnauthorizedSigner)
+	}
+	return nil
+}
+
+// Author returns the signer of a header. The coinbase holds a vote, so it is
+// not paid. A header without a signature is being prepared by this node.
+func (c *Clique) Author(header *Header) (string, error) {
+	if header.Index == 0 {
+		return "", nil
+	}
+	if header.Extra == "" {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return c.signer, nil
+	}
+	return recoverSigner(header)
+}
+
+// Weight prefers chains with more in-turn blocks
+func (c *Clique) Weight(header *Header) *big.Int {
+	return big.NewInt(int64(header.Difficulty))
+}
+
+// snapshot returns the voting state after the header at index, replaying
+// headers from the closest cached snapshot or from genesis
+func (c *Clique) snapshot(chain ChainReader, index int) (*Snapshot, error) {
+	var headers []*Header
+	var snap *Snapshot
+	for i := index; ; i-- {
+		header := chain.GetHeaderByIndex(i)
+		if header == nil {
+			return nil, fmt.Errorf("missing header %d", i)
+		}
+		c.mutex.Lock()
+		cached, ok := c.snapshots[header.Hash]
+		c.mutex.Unlock()
+		if ok {
+			snap = cached
+			break
+		}
+		if i == 0 {
+			snap = newGenesisSnapshot(c.signers, header.Hash)
+			break
+		}
+		headers = append(headers, header)
+	}
+
+	for i := len(headers) - 1; i >= 0; i-- {
+		signer, err := recoverSigner(headers[i])
+		if err != nil {
+			return nil, err
+		}
+		if snap, err = snap.apply(headers[i], signer, c.Epoch); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mutex.Lock()
+	if len(c.snapshots) >= inmemorySnapshots {
+		c.snapshots = make(map[string]*Snapshot)
+	}
+	c.snapshots[snap.Hash] = snap
+	c.mutex.Unlock()
+	return snap, nil
+}
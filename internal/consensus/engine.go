@@ -0,0 +1,58 @@
+package consensus
+
+import (
+	"context"
+	"math/big"
+)
+
+// ChainReader gives engines read access to the headers of a chain
+type ChainReader interface {
+	// CurrentHeader returns the header of the chain tip
+	CurrentHeader() *Header
+	// GetHeaderByIndex returns the header at the given height, or nil
+	GetHeaderByIndex(index int) *Header
+	// GetHeaderByHash returns the header with the given hash, or nil
+	GetHeaderByHash(hash string) *Header
+	// Config returns the chain's upgrades, or nil if it has none
+	Config() *ChainConfig
+}
+
+// Reward is a payout that an engine grants when a block is finalized.
+// The chain records it as a mint transaction at the start of the block.
+type Reward struct {
+	Address     string
+	Amount      *big.Int
+	TokenSymbol string
+	Staking     bool // Paid into the validator's reward pool, shared with its delegators
+}
+
+// Engine is a consensus algorithm that the chain delegates block sealing
+// and header validation to
+type Engine interface {
+	// Prepare sets the consensus fields of a new header, such as its difficulty
+	Prepare(chain ChainReader, header *Header) error
+
+	// Finalize returns the rewards to pay out in the block with the given header
+	Finalize(chain ChainReader, header *Header) []*Reward
+
+	// Seal produces the consensus proof for a prepared header and sets its
+	// hash. It stops early with ctx's error if ctx is cancelled.
+	Seal(ctx context.Context, chain ChainReader, header *Header) error
+
+	// VerifyHeader checks that a header follows the consensus rules
+	VerifyHeader(chain ChainReader, header *Header) error
+
+	// Weight returns the fork-choice weight that a block adds to its chain
+	Weight(header *Header) *big.Int
+
+	// Author returns the address that produced a header and is paid the tips
+	// of its transactions, or "" if nobody is
+	Author(header *Header) (string, error)
+}
+
+// SealVerifier is an Engine that can check a header's seal without its
+// parent, against the signers of the chain's current header. The chain keeps
+// blocks whose parent it lacks only if their seal verifies.
+type SealVerifier interface {
+	VerifySeal(chain ChainReader, header *Header) error
+}
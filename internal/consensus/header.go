@@ -0,0 +1,75 @@
+package consensus
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Header holds the fields of a block that are covered by its hash.
+// It is the view of a block that consensus engines and light clients work with.
+type Header struct {
+	Version      int      `json:"version,omitempty"` // Rules the block follows, see ChainConfig
+	Index        int      `json:"index"`
+	Timestamp    string   `json:"timestamp"`
+	Nonce        uint64   `json:"nonce"`
+	PreviousHash string   `json:"previousHash"`
+	MerkleRoot   string   `json:"merkleRoot"`
+	StateRoot    string   `json:"stateRoot"`
+	Difficulty   int      `json:"difficulty,omitempty"` // Required leading zero hex digits for proof-of-work
+	Coinbase     string   `json:"coinbase,omitempty"`   // Address receiving the block reward
+	BaseFee      *big.Int `json:"baseFee,omitempty"`    // Fee burned per transaction once the fee market is active
+	Extra        string   `json:"extra,omitempty"`      // Engine specific seal, e.g. a signature
+	Hash         string   `json:"hash"`
+	Commit       *Commit  `json:"commit,omitempty"` // BFT commit certificate, signs Hash so is not covered by it
+}
+
+// SealHash computes the hash of the header without its seal in Extra.
+// This is what signing engines sign.
+func (h *Header) SealHash() string {
+	return calculateHash(h.sealRecord())
+}
+
+// ComputeHash computes the block hash, covering every header field
+func (h *Header) ComputeHash() string {
+	return calculateHash(h.sealRecord() + h.Extra)
+}
+
+// WorkParts splits the hashed record around the nonce, so an external miner
+// can compute the hash for any nonce and extra-nonce as
+// sha256(prefix + fmt.Sprintf("%08d", nonce) + suffix + extra)
+func (h *Header) WorkParts() (prefix, suffix string) {
+	cp := *h
+	cp.Nonce = 0
+	record := cp.sealRecord()
+	prefix = fmt.Sprintf("%08d", h.Index) + h.Timestamp
+	return prefix, record[len(prefix)+8:]
+}
+
+// sealRecord serializes the header fields except Hash and Extra. Fields added
+// after the first release only contribute when set, so older hashes stay valid.
+func (h *Header) sealRecord() string {
+	record := fmt.Sprintf("%08d", h.Index) + h.Timestamp + fmt.Sprintf("%08d", h.Nonce) + h.PreviousHash
+	record += h.MerkleRoot + h.StateRoot
+	if h.Difficulty > 0 {
+		record += fmt.Sprintf("d%d", h.Difficulty)
+	}
+	if h.Coinbase != "" {
+		record += "c" + h.Coinbase
+	}
+	if h.Version > 0 {
+		record += fmt.Sprintf("v%d", h.Version)
+	}
+	if h.BaseFee != nil {
+		record += "f" + h.BaseFee.String()
+	}
+	return record
+}
+
+// Copy returns a copy of the header
+func (h *Header) Copy() *Header {
+	cp := *h
+	if h.BaseFee != nil {
+		cp.BaseFee = new(big.Int).Set(h.BaseFee)
+	}
+	return &cp
+}
@@ -0,0 +1,157 @@
+package consensus
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hashBatch is the number of hashes a worker tries between checks for
+// cancellation and updates of the hash counter
+const hashBatch = 1024
+
+// MiningStats reports the progress of a proof-of-work search
+type MiningStats struct {
+	Threads  int           `json:"threads"`
+	Hashes   uint64        `json:"hashes"`
+	Duration time.Duration `json:"duration"`
+	HashRate float64       `json:"hashRate"` // Hashes per second
+	Running  bool          `json:"running"`
+}
+
+// Miner searches for a header hash that meets a difficulty with several
+// worker goroutines. Each worker searches its own share of the nonce space.
+// A worker that exhausts its share rolls the header timestamp and an
+// extra-nonce in Extra, and searches its share again.
+type Miner struct {
+	Threads int // Worker goroutines, all CPUs if 0
+
+	hashes  uint64 // Hashes tried in the current or last search, updated atomically
+	started time.Time
+	stopped time.Time
+	running bool
+	mutex   sync.Mutex
+}
+
+// NewMiner creates a miner with the given number of worker goroutines, all CPUs if 0
+func NewMiner(threads int) *Miner {
+	return &Miner{Threads: threads}
+}
+
+// Mine searches for a nonce, and if needed an extra-nonce, that gives the
+// header a proof-of-work hash with difficulty leading zeros under algorithm. On success the header's Nonce,
+// Timestamp, Extra and Hash hold the solution. It returns ctx's error if ctx
+// is cancelled first, e.g. because a competing block arrived.
+func (m *Miner) Mine(ctx context.Context, header *Header, algorithm PoWAlgorithm, difficulty int) error {
+	threads := m.threads()
+	m.mutex.Lock()
+	atomic.StoreUint64(&m.hashes, 0)
+	m.started = time.Now()
+	m.running = true
+	m.mutex.Unlock()
+	defer func() {
+		m.mutex.Lock()
+		m.stopped = time.Now()
+		m.running = false
+		m.mutex.Unlock()
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	found := make(chan *Header, threads)
+	share := math.MaxUint64 / uint64(threads)
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		first := uint64(i) * share
+		last := first + share - 1
+		if i == threads-1 {
+			last = math.MaxUint64
+		}
+
+		wg.Add(1)
+		go func(work *Header) {
+			defer wg.Done()
+			if m.search(ctx, work, algorithm, difficulty, first, last) {
+				found <- work
+				cancel()
+			}
+		}(header.Copy())
+	}
+	wg.Wait()
+
+	select {
+	case solution := <-found:
+		*header = *solution
+		return nil
+	default:
+		return ctx.Err()
+	}
+}
+
+// search tries the nonces from first to last, rolling the timestamp and
+// extra-nonce each time the range is exhausted, until the header meets the
+// difficulty or ctx is cancelled
+func (m *Miner) search(ctx context.Context, header *Header, algorithm PoWAlgorithm, difficulty int, first, last uint64) bool {
+	var extraNonce uint64
+	for {
+		for nonce := first; ; nonce++ {
+			if (nonce-first)%hashBatch == 0 && nonce != first {
+				atomic.AddUint64(&m.hashes, hashBatch)
+				if ctx.Err() != nil {
+					return false
+				}
+			}
+
+			header.Nonce = nonce
+			if meetsDifficulty(algorithm.Hash(header), difficulty) {
+				header.Hash = header.ComputeHash()
+				atomic.AddUint64(&m.hashes, (nonce-first)%hashBatch+1)
+				return true
+			}
+			if nonce == last {
+				break
+			}
+		}
+
+		extraNonce++
+		header.Timestamp = time.Now().String()
+		header.Extra = strconv.FormatUint(extraNonce, 16)
+	}
+}
+
+// Stats returns the hash rate of the current search, or of the last one if none is running
+func (m *Miner) Stats() MiningStats {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	stats := MiningStats{
+		Threads: m.threads(),
+		Hashes:  atomic.LoadUint64(&m.hashes),
+		Running: m.running,
+	}
+	if m.started.IsZero() {
+		return stats
+	}
+	end := m.stopped
+	if m.running {
+		end = time.Now()
+	}
+	stats.Duration = end.Sub(m.started)
+	if stats.Duration > 0 {
+		stats.HashRate = float64(stats.Hashes) / stats.Duration.Seconds()
+	}
+	return stats
+}
+
+// threads returns the number of workers to start
+func (m *Miner) threads() int {
+	if m.Threads > 0 {
+		return m.Threads
+	}
+	return runtime.NumCPU()
+}
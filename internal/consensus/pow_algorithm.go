@@ -0,0 +1,61 @@
+package consensus
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// PoWAlgorithm names the hash function that proof-of-work is computed with.
+// A chain's algorithm is fixed when its genesis block is created.
+type PoWAlgorithm string
+
+const (
+	// SHA256 uses the block hash itself as the proof-of-work hash. It is
+	// cheap to compute and suited to devnets.
+	SHA256 PoWAlgorithm = "sha256"
+
+	// Scrypt computes the proof-of-work hash with scrypt over the hashed
+	// header record, which needs 128 KiB of memory per hash so that SHA-256
+	// ASICs cannot be used. The block hash stays SHA-256.
+	Scrypt PoWAlgorithm = "scrypt"
+)
+
+// scrypt parameters, as used by Litecoin
+const (
+	scryptN      = 1024
+	scryptR      = 1
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// genesisExtraPrefix marks the algorithm recorded in a genesis block's Extra
+const genesisExtraPrefix = "pow:"
+
+// ParsePoWAlgorithm returns the algorithm with the given name, SHA256 if empty
+func ParsePoWAlgorithm(name string) (PoWAlgorithm, error) {
+	switch PoWAlgorithm(name) {
+	case "", SHA256:
+		return SHA256, nil
+	case Scrypt:
+		return Scrypt, nil
+	default:
+		return "", fmt.Errorf("unknown proof-of-work algorithm %q", name)
+	}
+}
+
+// Hash computes the proof-of-work hash of the header, which has to meet the
+// difficulty
+func (a PoWAlgorithm) Hash(header *Header) string {
+	if a != Scrypt {
+		return header.ComputeHash()
+	}
+	record := []byte(header.sealRecord() + header.Extra)
+	key, err := scrypt.Key(record, record, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		// Only possible with invalid parameters, which are constant
+		panic(fmt.Sprintf("scrypt failed: %v", err))
+	}
+	return hex.EncodeToString(key)
+}
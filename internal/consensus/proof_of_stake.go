@@ -0,0 +1,247 @@
+package consensus
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxRounds is the number of proposer rounds tried for each height
+	MaxRounds = 32
+
+	// DefaultRoundTimeout is how long each round waits for its proposer
+	DefaultRoundTimeout = 2 * time.Second
+)
+
+// Validator is an address allowed to propose blocks, weighted by its stake
+type Validator struct {
+	Address string   `json:"address"`
+	Power   *big.Int `json:"power"`
+}
+
+// ValidatorReader is a ChainReader that also exposes the validator set
+// staked in a token after its current header
+type ValidatorReader interface {
+	ChainReader
+	Validators(tokenSymbol string) []*Validator
+}
+
+// ProofOfStake selects a stake-weighted proposer for every block. The
+// proposer signs the header and earns the block reward for itself and its delegators.
+type ProofOfStake struct {
+	StakeToken   string        // Token that is staked and paid as reward
+	Reward       *big.Int      // Paid to the proposer's reward pool, nil for no reward
+	RoundTimeout time.Duration // Delay before each later round may be proposed
+	bootstrap    []string      // Validators used while nobody has staked yet
+
+	signer string   // Address this node proposes blocks with
+	signFn SignerFn // Signs headers for signer
+	mutex  sync.Mutex
+}
+
+// NewProofOfStake creates a proof-of-stake engine over the given stake token.
+// The bootstrap validators propose blocks until the first stake is bonded.
+func NewProofOfStake(stakeToken string, bootstrap []string) *ProofOfStake {
+	normalized := make([]string, 0, len(bootstrap))
+	for _, address := range bootstrap {
+		normalized = append(normalized, normalizeAddress(address))
+	}
+	return &ProofOfStake{
+		StakeToken:   stakeToken,
+		RoundTimeout: DefaultRoundTimeout,
+		bootstrap:    normalized,
+	}
+}
+
+// Authorize sets the key this node proposes blocks with
+func (pos *ProofOfStake) Authorize(signer string, signFn SignerFn) {
+	pos.mutex.Lock()
+	defer pos.mutex.Unlock()
+
+	pos.signer = normalizeAddress(signer)
+	pos.signFn = signFn
+}
+
+// Validators returns the validator set that proposes the block after the chain's current header
+func (pos *ProofOfStake) Validators(chain ChainReader) []*Validator {
+	if reader, ok := chain.(ValidatorReader); ok {
+		if validators := reader.Validators(pos.StakeToken); len(validators) > 0 {
+			return validators
+		}
+	}
+	validators := make([]*Validator, 0, len(pos.bootstrap))
+	for _, address := range pos.bootstrap {
+		validators = append(validators, &Validator{Address: address, Power: big.NewInt(1)})
+	}
+	return validators
+}
+
+// Proposer picks the validator for a round of the block after parentHash,
+// with a probability proportional to its power
+func Proposer(validators []*Validator, parentHash string, round int) string {
+	sorted := append([]*Validator(nil), validators...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Address < sorted[j].Address })
+
+	total := big.NewInt(0)
+	for _, validator := range sorted {
+		total.Add(total, validator.Power)
+	}
+	if total.Sign() <= 0 {
+		return ""
+	}
+
+	seed := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", parentHash, round)))
+	target := new(big.Int).Mod(new(big.Int).SetBytes(seed[:]), total)
+	for _, validator := range sorted {
+		if target.Cmp(validator.Power) < 0 {
+			return validator.Address
+		}
+		target.Sub(target, validator.Power)
+	}
+	return ""
+}
+
+// Prepare picks the earliest round in which this node is the proposer and
+// records it in the header's nonce, with this node as coinbase
+func (pos *ProofOfStake) Prepare(chain ChainReader, header *Header) error {
+	pos.mutex.Lock()
+	signer := pos.signer
+	pos.mutex.Unlock()
+
+	validators := pos.Validators(chain)
+	for round := 0; round < MaxRounds; round++ {
+		if Proposer(validators, header.PreviousHash, round) == signer {
+			header.Nonce = uint64(round)
+			header.Coinbase = signer
+			header.Difficulty = 0
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not a proposer for block %d", signer, header.Index)
+}
+
+// Finalize pays the block reward into the proposer's reward pool
+func (pos *ProofOfStake) Finalize(chain ChainReader, header *Header) []*Reward {
+	if pos.Reward == nil || pos.Reward.Sign() <= 0 || header.Coinbase == "" {
+		return nil
+	}
+	return []*Reward{{
+		Address:     header.Coinbase,
+		Amount:      new(big.Int).Set(pos.Reward),
+		TokenSymbol: pos.StakeToken,
+		Staking:     true,
+	}}
+}
+
+// Seal waits for the header's round to open and signs the header
+func (pos *ProofOfStake) Seal(ctx context.Context, chain ChainReader, header *Header) error {
+	pos.mutex.Lock()
+	signer, signFn := pos.signer, pos.signFn
+	pos.mutex.Unlock()
+	if signFn == nil || header.Coinbase != signer {
+		return fmt.Errorf("no signing key configured for proposer %s", header.Coinbase)
+	}
+
+	// Later rounds give the proposers of earlier rounds a chance to go first
+	if delay := time.Duration(header.Nonce) * pos.RoundTimeout; delay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	} else if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	signature, err := signFn([]byte(header.SealHash()))
+	if err != nil {
+		return fmt.Errorf("failed to sign header: %v", err)
+	}
+	header.Extra = signature
+	header.Hash = header.ComputeHash()
+	return nil
+}
+
+// VerifyHeader checks the header's timestamp and that it is signed by the
+// proposer of its round.
+// The proposer depends on the staked validator set, so chains without access
+// to it, such as light clients, cannot verify headers and reject them.
+func (pos *ProofOfStake) VerifyHeader(chain ChainReader, header *Header) error {
+	if header.Hash != header.ComputeHash() {
+		return fmt.Errorf("block %d hash mismatch", header.Index)
+	}
+	if header.Index == 0 {
+		return nil
+	}
+	if err := VerifyTimestamp(chain, header); err != nil {
+		return err
+	}
+	if header.Nonce >= MaxRounds {
+		return fmt.Errorf("block %d has invalid round %d", header.Index, header.Nonce)
+	}
+
+	signer, err := recoverSigner(header)
+	if err != nil {
+		return err
+	}
+	if signer != header.Coinbase {
+		return fmt.Errorf("block %d is signed by %s, not its coinbase %s", header.Index, signer, header.Coinbase)
+	}
+	if _, ok := chain.(ValidatorReader); !ok {
+		return fmt.Errorf("block %d cannot be verified without the validator set", header.Index)
+	}
+	if proposer := Proposer(pos.Validators(chain), header.PreviousHash, int(header.Nonce)); proposer != signer {
+		return fmt.Errorf("block %d round %d must be proposed by %s, not %s", header.Index, header.Nonce, proposer, signer)
+	}
+	return nil
+}
+
+// VerifySeal is VerifyHeader with the chain's current validator set. The
+// proposer is picked by the parent hash, which the header holds.
+func (pos *ProofOfStake) VerifySeal(chain ChainReader, header *Header) error {
+	return pos.VerifyHeader(chain, header)
+}
+
+// Author returns the coinbase, which VerifyHeader requires to be the signer
+func (pos *ProofOfStake) Author(header *Header) (string, error) {
+	return header.Coinbase, nil
+}
+
+// Weight prefers blocks proposed in earlier rounds
+func (pos *ProofOfStake) Weight(header *Header) *big.Int {
+	return big.NewInt(MaxRounds - int64(header.Nonce))
+}
+
+// VerifyDoubleSign checks that two headers are conflicting blocks at the same
+// height signed by the same key, and returns that signer
+func VerifyDoubleSign(a, b *Header) (string, error) {
+	if a.Index != b.Index {
+		return "", fmt.Errorf("headers are at different heights %d and %d", a.Index, b.Index)
+	}
+	if a.SealHash() == b.SealHash() {
+		return "", fmt.Errorf("headers are the same block")
+	}
+	for _, header := range []*Header{a, b} {
+		if header.Hash != header.ComputeHash() {
+			return "", fmt.Errorf("block %d hash mismatch", header.Index)
+		}
+	}
+
+	signerA, err := recoverSigner(a)
+	if err != nil {
+		return "", err
+	}
+	signerB, err := recoverSigner(b)
+	if err != nil {
+		return "", err
+	}
+	if signerA != signerB {
+		return "", fmt.Errorf("headers are signed by different keys %s and %s", signerA, signerB)
+	}
+	return signerA, nil
+}
@@ -1,48 +1,125 @@
 package consensus
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 	"strings"
 )
 
 // ProofOfWork represents the proof-of-work system
 type ProofOfWork struct {
-	Difficulty int // Number of leading zeros required in the hash
+	Difficulty  int          // Number of leading zeros required in the hash
+	Algorithm   PoWAlgorithm // Hash function the proof-of-work is computed with
+	Reward      *big.Int     // Paid to the block's coinbase, nil for no reward
+	RewardToken string       // Token the reward is paid in, "" for native balances
+	Miner       *Miner       // Searches for nonces when sealing
 }
 
-// NewProofOfWork initializes a new PoW system
+// NewProofOfWork initializes a new PoW system using SHA-256
 func NewProofOfWork(difficulty int) *ProofOfWork {
 	return &ProofOfWork{
 		Difficulty: difficulty,
+		Algorithm:  SHA256,
+		Miner:      NewMiner(0),
 	}
 }
 
-// Mine performs mining by solving the PoW challenge
-func (pow *ProofOfWork) Mine(index int, timestamp string, transactions string, previousHash string) (string, int) {
-	nonce := 0
-	var hash string
-	target := strings.Repeat("0", pow.Difficulty)
+// Prepare sets the difficulty of a new header
+func (pow *ProofOfWork) Prepare(chain ChainReader, header *Header) error {
+	header.Difficulty = pow.Difficulty
+	return nil
+}
+
+// Finalize pays the block reward to the header's coinbase
+func (pow *ProofOfWork) Finalize(chain ChainReader, header *Header) []*Reward {
+	if pow.Reward == nil || pow.Reward.Sign() <= 0 || header.Coinbase == "" {
+		return nil
+	}
+	return []*Reward{{
+		Address:     header.Coinbase,
+		Amount:      new(big.Int).Set(pow.Reward),
+		TokenSymbol: pow.RewardToken,
+	}}
+}
+
+// Seal performs mining by searching for a nonce that solves the PoW challenge
+// on all of the miner's threads, until ctx is cancelled
+func (pow *ProofOfWork) Seal(ctx context.Context, chain ChainReader, header *Header) error {
+	return pow.Miner.Mine(ctx, header, pow.Algorithm, header.Difficulty)
+}
 
-	for {
-		data := fmt.Sprintf("%d%s%s%s%d", index, timestamp, transactions, previousHash, nonce)
-		hash = calculateHash(data)
+// VerifyHeader checks the header's hash, that it satisfies the PoW difficulty
+// and its timestamp
+func (pow *ProofOfWork) VerifyHeader(chain ChainReader, header *Header) error {
+	if err := pow.VerifySeal(chain, header); err != nil {
+		return err
+	}
+	return VerifyTimestamp(chain, header)
+}
 
-		// Check if the hash satisfies the difficulty
-		if strings.HasPrefix(hash, target) {
-			break
+// VerifySeal checks the proof-of-work, which needs no other header
+func (pow *ProofOfWork) VerifySeal(chain ChainReader, header *Header) error {
+	if header.Hash != header.ComputeHash() {
+		return fmt.Errorf("block %d hash mismatch", header.Index)
+	}
+	if header.Index == 0 {
+		return pow.VerifyGenesis(header)
+	}
+	if header.Difficulty != pow.Difficulty {
+		return fmt.Errorf("block %d has difficulty %d, expected %d", header.Index, header.Difficulty, pow.Difficulty)
+	}
+	if !pow.ValidateProof(pow.Algorithm.Hash(header)) {
+		return fmt.Errorf("block %d has insufficient %s proof-of-work", header.Index, pow.Algorithm)
+	}
+	return nil
+}
+
+// GenesisExtra returns the Extra of a new chain's genesis block, which
+// records the chain's algorithm. It is empty for SHA-256, as on older chains.
+func (pow *ProofOfWork) GenesisExtra() string {
+	if pow.Algorithm == SHA256 {
+		return ""
+	}
+	return genesisExtraPrefix + string(pow.Algorithm)
+}
+
+// VerifyGenesis checks that the chain was created with this engine's algorithm
+func (pow *ProofOfWork) VerifyGenesis(genesis *Header) error {
+	algorithm := SHA256
+	if genesis.Extra != "" {
+		parsed, err := ParsePoWAlgorithm(strings.TrimPrefix(genesis.Extra, genesisExtraPrefix))
+		if err != nil || !strings.HasPrefix(genesis.Extra, genesisExtraPrefix) {
+			return fmt.Errorf("genesis block records an unknown proof-of-work algorithm %q", genesis.Extra)
 		}
-		nonce++
+		algorithm = parsed
+	}
+	if algorithm != pow.Algorithm {
+		return fmt.Errorf("chain uses %s proof-of-work, but the node is configured for %s", algorithm, pow.Algorithm)
 	}
+	return nil
+}
+
+// Author returns the coinbase, which the miner chooses
+func (pow *ProofOfWork) Author(header *Header) (string, error) {
+	return header.Coinbase, nil
+}
 
-	fmt.Printf("Block mined! Hash: %s, Nonce: %d\n", hash, nonce)
-	return hash, nonce
+// Weight returns the expected number of hashes needed to mine the header
+func (pow *ProofOfWork) Weight(header *Header) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(4*header.Difficulty))
 }
 
-// ValidateProof checks if a given hash satisfies the PoW difficulty
+// ValidateProof checks if a given proof-of-work hash satisfies the PoW difficulty
 func (pow *ProofOfWork) ValidateProof(hash string) bool {
-	target := strings.Repeat("0", pow.Difficulty)
+	return meetsDifficulty(hash, pow.Difficulty)
+}
+
+// meetsDifficulty checks if a hash starts with difficulty zeros
+func meetsDifficulty(hash string, difficulty int) bool {
+	target := strings.Repeat("0", difficulty)
 	return strings.HasPrefix(hash, target)
 }
 
@@ -0,0 +1,40 @@
+package consensus
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	gethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// SignerFn signs data with a signer's key and returns the hex-encoded
+// recoverable secp256k1 signature of its Keccak-256 hash, like wallet.Sign
+type SignerFn func(data []byte) (string, error)
+
+// recoverSigner extracts the address that signed the header's seal hash
+func recoverSigner(header *Header) (string, error) {
+	signer, err := recoverAddress([]byte(header.SealHash()), header.Extra)
+	if err != nil {
+		return "", fmt.Errorf("block %d: %v", header.Index, err)
+	}
+	return signer, nil
+}
+
+// recoverAddress extracts the address whose key produced a SignerFn signature over data
+func recoverAddress(data []byte, signatureHex string) (string, error) {
+	signature, err := hex.DecodeString(signatureHex)
+	if err != nil || len(signature) != crypto.SignatureLength {
+		return "", fmt.Errorf("malformed signature")
+	}
+	pubKey, err := crypto.SigToPub(crypto.Keccak256(data), signature)
+	if err != nil {
+		return "", fmt.Errorf("signature recovery failed: %v", err)
+	}
+	return crypto.PubkeyToAddress(*pubKey).Hex(), nil
+}
+
+// normalizeAddress returns the checksummed form of a hex address
+func normalizeAddress(address string) string {
+	return gethcommon.HexToAddress(address).Hex()
+}
@@ -0,0 +1,219 @@
+package consensus
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Vote is a signer's proposal to add or remove an address from the signer set
+type Vote struct {
+	Signer    string `json:"signer"`    // Signer that cast the vote
+	Block     int    `json:"block"`     // Block the vote was cast in
+	Address   string `json:"address"`   // Address being voted on
+	Authorize bool   `json:"authorize"` // Whether to add or remove the address
+}
+
+// Tally counts the votes for one address
+type Tally struct {
+	Authorize bool `json:"authorize"`
+	Votes     int  `json:"votes"`
+}
+
+// Snapshot is the proof-of-authority voting state after a given block
+type Snapshot struct {
+	Index   int               `json:"index"`
+	Hash    string            `json:"hash"`
+	Signers map[string]bool   `json:"signers"`
+	Recents map[int]string    `json:"recents"` // Recent signers by block index, for spam protection
+	Votes   []*Vote           `json:"votes"`   // Votes cast since the last checkpoint, in order
+	Tally   map[string]*Tally `json:"tally"`
+}
+
+// newGenesisSnapshot creates the snapshot at the genesis block
+func newGenesisSnapshot(signers []string, hash string) *Snapshot {
+	snap := &Snapshot{
+		Hash:    hash,
+		Signers: make(map[string]bool),
+		Recents: make(map[int]string),
+		Tally:   make(map[string]*Tally),
+	}
+	for _, signer := range signers {
+		snap.Signers[signer] = true
+	}
+	return snap
+}
+
+// copy returns a deep copy of the snapshot
+func (s *Snapshot) copy() *Snapshot {
+	cp := &Snapshot{
+		Index:   s.Index,
+		Hash:    s.Hash,
+		Signers: make(map[string]bool, len(s.Signers)),
+		Recents: make(map[int]string, len(s.Recents)),
+		Votes:   make([]*Vote, len(s.Votes)),
+		Tally:   make(map[string]*Tally, len(s.Tally)),
+	}
+	for signer := range s.Signers {
+		cp.Signers[signer] = true
+	}
+	for index, signer := range s.Recents {
+		cp.Recents[index] = signer
+	}
+	for address, tally := range s.Tally {
+		t := *tally
+		cp.Tally[address] = &t
+	}
+	copy(cp.Votes, s.Votes)
+	return cp
+}
+
+// SignerList returns the authorized signers in ascending order
+func (s *Snapshot) SignerList() []string {
+	signers := make([]string, 0, len(s.Signers))
+	for signer := range s.Signers {
+		signers = append(signers, signer)
+	}
+	sort.Strings(signers)
+	return signers
+}
+
+// signerLimit is the number of consecutive blocks in which a signer may sign only once
+func (s *Snapshot) signerLimit() int {
+	return len(s.Signers)/2 + 1
+}
+
+// inturn reports whether it is the signer's turn to seal the block at index
+func (s *Snapshot) inturn(index int, signer string) bool {
+	signers := s.SignerList()
+	return len(signers) > 0 && signers[index%len(signers)] == signer
+}
+
+// recentlySigned reports whether the signer sealed one of the last blocks
+// before index and must wait before sealing again
+func (s *Snapshot) recentlySigned(index int, signer string) bool {
+	for seen, recent := range s.Recents {
+		if recent == signer && index-seen < s.signerLimit() {
+			return true
+		}
+	}
+	return false
+}
+
+// validVote reports whether a vote would change the signer set
+func (s *Snapshot) validVote(address string, authorize bool) bool {
+	return s.Signers[address] != authorize
+}
+
+// cast adds a vote to the tally if it is valid
+func (s *Snapshot) cast(address string, authorize bool) bool {
+	if !s.validVote(address, authorize) {
+		return false
+	}
+	if tally, ok := s.Tally[address]; ok {
+		tally.Votes++
+	} else {
+		s.Tally[address] = &Tally{Authorize: authorize, Votes: 1}
+	}
+	return true
+}
+
+// uncast removes a previously cast vote from the tally
+func (s *Snapshot) uncast(address string, authorize bool) {
+	tally, ok := s.Tally[address]
+	if !ok || tally.Authorize != authorize {
+		return
+	}
+	if tally.Votes > 1 {
+		tally.Votes--
+	} else {
+		delete(s.Tally, address)
+	}
+}
+
+// apply returns the snapshot after the given header, sealed by signer
+func (s *Snapshot) apply(header *Header, signer string, epoch int) (*Snapshot, error) {
+	if header.Index != s.Index+1 {
+		return nil, fmt.Errorf("block %d does not follow snapshot at %d", header.Index, s.Index)
+	}
+	snap := s.copy()
+
+	// Checkpoints discard all pending votes
+	if epoch > 0 && header.Index%epoch == 0 {
+		snap.Votes = nil
+		snap.Tally = make(map[string]*Tally)
+	}
+
+	// Let the signer that sealed the oldest recent block sign again
+	if limit := snap.signerLimit(); header.Index >= limit {
+		delete(snap.Recents, header.Index-limit)
+	}
+	if !snap.Signers[signer] {
+		return nil, fmt.Errorf("block %d is sealed by unauthorized signer %s", header.Index, signer)
+	}
+	if snap.recentlySigned(header.Index, signer) {
+		return nil, fmt.Errorf("block %d is sealed by recent signer %s", header.Index, signer)
+	}
+	snap.Recents[header.Index] = signer
+
+	if header.Coinbase != "" {
+		authorize := header.Nonce == nonceAuthVote
+
+		// A signer has at most one vote per address, so replace any earlier one
+		for i, vote := range snap.Votes {
+			if vote.Signer == signer && vote.Address == header.Coinbase {
+				snap.uncast(vote.Address, vote.Authorize)
+				snap.Votes = append(snap.Votes[:i], snap.Votes[i+1:]...)
+				break
+			}
+		}
+		if snap.cast(header.Coinbase, authorize) {
+			snap.Votes = append(snap.Votes, &Vote{
+				Signer:    signer,
+				Block:     header.Index,
+				Address:   header.Coinbase,
+				Authorize: authorize,
+			})
+		}
+
+		// Apply the change once a majority of signers agrees
+		if tally := snap.Tally[header.Coinbase]; tally != nil && tally.Votes > len(snap.Signers)/2 {
+			snap.applyChange(header.Index, header.Coinbase, tally.Authorize)
+		}
+	}
+
+	snap.Index = header.Index
+	snap.Hash = header.Hash
+	return snap, nil
+}
+
+// applyChange adds or removes a signer after a passed vote and clears the votes on it
+func (s *Snapshot) applyChange(index int, address string, authorize bool) {
+	if authorize {
+		s.Signers[address] = true
+	} else {
+		delete(s.Signers, address)
+
+		// The signer set shrank, so release the oldest recent signer
+		if limit := s.signerLimit(); index >= limit {
+			delete(s.Recents, index-limit)
+		}
+
+		// Drop the votes the removed signer cast
+		for i := 0; i < len(s.Votes); i++ {
+			if s.Votes[i].Signer == address {
+				s.uncast(s.Votes[i].Address, s.Votes[i].Authorize)
+				s.Votes = append(s.Votes[:i], s.Votes[i+1:]...)
+				i--
+			}
+		}
+	}
+
+	votes := s.Votes[:0]
+	for _, vote := range s.Votes {
+		if vote.Address != address {
+			votes = append(votes, vote)
+		}
+	}
+	s.Votes = votes
+	delete(s.Tally, address)
+}
@@ -0,0 +1,262 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default Tendermint timeouts. Each round waits Delta longer than the last.
+const (
+	DefaultProposeTimeout   = 3 * time.Second
+	DefaultPrevoteTimeout   = 1 * time.Second
+	DefaultPrecommitTimeout = 1 * time.Second
+	DefaultTimeoutDelta     = 500 * time.Millisecond
+	DefaultCommitTimeout    = 1 * time.Second
+)
+
+// CommitSig is one validator's precommit signature in a commit certificate
+type CommitSig struct {
+	Validator string `json:"validator"`
+	Signature string `json:"signature"`
+}
+
+// Commit certifies that validators holding more than two thirds of the voting
+// power precommitted a block, which makes it final
+type Commit struct {
+	Height     int          `json:"height"`
+	Round      int          `json:"round"`
+	BlockHash  string       `json:"blockHash"`
+	Precommits []*CommitSig `json:"precommits"`
+}
+
+// Timeouts configures how long each Tendermint step waits before moving on
+type Timeouts struct {
+	Propose   time.Duration
+	Prevote   time.Duration
+	Precommit time.Duration
+	Delta     time.Duration // Added to every timeout for each round after the first
+	Commit    time.Duration // Pause after committing a block to collect transactions for the next
+}
+
+// BFTBackend is the chain a Tendermint engine proposes, verifies and commits blocks for
+type BFTBackend interface {
+	// CurrentHeader returns the header of the latest committed block
+	CurrentHeader() *Header
+
+	// BuildProposal assembles and seals a new block on top of the current
+	// header and returns its header and encoded body
+	BuildProposal(ctx context.Context) (*Header, []byte, error)
+
+	// VerifyProposal checks a proposed block without a commit certificate
+	VerifyProposal(header *Header, payload []byte) error
+
+	// CommitBlock appends a proposed block whose header carries its commit certificate
+	CommitBlock(header *Header, payload []byte) error
+}
+
+// Tendermint is a BFT engine with instant finality. A known validator set
+// agrees on every block in rounds of propose, prevote and precommit, and a
+// block is committed once more than two thirds of the voting power precommit it.
+type Tendermint struct {
+	Timeouts   Timeouts
+	validators []*Validator
+	totalPower *big.Int
+
+	signer    string   // Address this node votes with
+	signFn    SignerFn // Signs proposals and votes for signer
+	transport Transport
+	inbox     chan *Message
+	round     int // Round of the proposal being built, read by Prepare
+	mutex     sync.Mutex
+}
+
+// NewTendermint creates a BFT engine over a fixed validator set
+func NewTendermint(validators []*Validator) *Tendermint {
+	set := make([]*Validator, 0, len(validators))
+	total := big.NewInt(0)
+	for _, validator := range validators {
+		set = append(set, &Validator{Address: normalizeAddress(validator.Address), Power: new(big.Int).Set(validator.Power)})
+		total.Add(total, validator.Power)
+	}
+	sort.Slice(set, func(i, j int) bool { return set[i].Address < set[j].Address })
+
+	return &Tendermint{
+		Timeouts: Timeouts{
+			Propose:   DefaultProposeTimeout,
+			Prevote:   DefaultPrevoteTimeout,
+			Precommit: DefaultPrecommitTimeout,
+			Delta:     DefaultTimeoutDelta,
+			Commit:    DefaultCommitTimeout,
+		},
+		validators: set,
+		totalPower: total,
+		inbox:      make(chan *Message, 4096),
+	}
+}
+
+// Authorize sets the key this node proposes and votes with
+func (t *Tendermint) Authorize(signer string, signFn SignerFn) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.signer = normalizeAddress(signer)
+	t.signFn = signFn
+}
+
+// Validators returns the validator set
+func (t *Tendermint) Validators() []*Validator {
+	return t.validators
+}
+
+// Prepare makes this node the proposer of the round being built
+func (t *Tendermint) Prepare(chain ChainReader, header *Header) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	header.Coinbase = t.signer
+	header.Nonce = uint64(t.round)
+	header.Difficulty = 0
+	return nil
+}
+
+// Finalize grants no rewards
+func (t *Tendermint) Finalize(chain ChainReader, header *Header) []*Reward {
+	return nil
+}
+
+// Seal signs the header as the proposer of its round. The block still needs
+// a commit certificate from the validators before it can be connected.
+func (t *Tendermint) Seal(ctx context.Context, chain ChainReader, header *Header) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	signer, signFn := t.signer, t.signFn
+	t.mutex.Unlock()
+
+	if signFn == nil {
+		return fmt.Errorf("no signing key configured")
+	}
+	if proposer := Proposer(t.validators, header.PreviousHash, int(header.Nonce)); proposer != signer {
+		return fmt.Errorf("%s is not the proposer of block %d round %d", signer, header.Index, header.Nonce)
+	}
+
+	signature, err := signFn([]byte(header.SealHash()))
+	if err != nil {
+		return fmt.Errorf("failed to sign header: %v", err)
+	}
+	header.Extra = signature
+	header.Hash = header.ComputeHash()
+	return nil
+}
+
+// VerifyHeader checks the proposer's signature, the timestamp and the commit certificate
+func (t *Tendermint) VerifyHeader(chain ChainReader, header *Header) error {
+	if err := t.VerifyProposal(header); err != nil {
+		return err
+	}
+	if header.Index == 0 {
+		return nil
+	}
+	if err := VerifyTimestamp(chain, header); err != nil {
+		return err
+	}
+	return t.VerifyCommit(header)
+}
+
+// VerifyProposal checks that a header is signed by the proposer of its round
+func (t *Tendermint) VerifyProposal(header *Header) error {
+	if header.Hash != header.ComputeHash() {
+		return fmt.Errorf("block %d hash mismatch", header.Index)
+	}
+	if header.Index == 0 {
+		return nil
+	}
+
+	signer, err := recoverSigner(header)
+	if err != nil {
+		return err
+	}
+	if signer != header.Coinbase {
+		return fmt.Errorf("block %d is signed by %s, not its coinbase %s", header.Index, signer, header.Coinbase)
+	}
+	if proposer := Proposer(t.validators, header.PreviousHash, int(header.Nonce)); proposer != signer {
+		return fmt.Errorf("block %d round %d must be proposed by %s, not %s", header.Index, header.Nonce, proposer, signer)
+	}
+	return nil
+}
+
+// VerifyCommit checks that more than two thirds of the voting power precommitted the header
+func (t *Tendermint) VerifyCommit(header *Header) error {
+	commit := header.Commit
+	if commit == nil {
+		return fmt.Errorf("block %d has no commit certificate", header.Index)
+	}
+	if commit.Height != header.Index || commit.BlockHash != header.Hash {
+		return fmt.Errorf("block %d commit certificate is for another block", header.Index)
+	}
+
+	power := big.NewInt(0)
+	seen := make(map[string]bool)
+	for _, sig := range commit.Precommits {
+		if seen[sig.Validator] {
+			return fmt.Errorf("block %d commit has duplicate precommits from %s", header.Index, sig.Validator)
+		}
+		seen[sig.Validator] = true
+
+		validator := t.validator(sig.Validator)
+		if validator == nil {
+			return fmt.Errorf("block %d commit has a precommit from non-validator %s", header.Index, sig.Validator)
+		}
+		vote := &Message{Type: MsgPrecommit, Height: commit.Height, Round: commit.Round, BlockHash: commit.BlockHash}
+		signer, err := recoverAddress(vote.signingBytes(), sig.Signature)
+		if err != nil || signer != sig.Validator {
+			return fmt.Errorf("block %d commit has an invalid precommit from %s", header.Index, sig.Validator)
+		}
+		power.Add(power, validator.Power)
+	}
+	if !t.hasQuorum(power) {
+		return fmt.Errorf("block %d commit has only %s of %s voting power", header.Index, power, t.totalPower)
+	}
+	return nil
+}
+
+// VerifySeal is VerifyHeader, as the validator set is fixed
+func (t *Tendermint) VerifySeal(chain ChainReader, header *Header) error {
+	return t.VerifyHeader(chain, header)
+}
+
+// Author returns the coinbase, which VerifyHeader requires to be the proposer
+func (t *Tendermint) Author(header *Header) (string, error) {
+	return header.Coinbase, nil
+}
+
+// Weight counts every committed block the same, committed blocks are final
+func (t *Tendermint) Weight(header *Header) *big.Int {
+	return big.NewInt(1)
+}
+
+// validator returns the validator with the given address, or nil
+func (t *Tendermint) validator(address string) *Validator {
+	for _, validator := range t.validators {
+		if validator.Address == address {
+			return validator
+		}
+	}
+	return nil
+}
+
+// hasQuorum reports whether power is more than two thirds of the total
+func (t *Tendermint) hasQuorum(power *big.Int) bool {
+	return new(big.Int).Mul(power, big.NewInt(3)).Cmp(new(big.Int).Mul(t.totalPower, big.NewInt(2))) > 0
+}
+
+// hasOneThird reports whether power is more than one third of the total, so
+// at least one honest validator is included
+func (t *Tendermint) hasOneThird(power *big.Int) bool {
+	return new(big.Int).Mul(power, big.NewInt(3)).Cmp(t.totalPower) > 0
+}
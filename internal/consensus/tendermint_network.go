@@ -0,0 +1,100 @@
+package consensus
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MessageType is the kind of a Tendermint consensus message
+type MessageType string
+
+const (
+	MsgProposal  MessageType = "proposal"
+	MsgPrevote   MessageType = "prevote"
+	MsgPrecommit MessageType = "precommit"
+)
+
+// Message is a signed proposal or vote exchanged between validators. Votes
+// with an empty BlockHash are votes for nil.
+type Message struct {
+	Type      MessageType `json:"type"`
+	Height    int         `json:"height"`
+	Round     int         `json:"round"`
+	BlockHash string      `json:"blockHash"`
+	POLRound  int         `json:"polRound"`          // Round in which the proposed block got a prevote quorum, or -1
+	Header    *Header     `json:"header,omitempty"`  // Proposals only
+	Payload   []byte      `json:"payload,omitempty"` // Proposals only, the encoded block body
+	Validator string      `json:"validator"`
+	Signature string      `json:"signature"`
+}
+
+// signingBytes serializes the fields of the message covered by its signature
+func (m *Message) signingBytes() []byte {
+	if m.Type == MsgProposal {
+		return []byte(fmt.Sprintf("%s:%d:%d:%s:%d", m.Type, m.Height, m.Round, m.BlockHash, m.POLRound))
+	}
+	return []byte(fmt.Sprintf("%s:%d:%d:%s", m.Type, m.Height, m.Round, m.BlockHash))
+}
+
+// Transport broadcasts consensus messages to all validators, including the sender
+type Transport interface {
+	Broadcast(msg *Message)
+}
+
+// SetTransport sets how this node reaches the other validators
+func (t *Tendermint) SetTransport(transport Transport) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.transport = transport
+}
+
+// HandleMessage queues a message received from another validator
+func (t *Tendermint) HandleMessage(msg *Message) {
+	t.inbox <- msg
+}
+
+// LocalNetwork connects Tendermint engines in the same process, for running
+// a multi-validator network on one machine
+type LocalNetwork struct {
+	nodes        []*Tendermint
+	disconnected map[*Tendermint]bool
+	mutex        sync.Mutex
+}
+
+// NewLocalNetwork creates an empty in-process network
+func NewLocalNetwork() *LocalNetwork {
+	return &LocalNetwork{disconnected: make(map[*Tendermint]bool)}
+}
+
+// Join connects an engine to the network
+func (n *LocalNetwork) Join(t *Tendermint) {
+	n.mutex.Lock()
+	n.nodes = append(n.nodes, t)
+	n.mutex.Unlock()
+	t.SetTransport(n)
+}
+
+// SetConnected cuts an engine off from the network or reconnects it
+func (n *LocalNetwork) SetConnected(t *Tendermint, connected bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.disconnected[t] = !connected
+}
+
+// Broadcast delivers a message to every connected engine
+func (n *LocalNetwork) Broadcast(msg *Message) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for _, node := range n.nodes {
+		if n.disconnected[node] {
+			continue
+		}
+		// Every node gets its own copy, as it would when decoded from the wire
+		cp := *msg
+		if msg.Header != nil {
+			cp.Header = msg.Header.Copy()
+		}
+		go node.HandleMessage(&cp)
+	}
+}
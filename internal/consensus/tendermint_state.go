@@ -0,0 +1,403 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// step is the phase of a Tendermint round
+type step int
+
+const (
+	stepPropose step = iota
+	stepPrevote
+	stepPrecommit
+)
+
+// timeout fires when a step of a round has waited too long
+type timeout struct {
+	height int
+	round  int
+	step   step
+}
+
+// proposal is a proposed block together with its encoded body
+type proposal struct {
+	header  *Header
+	payload []byte
+}
+
+// heightState tracks the rounds of agreement on a single block height
+type heightState struct {
+	height     int
+	parentHash string
+	round      int
+	step       step
+
+	lockedRound int
+	locked      *proposal
+	validRound  int
+	valid       *proposal
+
+	proposals map[int]*Message                            // Proposal message of each round
+	blocks    map[string]*proposal                        // Proposed blocks that passed verification, by hash
+	invalid   map[string]bool                             // Proposed blocks that failed verification
+	votes     map[MessageType]map[int]map[string]*Message // Votes by type, round and validator
+
+	prevoteWait   map[int]bool // Rounds where the prevote timeout is scheduled
+	precommitWait map[int]bool // Rounds where the precommit timeout is scheduled
+	decided       *proposal
+	decidedRound  int
+}
+
+// Run takes part in consensus for the backend's chain until ctx is cancelled.
+// Each height runs rounds of propose, prevote and precommit until more than
+// two thirds of the voting power precommit a block, which is then committed.
+func (t *Tendermint) Run(ctx context.Context, backend BFTBackend) error {
+	t.mutex.Lock()
+	signer := t.signer
+	t.mutex.Unlock()
+	if t.validator(signer) == nil {
+		return fmt.Errorf("%s is not a validator", signer)
+	}
+
+	timeouts := make(chan timeout, 64)
+	future := make(map[int][]*Message)
+	for {
+		parent := backend.CurrentHeader()
+		hs := &heightState{
+			height:        parent.Index + 1,
+			parentHash:    parent.Hash,
+			lockedRound:   -1,
+			validRound:    -1,
+			proposals:     make(map[int]*Message),
+			blocks:        make(map[string]*proposal),
+			invalid:       make(map[string]bool),
+			votes:         make(map[MessageType]map[int]map[string]*Message),
+			prevoteWait:   make(map[int]bool),
+			precommitWait: make(map[int]bool),
+		}
+		for height := range future {
+			if height < hs.height {
+				delete(future, height)
+			}
+		}
+		buffered := future[hs.height]
+		delete(future, hs.height)
+
+		t.startRound(ctx, backend, hs, 0, timeouts)
+		for _, msg := range buffered {
+			t.receive(ctx, backend, hs, msg, timeouts)
+		}
+
+		for hs.decided == nil {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case msg := <-t.inbox:
+				if msg.Height > hs.height {
+					future[msg.Height] = append(future[msg.Height], msg)
+					continue
+				}
+				t.receive(ctx, backend, hs, msg, timeouts)
+			case to := <-timeouts:
+				t.onTimeout(ctx, backend, hs, to, timeouts)
+			}
+
+			// Blocks connected by other means, such as syncing from peers, end the height
+			if hs.decided == nil && backend.CurrentHeader().Index >= hs.height {
+				break
+			}
+		}
+		if hs.decided == nil {
+			continue
+		}
+
+		header := hs.decided.header.Copy()
+		header.Commit = hs.commit(hs.decidedRound, header.Hash)
+		if err := backend.CommitBlock(header, hs.decided.payload); err != nil {
+			fmt.Printf("Failed to commit block %d: %v\n", header.Index, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(t.Timeouts.Commit):
+		}
+	}
+}
+
+// startRound moves to a round and proposes a block if this node is its proposer
+func (t *Tendermint) startRound(ctx context.Context, backend BFTBackend, hs *heightState, round int, timeouts chan timeout) {
+	hs.round = round
+	hs.step = stepPropose
+	t.schedule(ctx, hs, stepPropose, t.Timeouts.Propose, timeouts)
+
+	if Proposer(t.validators, hs.parentHash, round) == t.signer {
+		block, polRound := hs.valid, hs.validRound
+		if block == nil {
+			t.mutex.Lock()
+			t.round = round
+			t.mutex.Unlock()
+
+			header, payload, err := backend.BuildProposal(ctx)
+			if err != nil && ctx.Err() == nil {
+				fmt.Printf("Failed to build proposal for block %d round %d: %v\n", hs.height, round, err)
+			} else if err == nil {
+				block = &proposal{header: header, payload: payload}
+			}
+		}
+		if block != nil {
+			t.broadcast(&Message{
+				Type:      MsgProposal,
+				Height:    hs.height,
+				Round:     round,
+				BlockHash: block.header.Hash,
+				POLRound:  polRound,
+				Header:    block.header,
+				Payload:   block.payload,
+			})
+		}
+	}
+	t.advance(ctx, backend, hs, timeouts)
+}
+
+// receive records a message for the current height and applies the consensus rules
+func (t *Tendermint) receive(ctx context.Context, backend BFTBackend, hs *heightState, msg *Message, timeouts chan timeout) {
+	if msg.Height != hs.height || msg.Round < 0 || t.validator(msg.Validator) == nil {
+		return
+	}
+	signer, err := recoverAddress(msg.signingBytes(), msg.Signature)
+	if err != nil || signer != msg.Validator {
+		return
+	}
+
+	switch msg.Type {
+	case MsgProposal:
+		if msg.Validator != Proposer(t.validators, hs.parentHash, msg.Round) || msg.Header == nil {
+			return
+		}
+		if _, ok := hs.proposals[msg.Round]; ok {
+			return
+		}
+		hs.proposals[msg.Round] = msg
+		t.verifyBlock(backend, hs, msg)
+	case MsgPrevote, MsgPrecommit:
+		if hs.votes[msg.Type] == nil {
+			hs.votes[msg.Type] = make(map[int]map[string]*Message)
+		}
+		if hs.votes[msg.Type][msg.Round] == nil {
+			hs.votes[msg.Type][msg.Round] = make(map[string]*Message)
+		}
+		if _, ok := hs.votes[msg.Type][msg.Round][msg.Validator]; ok {
+			return
+		}
+		hs.votes[msg.Type][msg.Round][msg.Validator] = msg
+	default:
+		return
+	}
+
+	// More than a third of the power at a later round means this node fell behind
+	if msg.Round > hs.round && t.hasOneThird(hs.roundPower(t, msg.Round)) {
+		t.startRound(ctx, backend, hs, msg.Round, timeouts)
+		return
+	}
+	t.advance(ctx, backend, hs, timeouts)
+}
+
+// verifyBlock checks a proposed block once and remembers the result by hash
+func (t *Tendermint) verifyBlock(backend BFTBackend, hs *heightState, msg *Message) {
+	hash := msg.BlockHash
+	if _, ok := hs.blocks[hash]; ok || hs.invalid[hash] {
+		return
+	}
+	header := msg.Header
+	err := t.VerifyProposal(header)
+	if err == nil && (header.Hash != hash || header.Index != hs.height || header.PreviousHash != hs.parentHash) {
+		err = fmt.Errorf("proposal does not match its block")
+	}
+	if err == nil {
+		err = backend.VerifyProposal(header, msg.Payload)
+	}
+	if err != nil {
+		fmt.Printf("Rejected proposal for block %d round %d: %v\n", hs.height, msg.Round, err)
+		hs.invalid[hash] = true
+		return
+	}
+	hs.blocks[hash] = &proposal{header: header, payload: msg.Payload}
+}
+
+// advance applies every consensus rule whose conditions now hold
+func (t *Tendermint) advance(ctx context.Context, backend BFTBackend, hs *heightState, timeouts chan timeout) {
+	if hs.decided != nil {
+		return
+	}
+
+	// A block precommitted by a quorum in any round is decided
+	for round, msg := range hs.proposals {
+		if block := hs.blocks[msg.BlockHash]; block != nil && t.hasQuorum(hs.power(t, MsgPrecommit, round, msg.BlockHash)) {
+			hs.decided = block
+			hs.decidedRound = round
+			return
+		}
+	}
+
+	msg := hs.proposals[hs.round]
+	var block *proposal
+	if msg != nil {
+		block = hs.blocks[msg.BlockHash]
+	}
+
+	if hs.step == stepPropose && msg != nil {
+		if msg.POLRound < 0 {
+			// A fresh proposal is accepted unless this node is locked on another block
+			if block != nil && (hs.lockedRound == -1 || hs.locked.header.Hash == msg.BlockHash) {
+				t.vote(hs, MsgPrevote, msg.BlockHash)
+			} else {
+				t.vote(hs, MsgPrevote, "")
+			}
+			hs.step = stepPrevote
+		} else if msg.POLRound < hs.round && t.hasQuorum(hs.power(t, MsgPrevote, msg.POLRound, msg.BlockHash)) {
+			// A re-proposal backed by a quorum of prevotes may unlock an older lock
+			if block != nil && (hs.lockedRound <= msg.POLRound || hs.locked.header.Hash == msg.BlockHash) {
+				t.vote(hs, MsgPrevote, msg.BlockHash)
+			} else {
+				t.vote(hs, MsgPrevote, "")
+			}
+			hs.step = stepPrevote
+		}
+	}
+
+	if hs.step >= stepPrevote && !hs.prevoteWait[hs.round] && t.hasQuorum(hs.roundVotes(t, MsgPrevote, hs.round)) {
+		hs.prevoteWait[hs.round] = true
+		t.schedule(ctx, hs, stepPrevote, t.Timeouts.Prevote, timeouts)
+	}
+
+	if hs.step >= stepPrevote && block != nil && hs.validRound < hs.round && t.hasQuorum(hs.power(t, MsgPrevote, hs.round, msg.BlockHash)) {
+		if hs.step == stepPrevote {
+			hs.locked, hs.lockedRound = block, hs.round
+			t.vote(hs, MsgPrecommit, msg.BlockHash)
+			hs.step = stepPrecommit
+		}
+		hs.valid, hs.validRound = block, hs.round
+	}
+
+	if hs.step == stepPrevote && t.hasQuorum(hs.power(t, MsgPrevote, hs.round, "")) {
+		t.vote(hs, MsgPrecommit, "")
+		hs.step = stepPrecommit
+	}
+
+	if !hs.precommitWait[hs.round] && t.hasQuorum(hs.roundVotes(t, MsgPrecommit, hs.round)) {
+		hs.precommitWait[hs.round] = true
+		t.schedule(ctx, hs, stepPrecommit, t.Timeouts.Precommit, timeouts)
+	}
+}
+
+// onTimeout votes nil or moves to the next round when a step waited too long
+func (t *Tendermint) onTimeout(ctx context.Context, backend BFTBackend, hs *heightState, to timeout, timeouts chan timeout) {
+	if to.height != hs.height || to.round != hs.round || hs.decided != nil {
+		return
+	}
+	switch {
+	case to.step == stepPropose && hs.step == stepPropose:
+		t.vote(hs, MsgPrevote, "")
+		hs.step = stepPrevote
+		t.advance(ctx, backend, hs, timeouts)
+	case to.step == stepPrevote && hs.step == stepPrevote:
+		t.vote(hs, MsgPrecommit, "")
+		hs.step = stepPrecommit
+		t.advance(ctx, backend, hs, timeouts)
+	case to.step == stepPrecommit:
+		t.startRound(ctx, backend, hs, hs.round+1, timeouts)
+	}
+}
+
+// schedule delivers a timeout for the current step after its duration, which
+// grows with every round. The timeout is dropped once ctx is cancelled, since
+// nobody receives it after Run returns.
+func (t *Tendermint) schedule(ctx context.Context, hs *heightState, s step, base time.Duration, timeouts chan timeout) {
+	to := timeout{height: hs.height, round: hs.round, step: s}
+	time.AfterFunc(base+time.Duration(hs.round)*t.Timeouts.Delta, func() {
+		select {
+		case timeouts <- to:
+		case <-ctx.Done():
+		}
+	})
+}
+
+// vote broadcasts a prevote or precommit for the current round, empty hash for nil
+func (t *Tendermint) vote(hs *heightState, msgType MessageType, hash string) {
+	t.broadcast(&Message{Type: msgType, Height: hs.height, Round: hs.round, BlockHash: hash, POLRound: -1})
+}
+
+// broadcast signs a message as this node and sends it to all validators
+func (t *Tendermint) broadcast(msg *Message) {
+	t.mutex.Lock()
+	signer, signFn, transport := t.signer, t.signFn, t.transport
+	t.mutex.Unlock()
+	if signFn == nil || transport == nil {
+		return
+	}
+
+	msg.Validator = signer
+	signature, err := signFn(msg.signingBytes())
+	if err != nil {
+		fmt.Printf("Failed to sign %s: %v\n", msg.Type, err)
+		return
+	}
+	msg.Signature = signature
+	transport.Broadcast(msg)
+}
+
+// power sums the power of validators that cast a vote for hash in a round
+func (hs *heightState) power(t *Tendermint, msgType MessageType, round int, hash string) *big.Int {
+	power := big.NewInt(0)
+	for address, vote := range hs.votes[msgType][round] {
+		if vote.BlockHash == hash {
+			power.Add(power, t.validator(address).Power)
+		}
+	}
+	return power
+}
+
+// roundVotes sums the power of validators that cast a vote of a type in a round, for any block
+func (hs *heightState) roundVotes(t *Tendermint, msgType MessageType, round int) *big.Int {
+	power := big.NewInt(0)
+	for address := range hs.votes[msgType][round] {
+		power.Add(power, t.validator(address).Power)
+	}
+	return power
+}
+
+// roundPower sums the power of validators that sent any message in a round
+func (hs *heightState) roundPower(t *Tendermint, round int) *big.Int {
+	seen := make(map[string]bool)
+	if msg, ok := hs.proposals[round]; ok {
+		seen[msg.Validator] = true
+	}
+	for _, votes := range hs.votes {
+		for address := range votes[round] {
+			seen[address] = true
+		}
+	}
+	power := big.NewInt(0)
+	for address := range seen {
+		power.Add(power, t.validator(address).Power)
+	}
+	return power
+}
+
+// commit collects the precommits for hash in a round into a commit certificate
+func (hs *heightState) commit(round int, hash string) *Commit {
+	commit := &Commit{Height: hs.height, Round: round, BlockHash: hash}
+	for address, vote := range hs.votes[MsgPrecommit][round] {
+		if vote.BlockHash == hash {
+			commit.Precommits = append(commit.Precommits, &CommitSig{Validator: address, Signature: vote.Signature})
+		}
+	}
+	sort.Slice(commit.Precommits, func(i, j int) bool { return commit.Precommits[i].Validator < commit.Precommits[j].Validator })
+	return commit
+}
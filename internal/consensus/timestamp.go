@@ -0,0 +1,49 @@
+package consensus
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxTimestampDrift is how far past the node's clock a block's timestamp may be
+const MaxTimestampDrift = 2 * time.Minute
+
+// VerifyTimestamp checks that a header's timestamp can be read, is after its
+// parent's and is at most MaxTimestampDrift ahead of the node's clock. The
+// parent bound is left out if the chain lacks the parent, as for orphans, or
+// cannot read its timestamp. The genesis block is exempt.
+func VerifyTimestamp(chain ChainReader, header *Header) error {
+	if header.Index == 0 {
+		return nil
+	}
+	timestamp, ok := ParseTimestamp(header.Timestamp)
+	if !ok {
+		return fmt.Errorf("block %d has an invalid timestamp %q", header.Index, header.Timestamp)
+	}
+	if parent := chain.GetHeaderByHash(header.PreviousHash); parent != nil {
+		if parentTime, ok := ParseTimestamp(parent.Timestamp); ok && !timestamp.After(parentTime) {
+			return fmt.Errorf("block %d has timestamp %s, which is not after its parent's", header.Index, header.Timestamp)
+		}
+	}
+	if timestamp.After(time.Now().Add(MaxTimestampDrift)) {
+		return fmt.Errorf("block %d has timestamp %s, which is too far in the future", header.Index, header.Timestamp)
+	}
+	return nil
+}
+
+// ParseTimestamp reads a block timestamp written by time.Time.String, as
+// nodes and miners write them, or in RFC 3339
+func ParseTimestamp(timestamp string) (time.Time, bool) {
+	// Drop the monotonic clock reading that String appends
+	if i := strings.Index(timestamp, " m="); i >= 0 {
+		timestamp = timestamp[:i]
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", timestamp); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.RFC3339, timestamp); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,62 @@
+package consensus
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// headerChain is a chain reader over a list of headers
+type headerChain []*Header
+
+func (c headerChain) CurrentHeader() *Header { return c[len(c)-1] }
+
+func (c headerChain) GetHeaderByIndex(index int) *Header {
+	if index < 0 || index >= len(c) {
+		return nil
+	}
+	return c[index]
+}
+
+func (c headerChain) GetHeaderByHash(hash string) *Header {
+	for _, header := range c {
+		if header.Hash == hash {
+			return header
+		}
+	}
+	return nil
+}
+
+func (c headerChain) Config() *ChainConfig { return nil }
+
+func TestVerifyTimestamp(t *testing.T) {
+	now := time.Now()
+	parent := &Header{Index: 1, Hash: "parent", Timestamp: now.Add(-time.Minute).String()}
+	chain := headerChain{{Index: 0, Hash: "genesis", Timestamp: "genesis"}, parent}
+
+	tests := []struct {
+		name      string
+		header    *Header
+		expectErr string
+	}{
+		{"after parent", &Header{Index: 2, PreviousHash: "parent", Timestamp: now.String()}, ""},
+		{"rfc3339", &Header{Index: 2, PreviousHash: "parent", Timestamp: now.Format(time.RFC3339)}, ""},
+		{"within drift", &Header{Index: 2, PreviousHash: "parent", Timestamp: now.Add(MaxTimestampDrift / 2).String()}, ""},
+		{"same as parent", &Header{Index: 2, PreviousHash: "parent", Timestamp: parent.Timestamp}, "not after its parent"},
+		{"before parent", &Header{Index: 2, PreviousHash: "parent", Timestamp: now.Add(-time.Hour).String()}, "not after its parent"},
+		{"too far ahead", &Header{Index: 2, PreviousHash: "parent", Timestamp: now.Add(2 * MaxTimestampDrift).String()}, "future"},
+		{"unreadable", &Header{Index: 2, PreviousHash: "parent", Timestamp: "yesterday"}, "invalid timestamp"},
+		{"unknown parent", &Header{Index: 5, PreviousHash: "orphan", Timestamp: now.Add(-time.Hour).String()}, ""},
+		{"unreadable parent", &Header{Index: 1, PreviousHash: "genesis", Timestamp: now.String()}, ""},
+		{"genesis", &Header{Index: 0, Timestamp: "genesis"}, ""},
+	}
+	for _, test := range tests {
+		err := VerifyTimestamp(chain, test.header)
+		if test.expectErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+		if test.expectErr != "" && (err == nil || !strings.Contains(err.Error(), test.expectErr)) {
+			t.Errorf("%s: expected error containing %q, got %v", test.name, test.expectErr, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package filters
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// GenesisPrevHeader is the previous filter header used by the genesis block's filter
+var GenesisPrevHeader = hex.EncodeToString(make([]byte, sha256.Size))
+
+// BlockFilter is the compact filter of one block together with its filter header.
+// The filter covers every address a block touches.
+type BlockFilter struct {
+	BlockIndex int    `json:"blockIndex"`
+	BlockHash  string `json:"blockHash"`
+	Filter     string `json:"filter"` // Hex-encoded Golomb-coded set
+	Header     string `json:"header"` // Commits to this filter and all previous ones
+}
+
+// NewBlockFilter builds the filter for a block and chains its header to prevHeader
+func NewBlockFilter(blockIndex int, blockHash, prevHeader string, addresses []string) (*BlockFilter, error) {
+	key, err := keyFromBlockHash(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([][]byte, 0, len(addresses))
+	for _, address := range addresses {
+		if address != "" {
+			items = append(items, []byte(address))
+		}
+	}
+	encoded := BuildGCS(key, items)
+
+	return &BlockFilter{
+		BlockIndex: blockIndex,
+		BlockHash:  blockHash,
+		Filter:     hex.EncodeToString(encoded),
+		Header:     FilterHeader(encoded, prevHeader),
+	}, nil
+}
+
+// FilterHeader computes the header of a filter as sha256(sha256(filter) || prevHeader)
+func FilterHeader(filter []byte, prevHeader string) string {
+	filterHash := sha256.Sum256(filter)
+	header := sha256.Sum256([]byte(hex.EncodeToString(filterHash[:]) + prevHeader))
+	return hex.EncodeToString(header[:])
+}
+
+// VerifyHeader checks that the filter's header chains from prevHeader
+func (bf *BlockFilter) VerifyHeader(prevHeader string) error {
+	encoded, err := hex.DecodeString(bf.Filter)
+	if err != nil {
+		return fmt.Errorf("invalid filter encoding: %v", err)
+	}
+	if FilterHeader(encoded, prevHeader) != bf.Header {
+		return fmt.Errorf("filter header mismatch for block %d", bf.BlockIndex)
+	}
+	return nil
+}
+
+// MatchAny reports whether the block may touch any of the given addresses.
+// False positives occur at a rate of about 1/FilterM; false negatives never do.
+func (bf *BlockFilter) MatchAny(addresses []string) (bool, error) {
+	key, err := keyFromBlockHash(bf.BlockHash)
+	if err != nil {
+		return false, err
+	}
+	encoded, err := hex.DecodeString(bf.Filter)
+	if err != nil {
+		return false, fmt.Errorf("invalid filter encoding: %v", err)
+	}
+
+	items := make([][]byte, len(addresses))
+	for i, address := range addresses {
+		items[i] = []byte(address)
+	}
+	return MatchAnyGCS(key, encoded, items)
+}
+
+// keyFromBlockHash uses the first 16 bytes of the block hash as the SipHash key
+func keyFromBlockHash(blockHash string) (Key, error) {
+	var key Key
+	hashBytes, err := hex.DecodeString(blockHash)
+	if err != nil || len(hashBytes) < len(key) {
+		return key, fmt.Errorf("invalid block hash %q", blockHash)
+	}
+	copy(key[:], hashBytes)
+	return key, nil
+}
@@ -0,0 +1,161 @@
+package filters
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+const (
+	// FilterP is the Golomb-Rice coding parameter, as in BIP-158
+	FilterP = 19
+	// FilterM is the inverse false-positive rate, as in BIP-158
+	FilterM = 784931
+)
+
+// Key is the SipHash key of a filter, taken from the block hash
+type Key [16]byte
+
+// BuildGCS encodes items as a Golomb-coded set. Duplicate items are encoded once.
+func BuildGCS(key Key, items [][]byte) []byte {
+	unique := make(map[string]struct{}, len(items))
+	for _, item := range items {
+		unique[string(item)] = struct{}{}
+	}
+
+	n := uint64(len(unique))
+	values := make([]uint64, 0, n)
+	for item := range unique {
+		values = append(values, hashToRange(key, []byte(item), n*FilterM))
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	header := make([]byte, binary.MaxVarintLen64)
+	header = header[:binary.PutUvarint(header, n)]
+
+	w := &bitWriter{}
+	var last uint64
+	for _, value := range values {
+		delta := value - last
+		last = value
+		for q := delta >> FilterP; q > 0; q-- {
+			w.writeBit(1)
+		}
+		w.writeBit(0)
+		w.writeBits(delta, FilterP)
+	}
+	return append(header, w.bytes...)
+}
+
+// MatchGCS reports whether item may be a member of the encoded set
+func MatchGCS(key Key, filter []byte, item []byte) (bool, error) {
+	return MatchAnyGCS(key, filter, [][]byte{item})
+}
+
+// MatchAnyGCS reports whether any of the items may be a member of the encoded set
+func MatchAnyGCS(key Key, filter []byte, items [][]byte) (bool, error) {
+	n, read := binary.Uvarint(filter)
+	if read <= 0 {
+		return false, fmt.Errorf("invalid filter header")
+	}
+	if n == 0 || len(items) == 0 {
+		return false, nil
+	}
+
+	targets := make([]uint64, len(items))
+	for i, item := range items {
+		targets[i] = hashToRange(key, item, n*FilterM)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+
+	r := &bitReader{bytes: filter[read:]}
+	var value uint64
+	t := 0
+	for i := uint64(0); i < n; i++ {
+		delta, err := r.readGolomb()
+		if err != nil {
+			return false, fmt.Errorf("corrupt filter: %v", err)
+		}
+		value += delta
+		for t < len(targets) && targets[t] < value {
+			t++
+		}
+		if t == len(targets) {
+			return false, nil
+		}
+		if targets[t] == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// hashToRange maps an item uniformly onto [0, f) using SipHash and a 128-bit multiply
+func hashToRange(key Key, item []byte, f uint64) uint64 {
+	k0 := binary.LittleEndian.Uint64(key[:8])
+	k1 := binary.LittleEndian.Uint64(key[8:])
+	hi, _ := bits.Mul64(sipHash24(k0, k1, item), f)
+	return hi
+}
+
+type bitWriter struct {
+	bytes []byte
+	used  uint8 // Bits used in the last byte
+}
+
+func (w *bitWriter) writeBit(bit uint64) {
+	if w.used == 0 || w.used == 8 {
+		w.bytes = append(w.bytes, 0)
+		w.used = 0
+	}
+	if bit != 0 {
+		w.bytes[len(w.bytes)-1] |= 1 << (7 - w.used)
+	}
+	w.used++
+}
+
+func (w *bitWriter) writeBits(value uint64, count int) {
+	for i := count - 1; i >= 0; i-- {
+		w.writeBit((value >> uint(i)) & 1)
+	}
+}
+
+type bitReader struct {
+	bytes []byte
+	pos   int // Position in bits
+}
+
+func (r *bitReader) readBit() (uint64, error) {
+	if r.pos >= len(r.bytes)*8 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	bit := (r.bytes[r.pos/8] >> (7 - uint(r.pos%8))) & 1
+	r.pos++
+	return uint64(bit), nil
+}
+
+func (r *bitReader) readGolomb() (uint64, error) {
+	var q uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if bit == 0 {
+			break
+		}
+		q++
+	}
+
+	var remainder uint64
+	for i := 0; i < FilterP; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		remainder = remainder<<1 | bit
+	}
+	return q<<FilterP | remainder, nil
+}
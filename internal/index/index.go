@@ -0,0 +1,126 @@
+package index
+
+import (
+	"sort"
+)
+
+// TxLocation is the position of a confirmed transaction in the chain
+type TxLocation struct {
+	BlockIndex int    `json:"blockIndex"`
+	BlockHash  string `json:"blockHash"`
+	Position   int    `json:"position"`
+}
+
+// Index holds the secondary indexes used by explorer queries: transaction
+// hash to block position, address to transactions and token to holders.
+type Index struct {
+	Txs       map[string]*TxLocation     `json:"txs"`
+	Addresses map[string][]string        `json:"addresses"` // Transaction hashes in chain order
+	Holders   map[string]map[string]bool `json:"holders"`   // Token symbol to holder addresses
+	Height    int                        `json:"height"`    // Index of the last indexed block
+}
+
+// New creates an empty index
+func New() *Index {
+	return &Index{
+		Txs:       make(map[string]*TxLocation),
+		Addresses: make(map[string][]string),
+		Holders:   make(map[string]map[string]bool),
+		Height:    -1,
+	}
+}
+
+// AddTx records a confirmed transaction and the addresses it touches
+func (idx *Index) AddTx(txHash string, location *TxLocation, addresses []string) {
+	idx.Txs[txHash] = location
+	for _, address := range uniqueAddresses(addresses) {
+		idx.Addresses[address] = append(idx.Addresses[address], txHash)
+	}
+}
+
+// RemoveTx removes a transaction that was rolled back. Transactions are
+// removed in reverse chain order, so the hash is the last one for each address.
+func (idx *Index) RemoveTx(txHash string, addresses []string) {
+	delete(idx.Txs, txHash)
+	for _, address := range uniqueAddresses(addresses) {
+		hashes := idx.Addresses[address]
+		for i := len(hashes) - 1; i >= 0; i-- {
+			if hashes[i] == txHash {
+				hashes = append(hashes[:i], hashes[i+1:]...)
+				break
+			}
+		}
+		if len(hashes) == 0 {
+			delete(idx.Addresses, address)
+		} else {
+			idx.Addresses[address] = hashes
+		}
+	}
+}
+
+// SetHolder marks whether address currently holds a balance of the token
+func (idx *Index) SetHolder(tokenSymbol, address string, holds bool) {
+	holders := idx.Holders[tokenSymbol]
+	if !holds {
+		delete(holders, address)
+		return
+	}
+	if holders == nil {
+		holders = make(map[string]bool)
+		idx.Holders[tokenSymbol] = holders
+	}
+	holders[address] = true
+}
+
+// TxLocation returns where a transaction was confirmed
+func (idx *Index) TxLocation(txHash string) (*TxLocation, bool) {
+	location, ok := idx.Txs[txHash]
+	return location, ok
+}
+
+// AddressTxs returns a page of transaction hashes touching address, newest first,
+// along with the total number of transactions
+func (idx *Index) AddressTxs(address string, offset, limit int) ([]string, int) {
+	hashes := idx.Addresses[address]
+	total := len(hashes)
+
+	page := []string{}
+	for i := total - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, hashes[i])
+	}
+	return page, total
+}
+
+// TokenHolders returns a page of holder addresses of a token in sorted order,
+// along with the total number of holders
+func (idx *Index) TokenHolders(tokenSymbol string, offset, limit int) ([]string, int) {
+	holders := make([]string, 0, len(idx.Holders[tokenSymbol]))
+	for address := range idx.Holders[tokenSymbol] {
+		holders = append(holders, address)
+	}
+	sort.Strings(holders)
+	return paginate(holders, offset, limit), len(holders)
+}
+
+func paginate(items []string, offset, limit int) []string {
+	if offset >= len(items) {
+		return []string{}
+	}
+	end := offset + limit
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[offset:end]
+}
+
+func uniqueAddresses(addresses []string) []string {
+	seen := make(map[string]bool, len(addresses))
+	var unique []string
+	for _, address := range addresses {
+		if address != "" && !seen[address] {
+			seen[address] = true
+			unique = append(unique, address)
+		}
+	}
+	return unique
+}
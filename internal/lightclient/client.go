@@ -0,0 +1,290 @@
+package lightclient
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"tpy-blockchain/internal/blockchain"
+	"tpy-blockchain/internal/consensus"
+	"tpy-blockchain/internal/filters"
+)
+
+// headerBatchSize is the number of headers requested from a full node at once
+const headerBatchSize = 500
+
+// HeaderSource serves block headers and proofs, usually a full node's API
+type HeaderSource interface {
+	GetHeaders(from, to int) ([]*blockchain.BlockHeader, error)
+}
+
+// Client is a headers-only light client. It keeps a validated header chain
+// and checks proofs served by full nodes against it. Like a full node, it
+// follows the heaviest branch by the engine's weight.
+type Client struct {
+	headers     []*blockchain.BlockHeader
+	branch      []*blockchain.BlockHeader // Competing headers after a fork from headers, no heavier than them
+	engine      consensus.Engine
+	config      *consensus.ChainConfig
+	source      HeaderSource
+	genesisHash string
+	mutex       sync.Mutex
+}
+
+// NewClient creates a light client that checks headers with the chain's
+// consensus engine. If genesisHash is not empty the first synced header must match it.
+func NewClient(source HeaderSource, engine consensus.Engine, genesisHash string) *Client {
+	return &Client{
+		headers:     []*blockchain.BlockHeader{},
+		engine:      engine,
+		source:      source,
+		genesisHash: genesisHash,
+	}
+}
+
+// SetChainConfig sets the chain's upgrades, which must match the full node's
+func (c *Client) SetChainConfig(config *consensus.ChainConfig) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.config = config
+}
+
+// Sync downloads and validates headers until the source has no newer ones.
+// When the source has reorganized, it steps back to where its headers fork
+// from the synced ones. It returns the number of headers added.
+func (c *Client) Sync() (int, error) {
+	added := 0
+	from := c.Height() + 1
+	back := 1
+	for {
+		headers, err := c.source.GetHeaders(from, from+headerBatchSize-1)
+		if err != nil {
+			return added, fmt.Errorf("failed to fetch headers from %d: %v", from, err)
+		}
+		if len(headers) == 0 {
+			return added, nil
+		}
+		if !c.connects(headers[0]) {
+			// Fetch again from further back, skipping the headers both have
+			from -= back
+			if from < 0 {
+				from = 0
+			}
+			back *= 2
+			continue
+		}
+		n, err := c.addHeaders(headers)
+		added += n
+		if err != nil {
+			return added, err
+		}
+		if len(headers) < headerBatchSize {
+			return added, nil
+		}
+		from = headers[len(headers)-1].Index + 1
+	}
+}
+
+// AddHeaders validates headers and adds them to the header chain. Headers
+// that fork from it are kept on a competing branch, which replaces the
+// chain's headers after the fork once it is heavier.
+func (c *Client) AddHeaders(headers []*blockchain.BlockHeader) error {
+	_, err := c.addHeaders(headers)
+	return err
+}
+
+// addHeaders is AddHeaders that returns how many headers were new
+func (c *Client) addHeaders(headers []*blockchain.BlockHeader) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	added := 0
+	for _, header := range headers {
+		isNew, err := c.addHeader(header)
+		if err != nil {
+			return added, fmt.Errorf("invalid header %d: %v", header.Index, err)
+		}
+		if isNew {
+			added++
+		}
+	}
+	return added, nil
+}
+
+// connects reports whether a header's parent is synced or on the branch
+func (c *Client) connects(header *blockchain.BlockHeader) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if header.Index == 0 {
+		return true
+	}
+	if header.Index <= len(c.headers) && c.headers[header.Index-1].Hash == header.PreviousHash {
+		return true
+	}
+	return c.extendsBranch(header)
+}
+
+// extendsBranch reports whether a header follows the tip of the competing branch
+func (c *Client) extendsBranch(header *blockchain.BlockHeader) bool {
+	if len(c.branch) == 0 {
+		return false
+	}
+	tip := c.branch[len(c.branch)-1]
+	return header.Index == tip.Index+1 && header.PreviousHash == tip.Hash
+}
+
+// addHeader adds a header that extends the chain or the competing branch, or
+// starts a new branch from the chain. It reports whether the header was new.
+func (c *Client) addHeader(header *blockchain.BlockHeader) (bool, error) {
+	if header.Index < len(c.headers) && c.headers[header.Index].Hash == header.Hash {
+		return false, nil
+	}
+	if header.Index == len(c.headers) && (header.Index == 0 || header.PreviousHash == c.headers[len(c.headers)-1].Hash) {
+		if err := c.verifyHeader(c.headers, header); err != nil {
+			return false, err
+		}
+		c.headers = append(c.headers, header)
+		return true, nil
+	}
+
+	var branch []*blockchain.BlockHeader
+	switch {
+	case c.extendsBranch(header):
+		branch = c.branch
+	case header.Index > 0 && header.Index < len(c.headers) && header.PreviousHash == c.headers[header.Index-1].Hash:
+		// A new branch replaces the one kept before
+	default:
+		return false, fmt.Errorf("previous hash does not match a synced header %d", header.Index-1)
+	}
+	fork := header.Index - len(branch)
+	chain := append(append([]*blockchain.BlockHeader(nil), c.headers[:fork]...), branch...)
+	if err := c.verifyHeader(chain, header); err != nil {
+		return false, err
+	}
+	c.branch = append(branch, header)
+
+	if c.weight(c.branch).Cmp(c.weight(c.headers[fork:])) > 0 {
+		replaced := append([]*blockchain.BlockHeader(nil), c.headers[fork:]...)
+		c.headers = append(c.headers[:fork:fork], c.branch...)
+		c.branch = replaced
+		fmt.Printf("Light client switched to a heavier branch at header %d\n", fork)
+	}
+	return true, nil
+}
+
+// weight sums the fork-choice weight of headers
+func (c *Client) weight(headers []*blockchain.BlockHeader) *big.Int {
+	weight := big.NewInt(0)
+	for _, header := range headers {
+		weight.Add(weight, c.engine.Weight(header))
+	}
+	return weight
+}
+
+// verifyHeader checks that a header extends chain and the consensus rules
+func (c *Client) verifyHeader(chain []*blockchain.BlockHeader, header *blockchain.BlockHeader) error {
+	if header.Index != len(chain) {
+		return fmt.Errorf("expected header %d", len(chain))
+	}
+	if blockchain.CalculateHeaderHash(header) != header.Hash {
+		return fmt.Errorf("hash mismatch")
+	}
+	if header.Index == 0 {
+		if c.genesisHash != "" && header.Hash != c.genesisHash {
+			return fmt.Errorf("unexpected genesis %s", header.Hash)
+		}
+		return nil
+	}
+	if header.PreviousHash != chain[len(chain)-1].Hash {
+		return fmt.Errorf("previous hash does not match header %d", header.Index-1)
+	}
+	if err := c.config.VerifyVersion(header); err != nil {
+		return err
+	}
+	return c.engine.VerifyHeader(headerView{headers: chain, config: c.config}, header)
+}
+
+// headerView exposes a header chain to the consensus engine while the client's mutex is held
+type headerView struct {
+	headers []*blockchain.BlockHeader
+	config  *consensus.ChainConfig
+}
+
+func (v headerView) CurrentHeader() *consensus.Header {
+	if len(v.headers) == 0 {
+		return nil
+	}
+	return v.headers[len(v.headers)-1]
+}
+
+func (v headerView) GetHeaderByIndex(index int) *consensus.Header {
+	if index < 0 || index >= len(v.headers) {
+		return nil
+	}
+	return v.headers[index]
+}
+
+func (v headerView) GetHeaderByHash(hash string) *consensus.Header {
+	for i := len(v.headers) - 1; i >= 0; i-- {
+		if v.headers[i].Hash == hash {
+			return v.headers[i]
+		}
+	}
+	return nil
+}
+
+func (v headerView) Config() *consensus.ChainConfig {
+	return v.config
+}
+
+// Height returns the index of the latest validated header, or -1 if none
+func (c *Client) Height() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.headers) - 1
+}
+
+// Header returns the validated header at the given index
+func (c *Client) Header(index int) (*blockchain.BlockHeader, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if index < 0 || index >= len(c.headers) {
+		return nil, fmt.Errorf("header %d not synced", index)
+	}
+	return c.headers[index], nil
+}
+
+// VerifyTransaction checks that a transaction proof matches the synced header chain
+func (c *Client) VerifyTransaction(proof *blockchain.TxProof) error {
+	header, err := c.Header(proof.BlockIndex)
+	if err != nil {
+		return err
+	}
+	return proof.Verify(header)
+}
+
+// VerifyBalance checks that a balance proof matches the synced header chain
+func (c *Client) VerifyBalance(proof *blockchain.BalanceProof) error {
+	header, err := c.Header(proof.BlockIndex)
+	if err != nil {
+		return err
+	}
+	return proof.Verify(header)
+}
+
+// VerifyFilter checks that a compact filter belongs to a synced block and
+// chains from prevHeader, the filter header of the previous block
+func (c *Client) VerifyFilter(bf *filters.BlockFilter, prevHeader string) error {
+	header, err := c.Header(bf.BlockIndex)
+	if err != nil {
+		return err
+	}
+	if header.Hash != bf.BlockHash {
+		return fmt.Errorf("filter is for block %s, not %s", bf.BlockHash, header.Hash)
+	}
+	if bf.BlockIndex == 0 {
+		prevHeader = filters.GenesisPrevHeader
+	}
+	return bf.VerifyHeader(prevHeader)
+}
@@ -0,0 +1,120 @@
+package lightclient
+
+import (
+	"math/big"
+	"testing"
+	"tpy-blockchain/internal/blockchain"
+	"tpy-blockchain/internal/consensus"
+	"tpy-blockchain/internal/storage"
+)
+
+// chainSource serves the headers of a chain, and none past its tip as a full node's API does
+type chainSource struct {
+	bc *blockchain.Blockchain
+}
+
+func (s *chainSource) GetHeaders(from, to int) ([]*blockchain.BlockHeader, error) {
+	if from > s.bc.Height() {
+		return nil, nil
+	}
+	if to > s.bc.Height() {
+		to = s.bc.Height()
+	}
+	return s.bc.GetHeaders(from, to)
+}
+
+func newTestChains(t *testing.T, engine consensus.Engine) (*blockchain.Blockchain, *blockchain.Blockchain) {
+	t.Helper()
+	a, err := blockchain.NewBlockchainInDir(t.TempDir(), engine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.Close() })
+	b, err := blockchain.OpenBlockchainWithGenesis(storage.NewMemoryStore(), engine, a.Blocks[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, b
+}
+
+func extend(t *testing.T, bc *blockchain.Blockchain, blocks int, coinbase string) {
+	t.Helper()
+	for i := 0; i < blocks; i++ {
+		if err := bc.AddBlockWithCoinbase(nil, coinbase); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func expectTip(t *testing.T, c *Client, bc *blockchain.Blockchain) {
+	t.Helper()
+	tip := bc.Blocks[len(bc.Blocks)-1]
+	if c.Height() != tip.Index {
+		t.Fatalf("client height is %d, expected %d", c.Height(), tip.Index)
+	}
+	header, err := c.Header(tip.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Hash != tip.Hash {
+		t.Fatalf("client tip is %s, expected %s", header.Hash, tip.Hash)
+	}
+}
+
+func TestClientSwitchesToHeavierBranch(t *testing.T) {
+	engine := consensus.NewProofOfWork(1)
+	engine.Reward = big.NewInt(1000)
+	a, b := newTestChains(t, engine)
+	extend(t, a, 2, "a")
+	extend(t, b, 3, "b")
+
+	source := &chainSource{bc: a}
+	c := NewClient(source, engine, a.Blocks[0].Hash)
+	if _, err := c.Sync(); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	expectTip(t, c, a)
+
+	// The source reorganized onto the heavier branch
+	source.bc = b
+	if _, err := c.Sync(); err != nil {
+		t.Fatalf("failed to sync the heavier branch: %v", err)
+	}
+	expectTip(t, c, b)
+
+	// The old branch is kept and wins back once it is heavier
+	extend(t, a, 2, "a")
+	source.bc = a
+	if _, err := c.Sync(); err != nil {
+		t.Fatalf("failed to sync the grown branch: %v", err)
+	}
+	expectTip(t, c, a)
+}
+
+func TestClientRejectsInvalidHeaders(t *testing.T) {
+	engine := consensus.NewProofOfWork(1)
+	a, b := newTestChains(t, engine)
+	extend(t, a, 2, "a")
+	extend(t, b, 1, "b")
+
+	c := NewClient(&chainSource{bc: b}, engine, a.Blocks[0].Hash)
+	if _, err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	headers, err := a.GetHeaders(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := *headers[1]
+	tampered.StateRoot = "00"
+	if err := c.AddHeaders([]*blockchain.BlockHeader{headers[0], &tampered}); err == nil {
+		t.Fatalf("header with a changed state root accepted")
+	}
+	expectTip(t, c, b)
+
+	other := NewClient(&chainSource{bc: a}, engine, "ff")
+	if _, err := other.Sync(); err == nil {
+		t.Fatalf("chain with another genesis block accepted")
+	}
+}
@@ -0,0 +1,123 @@
+package lightclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"tpy-blockchain/internal/blockchain"
+	"tpy-blockchain/internal/filters"
+)
+
+// HTTPSource fetches headers and proofs from a full node's REST API
+type HTTPSource struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewHTTPSource creates a source for the full node at baseURL, e.g. http://localhost:8080
+func NewHTTPSource(baseURL string) *HTTPSource {
+	return &HTTPSource{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// GetHeaders fetches headers from..to inclusive. A range past the node's tip yields no headers.
+func (s *HTTPSource) GetHeaders(from, to int) ([]*blockchain.BlockHeader, error) {
+	var resp struct {
+		Headers []*blockchain.BlockHeader `json:"headers"`
+	}
+	found, err := s.get(fmt.Sprintf("/headers?from=%d&to=%d", from, to), &resp)
+	if err != nil || !found {
+		return nil, err
+	}
+	return resp.Headers, nil
+}
+
+// GetTxProof fetches an inclusion proof for a transaction hash
+func (s *HTTPSource) GetTxProof(txHash string) (*blockchain.TxProof, error) {
+	var proof blockchain.TxProof
+	found, err := s.get("/proofs/tx/"+url.PathEscape(txHash), &proof)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("transaction %s not found", txHash)
+	}
+	return &proof, nil
+}
+
+// GetBalanceProof fetches a balance proof for an address and optional token symbol
+func (s *HTTPSource) GetBalanceProof(address, tokenSymbol string) (*blockchain.BalanceProof, error) {
+	query := url.Values{"address": {address}}
+	if tokenSymbol != "" {
+		query.Set("token", tokenSymbol)
+	}
+	var proof blockchain.BalanceProof
+	found, err := s.get("/proofs/balance?"+query.Encode(), &proof)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("address %s not found", address)
+	}
+	return &proof, nil
+}
+
+// GetFilter fetches the compact filter of the block at the given index
+func (s *HTTPSource) GetFilter(index int) (*filters.BlockFilter, error) {
+	var bf filters.BlockFilter
+	found, err := s.get(fmt.Sprintf("/filters/%d", index), &bf)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("filter for block %d not found", index)
+	}
+	return &bf, nil
+}
+
+// GetFilterHeaders fetches the filter headers of blocks from..to inclusive
+func (s *HTTPSource) GetFilterHeaders(from, to int) ([]string, error) {
+	var resp struct {
+		Headers []string `json:"headers"`
+	}
+	found, err := s.get(fmt.Sprintf("/filter-headers?from=%d&to=%d", from, to), &resp)
+	if err != nil || !found {
+		return nil, err
+	}
+	return resp.Headers, nil
+}
+
+// get decodes a JSON response into result and reports false if the node
+// does not have what was asked for
+func (s *HTTPSource) get(path string, result interface{}) (bool, error) {
+	resp, err := s.Client.Get(s.BaseURL + path)
+	if err != nil {
+		return false, fmt.Errorf("request to %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		decodeErr := json.NewDecoder(resp.Body).Decode(&body)
+		// The node answers with a JSON error when it lacks a header, proof or
+		// filter. Other 404s, such as from a wrong URL or a proxy, are failures.
+		if resp.StatusCode == http.StatusNotFound && decodeErr == nil && body.Error != "" {
+			return false, nil
+		}
+		if body.Error == "" {
+			body.Error = http.StatusText(resp.StatusCode)
+		}
+		return false, fmt.Errorf("request to %s failed with status %d: %s", path, resp.StatusCode, body.Error)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return false, fmt.Errorf("failed to decode response from %s: %v", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,185 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+	"tpy-blockchain/internal/blockchain"
+	"tpy-blockchain/internal/consensus"
+)
+
+// DefaultInterval is how long the producer waits before retrying when there
+// is nothing to produce or this node may not seal the next block
+const DefaultInterval = time.Second
+
+// Producer continuously turns pending transactions into blocks. It builds a
+// template on top of the tip, seals it with the chain's consensus engine,
+// connects and saves the block, and announces it to the chain's subscribers.
+// Sealing is abandoned as soon as a competing block is connected.
+type Producer struct {
+	Interval   time.Duration // Wait between attempts when idle
+	AllowEmpty bool          // Produce blocks without pending transactions, e.g. to earn rewards
+
+	chain    *blockchain.Blockchain
+	coinbase string
+	produced int
+	running  bool
+	cancel   context.CancelFunc
+	done     chan struct{}
+	mutex    sync.Mutex
+}
+
+// NewProducer creates a stopped producer for the chain
+func NewProducer(chain *blockchain.Blockchain) *Producer {
+	return &Producer{
+		Interval: DefaultInterval,
+		chain:    chain,
+	}
+}
+
+// SetCoinbase sets the address that receives the rewards of produced blocks
+func (p *Producer) SetCoinbase(address string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.coinbase = address
+}
+
+// Coinbase returns the address that receives the rewards of produced blocks
+func (p *Producer) Coinbase() string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.coinbase
+}
+
+// Running reports whether the producer is started
+func (p *Producer) Running() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.running
+}
+
+// Produced returns the number of blocks produced since the producer was created
+func (p *Producer) Produced() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.produced
+}
+
+// Start begins producing blocks in the background. With a BFT engine the
+// producer takes part in consensus instead, proposing and committing blocks
+// together with the other validators.
+func (p *Producer) Start() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.running {
+		return fmt.Errorf("block producer is already running")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.done = make(chan struct{})
+	p.running = true
+
+	go func() {
+		defer close(p.done)
+		if tendermint, ok := p.chain.Engine().(*consensus.Tendermint); ok {
+			if err := tendermint.Run(ctx, p.chain.BFTBackend()); err != nil && ctx.Err() == nil {
+				fmt.Printf("Block producer stopped: %v\n", err)
+			}
+			return
+		}
+		p.loop(ctx)
+	}()
+	return nil
+}
+
+// Stop stops producing blocks and waits for the block being sealed to be abandoned
+func (p *Producer) Stop() error {
+	p.mutex.Lock()
+	if !p.running {
+		p.mutex.Unlock()
+		return fmt.Errorf("block producer is not running")
+	}
+	p.running = false
+	p.cancel()
+	done := p.done
+	p.mutex.Unlock()
+
+	<-done
+	return nil
+}
+
+// loop produces blocks until ctx is cancelled. The same error, e.g. that it
+// is another signer's turn, is only reported once in a row.
+func (p *Producer) loop(ctx context.Context) {
+	lastErr := ""
+	for {
+		if p.AllowEmpty || p.chain.PendingCount() > 0 {
+			err := p.produce(ctx)
+			if err == nil {
+				lastErr = ""
+				continue
+			}
+			if ctx.Err() == nil && err.Error() != lastErr {
+				fmt.Printf("Block production failed: %v\n", err)
+				lastErr = err.Error()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(p.Interval):
+		}
+	}
+}
+
+// produce seals one block on top of the tip and connects it. It returns nil
+// without a block if another block was connected first.
+func (p *Producer) produce(ctx context.Context) error {
+	// A block connected by anyone else makes the template stale
+	blocks, unsubscribe := p.chain.SubscribeBlocks()
+	defer unsubscribe()
+	template, err := p.chain.NewBlockTemplate(p.Coinbase())
+	if err != nil {
+		return err
+	}
+
+	sealCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		for {
+			select {
+			case block := <-blocks:
+				if block.Index >= template.Header.Index {
+					cancel()
+					return
+				}
+			case <-sealCtx.Done():
+				return
+			}
+		}
+	}()
+
+	block, err := p.chain.SealBlock(sealCtx, template)
+	if err == nil {
+		err = p.chain.ConnectBlock(block)
+	}
+	if err != nil {
+		if ctx.Err() == nil && p.chain.Height() >= template.Header.Index {
+			fmt.Printf("Abandoned block %d, a competing block arrived\n", template.Header.Index)
+			return nil
+		}
+		return err
+	}
+	if err := p.chain.Save(); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	p.produced++
+	p.mutex.Unlock()
+	fmt.Printf("Produced block %d with %d transactions: %s\n", block.Index, len(block.Transactions), block.Hash)
+	return nil
+}
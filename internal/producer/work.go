@@ -0,0 +1,184 @@
+package producer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"tpy-blockchain/internal/blockchain"
+	"tpy-blockchain/internal/consensus"
+)
+
+const (
+	// WorkRefresh is how long a block template is handed out before it is
+	// rebuilt to include newly pending transactions
+	WorkRefresh = 10 * time.Second
+
+	// maxJobs is how many outstanding jobs are kept for solutions to arrive
+	maxJobs = 64
+)
+
+// Work is a block template handed out to an external miner. The miner
+// searches for a nonce, and optionally an extra-nonce, for which the
+// proof-of-work hash of record = Prefix + fmt.Sprintf("%08d", nonce) + Suffix + extra
+// as hex is at most Target, i.e. starts with Difficulty zeros. The hash is
+// sha256(record), or scrypt(record, record, 1024, 1, 1, 32) if Algorithm is scrypt.
+type Work struct {
+	ID           string                 `json:"id"`
+	Height       int                    `json:"height"`
+	Header       *consensus.Header      `json:"header"`
+	Prefix       string                 `json:"prefix"`
+	Suffix       string                 `json:"suffix"`
+	Target       string                 `json:"target"`
+	Difficulty   int                    `json:"difficulty"`
+	Algorithm    consensus.PoWAlgorithm `json:"algorithm"`
+	Transactions []string               `json:"transactions"` // Hashes of the block's transactions
+	template     *blockchain.BlockTemplate
+	created      time.Time
+}
+
+// Solution is a solved job submitted by an external miner. Timestamp is only
+// set if the miner rolled the header timestamp.
+type Solution struct {
+	ID        string `json:"id"`
+	Nonce     uint64 `json:"nonce"`
+	Extra     string `json:"extra"`
+	Timestamp string `json:"timestamp"`
+}
+
+// WorkManager hands out proof-of-work block templates to external miners,
+// and validates and connects the blocks they solve
+type WorkManager struct {
+	chain   *blockchain.Blockchain
+	current map[string]*Work // Latest job for each coinbase
+	jobs    map[string]*Work // Outstanding jobs by ID
+	order   []string         // Job IDs, oldest first
+	mutex   sync.Mutex
+}
+
+// NewWorkManager creates a work manager for the chain
+func NewWorkManager(chain *blockchain.Blockchain) *WorkManager {
+	return &WorkManager{
+		chain:   chain,
+		current: make(map[string]*Work),
+		jobs:    make(map[string]*Work),
+	}
+}
+
+// GetWork returns a job paying block rewards to coinbase. Miners asking for
+// the same coinbase get the same job until the tip changes or the job is
+// older than WorkRefresh.
+func (m *WorkManager) GetWork(coinbase string) (*Work, error) {
+	pow, err := m.engine()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	height := m.chain.Height()
+	if work, ok := m.current[coinbase]; ok && work.Height == height+1 && time.Since(work.created) < WorkRefresh {
+		return work, nil
+	}
+
+	template, err := m.chain.NewBlockTemplate(coinbase)
+	if err != nil {
+		return nil, err
+	}
+	header := template.Header
+	prefix, suffix := header.WorkParts()
+	work := &Work{
+		ID:           header.SealHash(),
+		Height:       header.Index,
+		Header:       header,
+		Prefix:       prefix,
+		Suffix:       suffix,
+		Target:       strings.Repeat("0", header.Difficulty) + strings.Repeat("f", 64-header.Difficulty),
+		Difficulty:   header.Difficulty,
+		Algorithm:    pow.Algorithm,
+		Transactions: make([]string, 0, len(template.Transactions)),
+		template:     template,
+		created:      time.Now(),
+	}
+	for _, tx := range template.Transactions {
+		work.Transactions = append(work.Transactions, tx.Hash)
+	}
+
+	m.current[coinbase] = work
+	if _, ok := m.jobs[work.ID]; !ok {
+		m.jobs[work.ID] = work
+		m.order = append(m.order, work.ID)
+	}
+	m.prune(height)
+	return work, nil
+}
+
+// SubmitWork checks a solution against its job's target and connects and
+// saves the solved block
+func (m *WorkManager) SubmitWork(solution *Solution) (*blockchain.Block, error) {
+	pow, err := m.engine()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mutex.Lock()
+	work, ok := m.jobs[solution.ID]
+	m.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown or expired job %s", solution.ID)
+	}
+
+	header := work.Header.Copy()
+	header.Nonce = solution.Nonce
+	header.Extra = solution.Extra
+	if solution.Timestamp != "" {
+		// Checked with the header when the block is connected
+		header.Timestamp = solution.Timestamp
+	}
+	header.Hash = header.ComputeHash()
+	if proof := pow.Algorithm.Hash(header); !pow.ValidateProof(proof) {
+		return nil, fmt.Errorf("%s hash %s does not meet the target", pow.Algorithm, proof)
+	}
+
+	block := work.template.Block(header)
+	if err := m.chain.ConnectBlock(block); err != nil {
+		if m.chain.Height() >= block.Index {
+			return nil, fmt.Errorf("stale solution, block %d was already found", block.Index)
+		}
+		return nil, err
+	}
+	if err := m.chain.Save(); err != nil {
+		return nil, err
+	}
+	fmt.Printf("External miner solved block %d: %s\n", block.Index, block.Hash)
+	return block, nil
+}
+
+// engine returns the chain's proof-of-work engine
+func (m *WorkManager) engine() (*consensus.ProofOfWork, error) {
+	pow, ok := m.chain.Engine().(*consensus.ProofOfWork)
+	if !ok {
+		return nil, fmt.Errorf("chain does not use proof-of-work")
+	}
+	return pow, nil
+}
+
+// prune drops jobs that no longer extend the tip and the oldest jobs beyond maxJobs
+func (m *WorkManager) prune(height int) {
+	kept := m.order[:0]
+	for i, id := range m.order {
+		work := m.jobs[id]
+		if work.Height <= height || len(m.order)-i > maxJobs {
+			delete(m.jobs, id)
+			continue
+		}
+		kept = append(kept, id)
+	}
+	m.order = kept
+	for coinbase, work := range m.current {
+		if m.jobs[work.ID] == nil {
+			delete(m.current, coinbase)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The file store appends each block once to a segmented log as a record of
+// its payload length, the CRC-32C of the payload and the payload, which holds
+// the block's hash, header, body and derived data. Index files map heights to
+// records.
+const (
+	logSegmentSize   = 64 << 20 // Size at which a new log segment is started
+	entriesPerIndex  = 1000     // Heights in each index file
+	recordPrefixSize = 8        // Payload length and checksum
+	indexEntrySize   = 16       // Segment, payload length and offset
+	logSegmentPrefix = "blocks-"
+	logSegmentSuffix = ".log"
+	indexFilePrefix  = "index-"
+	indexFileSuffix  = ".idx"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// logEntry locates the record of a block in the log
+type logEntry struct {
+	segment int
+	offset  int64
+	length  int // Of the payload
+	hash    string
+}
+
+func logSegmentName(n int) string {
+	return fmt.Sprintf("%s%06d%s", logSegmentPrefix, n, logSegmentSuffix)
+}
+
+func indexFileName(n int) string {
+	return fmt.Sprintf("%s%06d%s", indexFilePrefix, n, indexFileSuffix)
+}
+
+// numberedFiles returns the numbers of the files in dir named prefix, a number and suffix
+func numberedFiles(dir, prefix, suffix string) ([]int, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block directory: %v", err)
+	}
+	var numbers []int
+	for _, file := range files {
+		name := file.Name()
+		if strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+			if n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)); err == nil {
+				numbers = append(numbers, n)
+			}
+		}
+	}
+	return numbers, nil
+}
+
+// derivedFlag marks the hash length of payloads that hold the length of the
+// body followed by derived data. Earlier versions wrote the body last.
+const derivedFlag = 0x8000
+
+// encodeRecord returns the log record of a block
+func encodeRecord(hash string, header, block, derived []byte) []byte {
+	payloadSize := 2 + len(hash) + 4 + len(header) + 4 + len(block) + len(derived)
+	record := make([]byte, recordPrefixSize+payloadSize)
+	payload := record[recordPrefixSize:]
+	binary.BigEndian.PutUint16(payload, uint16(len(hash))|derivedFlag)
+	copy(payload[2:], hash)
+	binary.BigEndian.PutUint32(payload[2+len(hash):], uint32(len(header)))
+	copy(payload[6+len(hash):], header)
+	blockStart := 6 + len(hash) + len(header)
+	binary.BigEndian.PutUint32(payload[blockStart:], uint32(len(block)))
+	copy(payload[blockStart+4:], block)
+	copy(payload[blockStart+4+len(block):], derived)
+
+	binary.BigEndian.PutUint32(record, uint32(payloadSize))
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(payload, crcTable))
+	return record
+}
+
+// decodePayload splits the payload of a record into the block's hash, header,
+// body and derived data
+func decodePayload(payload []byte) (string, []byte, []byte, []byte, error) {
+	if len(payload) < 2 {
+		return "", nil, nil, nil, fmt.Errorf("record too short")
+	}
+	hashLength := binary.BigEndian.Uint16(payload)
+	hashEnd := 2 + int(hashLength&^derivedFlag)
+	if len(payload) < hashEnd+4 {
+		return "", nil, nil, nil, fmt.Errorf("record too short")
+	}
+	headerEnd := hashEnd + 4 + int(binary.BigEndian.Uint32(payload[hashEnd:]))
+	if len(payload) < headerEnd {
+		return "", nil, nil, nil, fmt.Errorf("record too short")
+	}
+	hash, header := string(payload[2:hashEnd]), payload[hashEnd+4:headerEnd]
+	if hashLength&derivedFlag == 0 {
+		return hash, header, payload[headerEnd:], nil, nil
+	}
+	if len(payload) < headerEnd+4 {
+		return "", nil, nil, nil, fmt.Errorf("record too short")
+	}
+	blockEnd := headerEnd + 4 + int(binary.BigEndian.Uint32(payload[headerEnd:]))
+	if len(payload) < blockEnd {
+		return "", nil, nil, nil, fmt.Errorf("record too short")
+	}
+	return hash, header, payload[headerEnd+4 : blockEnd], payload[blockEnd:], nil
+}
+
+// readRecord reads and checks the payload of the record at entry from the
+// log segment f
+func readRecord(f io.ReaderAt, entry *logEntry) ([]byte, error) {
+	record := make([]byte, recordPrefixSize+entry.length)
+	if _, err := f.ReadAt(record, entry.offset); err != nil {
+		return nil, err
+	}
+	payload := record[recordPrefixSize:]
+	if int(binary.BigEndian.Uint32(record)) != entry.length || binary.BigEndian.Uint32(record[4:]) != crc32.Checksum(payload, crcTable) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	return payload, nil
+}
+
+// encodeIndex returns the content of index file n for the given entries
+func encodeIndex(entries []*logEntry, n int) []byte {
+	start := (n - 1) * entriesPerIndex
+	end := start + entriesPerIndex
+	if end > len(entries) {
+		end = len(entries)
+	}
+	data := make([]byte, 0, (end-start)*indexEntrySize)
+	for _, entry := range entries[start:end] {
+		var buf [indexEntrySize]byte
+		binary.BigEndian.PutUint32(buf[0:], uint32(entry.segment))
+		binary.BigEndian.PutUint32(buf[4:], uint32(entry.length))
+		binary.BigEndian.PutUint64(buf[8:], uint64(entry.offset))
+		data = append(data, buf[:]...)
+	}
+	return data
+}
+
+// decodeIndex returns the entries of an index file, without their hashes
+func decodeIndex(data []byte) ([]*logEntry, error) {
+	if len(data)%indexEntrySize != 0 || len(data) > entriesPerIndex*indexEntrySize {
+		return nil, fmt.Errorf("invalid size %d", len(data))
+	}
+	entries := make([]*logEntry, 0, len(data)/indexEntrySize)
+	for i := 0; i < len(data); i += indexEntrySize {
+		entries = append(entries, &logEntry{
+			segment: int(binary.BigEndian.Uint32(data[i:])),
+			length:  int(binary.BigEndian.Uint32(data[i+4:])),
+			offset:  int64(binary.BigEndian.Uint64(data[i+8:])),
+		})
+	}
+	return entries, nil
+}
+
+// loadIndex reads the index files, each but the last one full
+func (s *FileStore) loadIndex() error {
+	numbers, err := numberedFiles(s.dir, indexFilePrefix, indexFileSuffix)
+	if err != nil {
+		return err
+	}
+	for n := 1; n <= len(numbers); n++ {
+		name := indexFileName(n)
+		data, err := os.ReadFile(s.path(name))
+		if err != nil {
+			return fmt.Errorf("failed to read index file %s: %v", name, err)
+		}
+		data, err = s.verify(name, data)
+		if err != nil {
+			return err
+		}
+		entries, err := decodeIndex(data)
+		if err != nil {
+			return fmt.Errorf("index file %s is corrupted: %v", name, err)
+		}
+		if len(entries) < entriesPerIndex && n < len(numbers) {
+			return fmt.Errorf("index file %s is incomplete", name)
+		}
+		s.entries = append(s.entries, entries...)
+	}
+	return nil
+}
+
+// openLog checks the records of the index against the log and discards what
+// the log holds beyond them: a write that was not committed, or blocks that
+// were truncated. New blocks are appended to the highest segment.
+func (s *FileStore) openLog() error {
+	ends := make(map[int]int64) // End of the last indexed record in each segment
+	for height, entry := range s.entries {
+		f, err := s.logSegment(entry.segment)
+		if err != nil {
+			return err
+		}
+		payload, err := readRecord(f, entry)
+		if err != nil {
+			return fmt.Errorf("block %d in %s is corrupted: %v", height, logSegmentName(entry.segment), err)
+		}
+		hash, _, _, _, err := decodePayload(payload)
+		if err != nil {
+			return fmt.Errorf("block %d in %s is corrupted: %v", height, logSegmentName(entry.segment), err)
+		}
+		entry.hash = hash
+		if hash != "" {
+			s.byHash[hash] = height
+		}
+		s.live[entry.segment]++
+		if end := entry.offset + recordPrefixSize + int64(entry.length); end > ends[entry.segment] {
+			ends[entry.segment] = end
+		}
+	}
+
+	segments, err := numberedFiles(s.dir, logSegmentPrefix, logSegmentSuffix)
+	if err != nil {
+		return err
+	}
+	discarded := int64(0)
+	for _, n := range segments {
+		name := s.path(logSegmentName(n))
+		info, err := os.Stat(name)
+		if err != nil {
+			return fmt.Errorf("failed to read log segment %s: %v", name, err)
+		}
+		if s.live[n] == 0 {
+			s.closeSegment(n)
+			if err := os.Remove(name); err != nil {
+				return fmt.Errorf("failed to remove log segment %s: %v", name, err)
+			}
+			discarded += info.Size()
+			continue
+		}
+		if info.Size() > ends[n] {
+			if err := os.Truncate(name, ends[n]); err != nil {
+				return fmt.Errorf("failed to truncate log segment %s: %v", name, err)
+			}
+			discarded += info.Size() - ends[n]
+		}
+		if n >= s.segment {
+			s.segment, s.size = n, ends[n]
+		}
+	}
+	if s.segment == 0 {
+		s.segment = 1
+	}
+	if discarded > 0 {
+		fmt.Printf("Discarded %d bytes of the block log not covered by the index.\n", discarded)
+		return s.syncDir()
+	}
+	return nil
+}
+
+// logSegment returns log segment n opened for reading
+func (s *FileStore) logSegment(n int) (*os.File, error) {
+	if f, ok := s.segments[n]; ok {
+		return f, nil
+	}
+	f, err := os.Open(s.path(logSegmentName(n)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log segment: %v", err)
+	}
+	s.segments[n] = f
+	return f, nil
+}
+
+func (s *FileStore) closeSegment(n int) {
+	if f, ok := s.segments[n]; ok {
+		f.Close()
+		delete(s.segments, n)
+	}
+}
+
+// appendRecords writes records to the end of log segment n, starting at
+// offset, and syncs the segment
+func (s *FileStore) appendRecords(n int, offset int64, records []byte) error {
+	name := s.path(logSegmentName(n))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log segment %s: %v", name, err)
+	}
+	if _, err := f.WriteAt(records, offset); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to append to log segment %s: %v", name, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync log segment %s: %v", name, err)
+	}
+	return f.Close()
+}
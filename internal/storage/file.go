@@ -0,0 +1,433 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// blocksPerSegment is the number of blocks in each chain file of earlier versions
+const blocksPerSegment = 1000
+
+// FileStore is a ChainStore of files in a directory. Blocks are appended once
+// to an append-only log, see blocklog.go, and read from it by height through
+// index files, so saving a block costs the same however long the chain is.
+// Every record is a JSON file named after its key. A batch is written through
+// a write-ahead log, see commit, and every file is verified against its
+// checksum when read. Putting a block below the highest one removes the
+// blocks above it.
+type FileStore struct {
+	dir       string
+	mutex     sync.Mutex
+	entries   []*logEntry       // Records of the blocks by height
+	byHash    map[string]int    // Height of each block
+	live      map[int]int       // Number of entries in each log segment
+	segment   int               // Log segment new blocks are appended to
+	size      int64             // Size of that segment
+	segments  map[int]*os.File  // Log segments opened for reading
+	checksums map[string]string // By file name, see checksumFile
+}
+
+// segment is the content of a chain file written by earlier versions
+type segment struct {
+	Blocks  []json.RawMessage `json:"blocks"`
+	Headers []json.RawMessage `json:"headers"`
+	Hashes  []string          `json:"hashes"`
+}
+
+// legacyBlock holds the fields of a block that place it in the chain. Chain
+// files written by the earliest versions list blocks without headers or hashes.
+type legacyBlock struct {
+	Index int    `json:"index"`
+	Hash  string `json:"hash"`
+}
+
+// NewFileStore opens the file store in dir, creating the directory if needed.
+// A write interrupted by a crash is finished or discarded first, and chain
+// files of earlier versions are moved to the block log.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create block directory: %v", err)
+	}
+	s := &FileStore{
+		dir:      dir,
+		byHash:   make(map[string]int),
+		live:     make(map[int]int),
+		segments: make(map[int]*os.File),
+	}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	if err := s.loadChecksums(); err != nil {
+		return nil, err
+	}
+	if err := s.loadIndex(); err != nil {
+		return nil, err
+	}
+	if err := s.openLog(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err := s.convertChainFiles(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// segmentCount returns the number of the highest chain file
+func (s *FileStore) segmentCount() (int, error) {
+	numbers, err := numberedFiles(s.dir, "chain", ".json")
+	if err != nil {
+		return 0, err
+	}
+	highest := 0
+	for _, n := range numbers {
+		if n > highest {
+			highest = n
+		}
+	}
+	return highest, nil
+}
+
+func segmentName(n int) string {
+	return fmt.Sprintf("chain%d.json", n)
+}
+
+func recordName(key string) string {
+	return key + ".json"
+}
+
+func (s *FileStore) segmentFile(n int) string {
+	return s.path(segmentName(n))
+}
+
+func (s *FileStore) recordFile(key string) string {
+	return s.path(recordName(key))
+}
+
+// convertChainFiles moves the blocks of chain files written by earlier
+// versions to the block log and removes the files in the same batch. The
+// earliest versions kept the chain state in the newest chain file, which
+// becomes the state record.
+func (s *FileStore) convertChainFiles() error {
+	segments, err := s.segmentCount()
+	if err != nil || segments == 0 {
+		return err
+	}
+	if len(s.entries) > 0 {
+		return fmt.Errorf("block directory %s holds both chain files and a block log", s.dir)
+	}
+
+	blocks := newBlockIndex()
+	for n := 1; n <= segments; n++ {
+		if err := s.loadSegment(blocks, n); err != nil {
+			return err
+		}
+	}
+	b := &batch{}
+	for height := range blocks.blocks {
+		b.PutBlock(height, blocks.hashes[height], blocks.headers[height], blocks.blocks[height], nil)
+	}
+	if _, err := os.Stat(s.recordFile("state")); os.IsNotExist(err) {
+		if state, err := s.legacyState(segments); err == nil {
+			b.Put("state", state)
+		}
+	}
+	removed := make([]*fileChange, 0, segments)
+	for n := 1; n <= segments; n++ {
+		removed = append(removed, &fileChange{name: segmentName(n)})
+	}
+
+	if err := s.writeBatch(b.ops, removed); err != nil {
+		return fmt.Errorf("failed to convert chain files: %v", err)
+	}
+	fmt.Printf("Moved %d blocks from %d chain files to the block log.\n", len(blocks.blocks), segments)
+	return nil
+}
+
+// loadSegment reads chain file n into blocks
+func (s *FileStore) loadSegment(blocks *blockIndex, n int) error {
+	filename := s.segmentFile(n)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %v", filename, err)
+	}
+	data, err = s.verify(segmentName(n), data)
+	if err != nil {
+		return err
+	}
+	var seg segment
+	if err := json.Unmarshal(data, &seg); err != nil {
+		return fmt.Errorf("failed to unmarshal data from file %s: %v", filename, err)
+	}
+
+	if seg.Hashes == nil {
+		// The earliest versions wrote the latest blocks to each file, so
+		// files may overlap and blocks are placed by their own index
+		for _, raw := range seg.Blocks {
+			var block legacyBlock
+			if err := json.Unmarshal(raw, &block); err != nil {
+				return fmt.Errorf("failed to unmarshal block in file %s: %v", filename, err)
+			}
+			if err := blocks.put(block.Index, block.Hash, nil, raw, nil); err != nil {
+				return fmt.Errorf("file %s: %v", filename, err)
+			}
+		}
+		return nil
+	}
+
+	if len(seg.Headers) != len(seg.Blocks) || len(seg.Hashes) != len(seg.Blocks) {
+		return fmt.Errorf("file %s lists %d blocks, %d headers and %d hashes", filename, len(seg.Blocks), len(seg.Headers), len(seg.Hashes))
+	}
+	start := (n - 1) * blocksPerSegment
+	for i, raw := range seg.Blocks {
+		if err := blocks.put(start+i, seg.Hashes[i], seg.Headers[i], raw, nil); err != nil {
+			return fmt.Errorf("file %s: %v", filename, err)
+		}
+	}
+	return nil
+}
+
+// legacyState returns the newest chain file if it was written by the earliest versions
+func (s *FileStore) legacyState(segments int) ([]byte, error) {
+	data, err := os.ReadFile(s.segmentFile(segments))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var seg segment
+	if json.Unmarshal(data, &seg) != nil || seg.Hashes != nil {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *FileStore) Height() (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.entries) - 1, nil
+}
+
+// record reads the header, body and derived data of the block at height from the log
+func (s *FileStore) record(height int) ([]byte, []byte, []byte, error) {
+	if height < 0 || height >= len(s.entries) {
+		return nil, nil, nil, ErrNotFound
+	}
+	entry := s.entries[height]
+	f, err := s.logSegment(entry.segment)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	payload, err := readRecord(f, entry)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("block %d in %s is corrupted: %v", height, logSegmentName(entry.segment), err)
+	}
+	_, header, block, derived, err := decodePayload(payload)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("block %d in %s is corrupted: %v", height, logSegmentName(entry.segment), err)
+	}
+	return header, block, derived, nil
+}
+
+func (s *FileStore) BlockByHeight(height int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, block, _, err := s.record(height)
+	return block, err
+}
+
+func (s *FileStore) DerivedByHeight(height int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, _, derived, err := s.record(height)
+	if err == nil && len(derived) == 0 {
+		return nil, ErrNotFound
+	}
+	return derived, err
+}
+
+func (s *FileStore) BlockByHash(hash string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	height, ok := s.byHash[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	_, block, _, err := s.record(height)
+	return block, err
+}
+
+// header returns the header of the block at height, ErrNotFound if the
+// block was stored without one
+func (s *FileStore) header(height int) ([]byte, error) {
+	header, _, _, err := s.record(height)
+	if err != nil {
+		return nil, err
+	}
+	if len(header) == 0 {
+		return nil, ErrNotFound
+	}
+	return header, nil
+}
+
+func (s *FileStore) HeaderByHeight(height int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.header(height)
+}
+
+func (s *FileStore) HeaderByHash(hash string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	height, ok := s.byHash[hash]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return s.header(height)
+}
+
+// Get reads the record stored under key
+func (s *FileStore) Get(key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data, err := os.ReadFile(s.recordFile(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read record %s: %v", key, err)
+	}
+	return s.verify(recordName(key), data)
+}
+
+func (s *FileStore) NewBatch() Batch {
+	return &batch{write: func(ops []*op) error {
+		return s.writeBatch(ops, nil)
+	}}
+}
+
+// writeBatch appends the batch's blocks to the block log, then commits the
+// index files from the lowest changed height on, the changed records and
+// the extra changes. Until the commit, the appended records are not in the
+// index, so a crash leaves them to be discarded by openLog.
+func (s *FileStore) writeBatch(ops []*op, extra []*fileChange) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries := append([]*logEntry(nil), s.entries...)
+	live := make(map[int]int, len(s.live))
+	for n, count := range s.live {
+		live[n] = count
+	}
+	changed := len(entries)
+	segment, size := s.segment, s.size
+	var records []byte // Not yet written to segment
+	offset := size     // Where records go in segment
+	truncate := func(height int) {
+		for _, entry := range entries[height:] {
+			live[entry.segment]--
+		}
+		entries = entries[:height]
+		if height < changed {
+			changed = height
+		}
+	}
+	flush := func() error {
+		if len(records) == 0 {
+			return nil
+		}
+		err := s.appendRecords(segment, offset, records)
+		offset, records = size, nil
+		return err
+	}
+
+	for _, o := range ops {
+		switch o.kind {
+		case opPutBlock:
+			if o.height < 0 || o.height > len(entries) {
+				return fmt.Errorf("cannot store block %d above height %d", o.height, len(entries)-1)
+			}
+			truncate(o.height)
+			// A segment full of removed blocks is left to be deleted
+			if size >= logSegmentSize || (size > 0 && live[segment] == 0) {
+				if err := flush(); err != nil {
+					return err
+				}
+				segment, size, offset = segment+1, 0, 0
+			}
+			record := encodeRecord(o.hash, o.header, o.value, o.derived)
+			entries = append(entries, &logEntry{segment: segment, offset: size, length: len(record) - recordPrefixSize, hash: o.hash})
+			live[segment]++
+			records = append(records, record...)
+			size += int64(len(record))
+		case opTruncate:
+			if o.height+1 < len(entries) {
+				truncate(o.height + 1)
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	// Index files
+	changes := extra
+	if changed < len(s.entries) || changed < len(entries) {
+		last := (len(entries) + entriesPerIndex - 1) / entriesPerIndex
+		for n := changed/entriesPerIndex + 1; n <= last; n++ {
+			changes = append(changes, &fileChange{name: indexFileName(n), data: encodeIndex(entries, n)})
+		}
+		for n := last + 1; n <= (len(s.entries)+entriesPerIndex-1)/entriesPerIndex; n++ {
+			changes = append(changes, &fileChange{name: indexFileName(n)})
+		}
+	}
+	var removed []int
+	for n, count := range live {
+		if count == 0 && n != segment {
+			changes = append(changes, &fileChange{name: logSegmentName(n)})
+			removed = append(removed, n)
+		}
+	}
+
+	// Records
+	for _, o := range ops {
+		switch o.kind {
+		case opPut:
+			changes = append(changes, &fileChange{name: recordName(o.hash), data: o.value})
+		case opDelete:
+			changes = append(changes, &fileChange{name: recordName(o.hash)})
+		}
+	}
+
+	if err := s.commit(changes); err != nil {
+		return err
+	}
+
+	for height := changed; height < len(s.entries); height++ {
+		if s.byHash[s.entries[height].hash] == height {
+			delete(s.byHash, s.entries[height].hash)
+		}
+	}
+	for height := changed; height < len(entries); height++ {
+		if entries[height].hash != "" {
+			s.byHash[entries[height].hash] = height
+		}
+	}
+	for _, n := range removed {
+		s.closeSegment(n)
+		delete(live, n)
+	}
+	s.entries, s.live, s.segment, s.size = entries, live, segment, size
+	return nil
+}
+
+func (s *FileStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for n := range s.segments {
+		s.closeSegment(n)
+	}
+	return nil
+}
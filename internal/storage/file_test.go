@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func openFileStore(t *testing.T, dir string) *FileStore {
+	t.Helper()
+	s, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func putBlocks(t *testing.T, s ChainStore, from, to int) {
+	t.Helper()
+	batch := s.NewBatch()
+	for height := from; height <= to; height++ {
+		hash := strings.Repeat(string(rune('a'+height%26)), 8)
+		batch.PutBlock(height, hash, []byte("header"), []byte("block"), []byte("derived"))
+	}
+	if err := batch.Write(); err != nil {
+		t.Fatalf("failed to write blocks %d-%d: %v", from, to, err)
+	}
+}
+
+func putRecord(t *testing.T, s ChainStore, key, value string) {
+	t.Helper()
+	batch := s.NewBatch()
+	batch.Put(key, []byte(value))
+	if err := batch.Write(); err != nil {
+		t.Fatalf("failed to write record %s: %v", key, err)
+	}
+}
+
+func expectRecord(t *testing.T, s ChainStore, key, value string) {
+	t.Helper()
+	data, err := s.Get(key)
+	if err != nil {
+		t.Fatalf("failed to read record %s: %v", key, err)
+	}
+	if string(data) != value {
+		t.Fatalf("record %s is %q, expected %q", key, data, value)
+	}
+}
+
+// writeUncommitted does what commit does before the write-ahead log is in
+// place: it writes the new content of records to temporary files. It returns
+// the log entries that commit the writes.
+func writeUncommitted(t *testing.T, s *FileStore, records map[string]string) []*walEntry {
+	t.Helper()
+	var entries []*walEntry
+	for key, value := range records {
+		data := seal([]byte(value))
+		if err := writeFileSync(s.path(recordName(key)+tmpSuffix), data); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, &walEntry{Name: recordName(key), Checksum: checksum(data)})
+	}
+	return entries
+}
+
+func TestFileStoreReplaysCommittedBatchAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	putRecord(t, s, "state", "old state")
+	putRecord(t, s, "index", "old index")
+
+	// Crash after the log is in place, before any file was moved
+	entries := writeUncommitted(t, s, map[string]string{"state": "new state", "index": "new index"})
+	if err := s.writeLog(entries); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openFileStore(t, dir)
+	expectRecord(t, s, "state", "new state")
+	expectRecord(t, s, "index", "new index")
+	if _, err := os.Stat(s.path(walFile)); !os.IsNotExist(err) {
+		t.Fatalf("write-ahead log was not removed after recovery")
+	}
+}
+
+func TestFileStoreReplaysPartlyAppliedBatch(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	putRecord(t, s, "state", "old state")
+	putRecord(t, s, "index", "old index")
+
+	// Crash after the first file of the batch was moved over its target
+	entries := writeUncommitted(t, s, map[string]string{"state": "new state", "index": "new index"})
+	if err := s.writeLog(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(s.path(entries[0].Name+tmpSuffix), s.path(entries[0].Name)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openFileStore(t, dir)
+	expectRecord(t, s, "state", "new state")
+	expectRecord(t, s, "index", "new index")
+}
+
+func TestFileStoreDiscardsUncommittedBatch(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	putRecord(t, s, "state", "old state")
+
+	// Crash before the log is in place
+	writeUncommitted(t, s, map[string]string{"state": "new state"})
+	s.Close()
+
+	s = openFileStore(t, dir)
+	expectRecord(t, s, "state", "old state")
+	if _, err := os.Stat(s.path(recordName("state") + tmpSuffix)); !os.IsNotExist(err) {
+		t.Fatalf("temporary file of the uncommitted batch was not removed")
+	}
+}
+
+func TestFileStoreRefusesLogWithMissingFiles(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	putRecord(t, s, "state", "old state")
+
+	entries := writeUncommitted(t, s, map[string]string{"state": "new state"})
+	if err := s.writeLog(entries); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(s.path(entries[0].Name + tmpSuffix)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if _, err := NewFileStore(dir); err == nil {
+		t.Fatal("store opened although a committed file is missing")
+	}
+}
+
+func TestFileStoreDiscardsUnindexedBlocks(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	putBlocks(t, s, 0, 4)
+
+	// Crash after records were appended to the log, before the index was committed
+	segment := s.path(logSegmentName(1))
+	before, err := os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := append(encodeRecord("ffffffff", []byte("header"), []byte("block"), nil), 0x01, 0x02)
+	if err := os.WriteFile(segment, append(append([]byte(nil), before...), torn...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openFileStore(t, dir)
+	if height, err := s.Height(); err != nil || height != 4 {
+		t.Fatalf("height after recovery is %d (%v), expected 4", height, err)
+	}
+	after, err := os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, before) {
+		t.Fatalf("log segment is %d bytes after recovery, expected %d", len(after), len(before))
+	}
+	if _, err := s.BlockByHash("ffffffff"); err != ErrNotFound {
+		t.Fatalf("unindexed block is readable: %v", err)
+	}
+
+	// The log keeps growing from where the index ends
+	putBlocks(t, s, 5, 6)
+	s.Close()
+	s = openFileStore(t, dir)
+	if height, err := s.Height(); err != nil || height != 6 {
+		t.Fatalf("height after reopening is %d (%v), expected 6", height, err)
+	}
+}
+
+func TestFileStoreDetectsCorruptedFiles(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	putRecord(t, s, "state", "balances")
+	putBlocks(t, s, 0, 2)
+
+	name := s.path(recordName("state"))
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0] ^= 0xff
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("state"); err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Fatalf("corrupted record was read: %v", err)
+	}
+	s.Close()
+
+	segment := s.path(logSegmentName(1))
+	data, err = os.ReadFile(segment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(segment, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(dir); err == nil {
+		t.Fatal("store opened with a corrupted block record")
+	}
+}
+
+func TestFileStoreTruncatesAndReplacesBlocks(t *testing.T) {
+	dir := t.TempDir()
+	s := openFileStore(t, dir)
+	putBlocks(t, s, 0, 5)
+
+	batch := s.NewBatch()
+	batch.Truncate(2)
+	batch.PutBlock(3, "replaced", []byte("header"), []byte("other block"), nil)
+	if err := batch.Write(); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openFileStore(t, dir)
+	if height, err := s.Height(); err != nil || height != 3 {
+		t.Fatalf("height is %d (%v), expected 3", height, err)
+	}
+	block, err := s.BlockByHash("replaced")
+	if err != nil || string(block) != "other block" {
+		t.Fatalf("replaced block is %q (%v)", block, err)
+	}
+	if _, err := s.DerivedByHeight(3); err != ErrNotFound {
+		t.Fatalf("block stored without derived data has some: %v", err)
+	}
+	if derived, err := s.DerivedByHeight(2); err != nil || string(derived) != "derived" {
+		t.Fatalf("derived data of block 2 is %q (%v)", derived, err)
+	}
+}
@@ -0,0 +1,125 @@
+package storage
+
+// IndexedStore is a ChainStore that also keeps the chain in queryable tables,
+// so lookups don't scan blocks in memory. The tables reflect the chain as of
+// the last written batch.
+type IndexedStore interface {
+	ChainStore
+
+	// IndexedHeight returns the height of the highest block in the tables,
+	// which lags behind Height for blocks stored before the tables existed
+	IndexedHeight() (int, error)
+
+	// TxLocation returns where the transaction with the given hash was
+	// confirmed, the latest block if it was confirmed more than once
+	TxLocation(hash string) (*TxRef, error)
+
+	// AddressTxs returns a page of the transactions sent or received by
+	// address, newest first, and their total number
+	AddressTxs(address string, offset, limit int) ([]*TxRef, int, error)
+
+	// TokenHolders returns a page of the addresses with a balance of the
+	// token, in address order, and their total number
+	TokenHolders(token string, offset, limit int) ([]string, int, error)
+
+	// Receipt returns the receipt of the transaction at the given position
+	Receipt(height, position int) (*ReceiptRow, error)
+
+	Proposals(token string) ([]*ProposalRow, error)
+	Votes(token, proposalID string) ([]*VoteRow, error)
+}
+
+// IndexBatch is the Batch of an IndexedStore. Truncate also removes the rows
+// of the removed blocks.
+type IndexBatch interface {
+	Batch
+
+	// IndexBlock adds the rows of a stored block or one put in the same batch,
+	// replacing rows already indexed at its height
+	IndexBlock(row *BlockRow)
+
+	// SetBalances replaces all balances
+	SetBalances(rows []*BalanceRow)
+
+	// SetProposals replaces all proposals
+	SetProposals(rows []*ProposalRow)
+}
+
+// BlockRow is a block with its transactions and their receipts and votes
+type BlockRow struct {
+	Height       int
+	Hash         string
+	PreviousHash string
+	Version      int
+	Timestamp    string
+	MerkleRoot   string
+	StateRoot    string
+	Difficulty   int
+	Nonce        uint64
+	Coinbase     string
+	Author       string // Paid the tips of the block, "" if they were burned
+	BaseFee      string // Empty before the fee market
+	Extra        string
+	Transactions []*TxRow
+	Votes        []*VoteRow
+}
+
+// TxRow is a confirmed transaction and its receipt
+type TxRow struct {
+	Position    int
+	Hash        string
+	Type        string
+	Sender      string
+	Receiver    string
+	Amount      string
+	TokenSymbol string
+	Data        string
+	Nonce       uint64
+	MaxFee      string // Empty if the transaction offers no fees
+	PriorityFee string
+	FeeBurned   string // Base fee paid
+	Tip         string // Priority fee paid to the block's author
+}
+
+// TxRef locates a confirmed transaction
+type TxRef struct {
+	Height    int
+	BlockHash string
+	Position  int
+}
+
+// ReceiptRow is what a confirmed transaction paid
+type ReceiptRow struct {
+	TxRef
+	FeeBurned    string
+	Tip          string
+	TipRecipient string
+}
+
+// BalanceRow is the balance of an address in a token, "" for the native balance
+type BalanceRow struct {
+	Address string
+	Token   string
+	Balance string
+}
+
+// ProposalRow is a governance proposal of a token with its tally
+type ProposalRow struct {
+	Token       string
+	ID          string
+	Title       string
+	Description string
+	Status      string
+	YesVotes    string
+	NoVotes     string
+}
+
+// VoteRow is a vote cast on a proposal by a confirmed transaction
+type VoteRow struct {
+	Height     int
+	Position   int
+	Token      string
+	ProposalID string
+	Voter      string
+	Yes        bool
+}
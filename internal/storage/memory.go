@@ -0,0 +1,106 @@
+package storage
+
+import "sync"
+
+// MemoryStore is a ChainStore that keeps everything in memory, for tests and
+// throwaway nodes. Its contents are lost when the process exits.
+type MemoryStore struct {
+	mutex   sync.Mutex
+	blocks  *blockIndex
+	records map[string][]byte
+}
+
+// NewMemoryStore creates an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{blocks: newBlockIndex(), records: make(map[string][]byte)}
+}
+
+func (s *MemoryStore) Height() (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.blocks.blocks) - 1, nil
+}
+
+func (s *MemoryStore) BlockByHeight(height int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.blocks.blockByHeight(height)
+}
+
+func (s *MemoryStore) BlockByHash(hash string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	height, err := s.blocks.heightOf(hash)
+	if err != nil {
+		return nil, err
+	}
+	return s.blocks.blockByHeight(height)
+}
+
+func (s *MemoryStore) HeaderByHeight(height int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.blocks.headerByHeight(height)
+}
+
+func (s *MemoryStore) HeaderByHash(hash string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	height, err := s.blocks.heightOf(hash)
+	if err != nil {
+		return nil, err
+	}
+	return s.blocks.headerByHeight(height)
+}
+
+func (s *MemoryStore) DerivedByHeight(height int) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.blocks.derivedByHeight(height)
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	value, ok := s.records[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *MemoryStore) NewBatch() Batch {
+	return &batch{write: s.write}
+}
+
+// write applies a batch to a copy of the blocks, so a failed batch changes nothing
+func (s *MemoryStore) write(ops []*op) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	blocks := s.blocks.copy()
+	records := make(map[string][]byte, len(s.records))
+	for key, value := range s.records {
+		records[key] = value
+	}
+	for _, o := range ops {
+		switch o.kind {
+		case opPutBlock:
+			if err := blocks.put(o.height, o.hash, o.header, o.value, o.derived); err != nil {
+				return err
+			}
+		case opTruncate:
+			blocks.truncate(o.height)
+		case opPut:
+			records[o.hash] = o.value
+		case opDelete:
+			delete(records, o.hash)
+		}
+	}
+	s.blocks, s.records = blocks, records
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration changes the database schema from the previous version to version
+type migration struct {
+	version     int
+	description string
+	statements  string
+}
+
+// migrations lists every schema change in order. Applied migrations must
+// never change; new ones are appended with the next version.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "encoded blocks and records",
+		statements: `
+		CREATE TABLE IF NOT EXISTS chain_blocks (
+			height INTEGER PRIMARY KEY,
+			hash   TEXT NOT NULL UNIQUE,
+			header BLOB NOT NULL,
+			block  BLOB NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS chain_records (
+			key   TEXT PRIMARY KEY,
+			value BLOB NOT NULL
+		);
+		`,
+	},
+	{
+		version:     2,
+		description: "headers, transactions, receipts, balances and governance tables",
+		statements: `
+		CREATE TABLE headers (
+			height        INTEGER PRIMARY KEY REFERENCES chain_blocks (height),
+			hash          TEXT NOT NULL UNIQUE,
+			previous_hash TEXT NOT NULL,
+			version       INTEGER NOT NULL,
+			timestamp     TEXT NOT NULL,
+			merkle_root   TEXT NOT NULL,
+			state_root    TEXT NOT NULL,
+			difficulty    INTEGER NOT NULL,
+			nonce         INTEGER NOT NULL,
+			coinbase      TEXT NOT NULL,
+			base_fee      TEXT,
+			extra         TEXT NOT NULL,
+			tx_count      INTEGER NOT NULL
+		);
+
+		CREATE TABLE transactions (
+			block_height INTEGER NOT NULL REFERENCES headers (height),
+			position     INTEGER NOT NULL,
+			hash         TEXT NOT NULL,
+			type         TEXT NOT NULL,
+			sender       TEXT NOT NULL,
+			receiver     TEXT NOT NULL,
+			amount       TEXT NOT NULL,
+			token_symbol TEXT NOT NULL,
+			data         TEXT NOT NULL,
+			nonce        INTEGER NOT NULL,
+			max_fee      TEXT,
+			priority_fee TEXT,
+			PRIMARY KEY (block_height, position)
+		);
+		CREATE INDEX transactions_hash ON transactions (hash);
+		CREATE INDEX transactions_sender ON transactions (sender, block_height, position);
+		CREATE INDEX transactions_receiver ON transactions (receiver, block_height, position);
+
+		CREATE TABLE receipts (
+			block_height  INTEGER NOT NULL,
+			position      INTEGER NOT NULL,
+			fee_burned    TEXT NOT NULL,
+			tip           TEXT NOT NULL,
+			tip_recipient TEXT NOT NULL,
+			PRIMARY KEY (block_height, position),
+			FOREIGN KEY (block_height, position) REFERENCES transactions (block_height, position)
+		);
+
+		CREATE TABLE balances (
+			address TEXT NOT NULL,
+			token   TEXT NOT NULL,
+			balance TEXT NOT NULL,
+			PRIMARY KEY (address, token)
+		);
+		CREATE INDEX balances_token ON balances (token, address);
+
+		CREATE TABLE proposals (
+			token       TEXT NOT NULL,
+			id          TEXT NOT NULL,
+			title       TEXT NOT NULL,
+			description TEXT NOT NULL,
+			status      TEXT NOT NULL,
+			yes_votes   TEXT NOT NULL,
+			no_votes    TEXT NOT NULL,
+			PRIMARY KEY (token, id)
+		);
+
+		CREATE TABLE votes (
+			block_height INTEGER NOT NULL,
+			position     INTEGER NOT NULL,
+			token        TEXT NOT NULL,
+			proposal_id  TEXT NOT NULL,
+			voter        TEXT NOT NULL,
+			choice       TEXT NOT NULL CHECK (choice IN ('yes', 'no')),
+			PRIMARY KEY (block_height, position),
+			FOREIGN KEY (block_height, position) REFERENCES transactions (block_height, position)
+		);
+		CREATE INDEX votes_proposal ON votes (token, proposal_id, block_height);
+		`,
+	},
+	{
+		version:     3,
+		description: "data derived from each block, such as its filter",
+		statements: `
+		ALTER TABLE chain_blocks ADD COLUMN derived BLOB;
+		`,
+	},
+}
+
+// SchemaVersion is the database schema version this node migrates to
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate applies the migrations the database is missing, each in its own
+// transaction, and records them in the schema_migrations table. Databases
+// created before versioning have the tables of version 1, which it creates
+// only if they don't exist.
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at  TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create migrations table: %v", err)
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if current > SchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than the supported version %d", current, SchemaVersion())
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", m.version, m.description, err)
+		}
+		fmt.Printf("Applied database migration %d: %s\n", m.version, m.description)
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.statements); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)", m.version, m.description, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
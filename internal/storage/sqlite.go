@@ -0,0 +1,490 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite driver
+)
+
+// SQLiteStore is an IndexedStore in a SQLite database. Each batch is written
+// in one transaction. The schema is brought up to date on open, see migrations.
+type SQLiteStore struct {
+	db *sql.DB
+
+	// The balances and proposals in the tables, loaded by the first batch
+	// that sets them, so later batches write only the rows that changed
+	balances  map[rowKey]string
+	proposals map[rowKey]ProposalRow
+}
+
+// rowKey is the primary key of a balance (address, token) or a proposal (token, id)
+type rowKey struct {
+	a, b string
+}
+
+// OpenSQLiteStore opens or creates the SQLite store at dbPath and applies
+// any missing schema migrations
+func OpenSQLiteStore(dbPath string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// maxHeight returns the highest height in a table, -1 if it is empty
+func (s *SQLiteStore) maxHeight(table string) (int, error) {
+	var height sql.NullInt64
+	if err := s.db.QueryRow("SELECT MAX(height) FROM " + table).Scan(&height); err != nil {
+		return 0, fmt.Errorf("failed to query height: %v", err)
+	}
+	if !height.Valid {
+		return -1, nil
+	}
+	return int(height.Int64), nil
+}
+
+func (s *SQLiteStore) Height() (int, error) {
+	return s.maxHeight("chain_blocks")
+}
+
+func (s *SQLiteStore) IndexedHeight() (int, error) {
+	return s.maxHeight("headers")
+}
+
+// queryValue returns the single value of a query, ErrNotFound if it has no rows
+func (s *SQLiteStore) queryValue(query string, args ...interface{}) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRow(query, args...).Scan(&value)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database: %v", err)
+	}
+	return value, nil
+}
+
+func (s *SQLiteStore) BlockByHeight(height int) ([]byte, error) {
+	return s.queryValue("SELECT block FROM chain_blocks WHERE height = ?", height)
+}
+
+func (s *SQLiteStore) BlockByHash(hash string) ([]byte, error) {
+	return s.queryValue("SELECT block FROM chain_blocks WHERE hash = ?", hash)
+}
+
+func (s *SQLiteStore) HeaderByHeight(height int) ([]byte, error) {
+	return s.queryValue("SELECT header FROM chain_blocks WHERE height = ?", height)
+}
+
+func (s *SQLiteStore) HeaderByHash(hash string) ([]byte, error) {
+	return s.queryValue("SELECT header FROM chain_blocks WHERE hash = ?", hash)
+}
+
+func (s *SQLiteStore) DerivedByHeight(height int) ([]byte, error) {
+	derived, err := s.queryValue("SELECT derived FROM chain_blocks WHERE height = ? AND derived IS NOT NULL", height)
+	if err == nil && len(derived) == 0 {
+		return nil, ErrNotFound
+	}
+	return derived, err
+}
+
+func (s *SQLiteStore) Get(key string) ([]byte, error) {
+	return s.queryValue("SELECT value FROM chain_records WHERE key = ?", key)
+}
+
+func (s *SQLiteStore) TxLocation(hash string) (*TxRef, error) {
+	ref := &TxRef{}
+	err := s.db.QueryRow(`
+		SELECT t.block_height, h.hash, t.position FROM transactions t
+		JOIN headers h ON h.height = t.block_height
+		WHERE t.hash = ? ORDER BY t.block_height DESC LIMIT 1`, hash).Scan(&ref.Height, &ref.BlockHash, &ref.Position)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transaction: %v", err)
+	}
+	return ref, nil
+}
+
+func (s *SQLiteStore) AddressTxs(address string, offset, limit int) ([]*TxRef, int, error) {
+	var total int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM transactions WHERE sender = ? OR receiver = ?", address, address).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count transactions: %v", err)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT t.block_height, h.hash, t.position FROM transactions t
+		JOIN headers h ON h.height = t.block_height
+		WHERE t.sender = ? OR t.receiver = ?
+		ORDER BY t.block_height DESC, t.position DESC LIMIT ? OFFSET ?`, address, address, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query transactions: %v", err)
+	}
+	defer rows.Close()
+
+	refs := []*TxRef{}
+	for rows.Next() {
+		ref := &TxRef{}
+		if err := rows.Scan(&ref.Height, &ref.BlockHash, &ref.Position); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan transaction: %v", err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, total, rows.Err()
+}
+
+func (s *SQLiteStore) TokenHolders(token string, offset, limit int) ([]string, int, error) {
+	var total int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM balances WHERE token = ? AND balance <> '0'", token).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count holders: %v", err)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT address FROM balances WHERE token = ? AND balance <> '0'
+		ORDER BY address LIMIT ? OFFSET ?`, token, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query holders: %v", err)
+	}
+	defer rows.Close()
+
+	holders := []string{}
+	for rows.Next() {
+		var address string
+		if err := rows.Scan(&address); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan holder: %v", err)
+		}
+		holders = append(holders, address)
+	}
+	return holders, total, rows.Err()
+}
+
+func (s *SQLiteStore) Receipt(height, position int) (*ReceiptRow, error) {
+	receipt := &ReceiptRow{}
+	err := s.db.QueryRow(`
+		SELECT r.block_height, h.hash, r.position, r.fee_burned, r.tip, r.tip_recipient FROM receipts r
+		JOIN headers h ON h.height = r.block_height
+		WHERE r.block_height = ? AND r.position = ?`, height, position).Scan(
+		&receipt.Height, &receipt.BlockHash, &receipt.Position, &receipt.FeeBurned, &receipt.Tip, &receipt.TipRecipient)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query receipt: %v", err)
+	}
+	return receipt, nil
+}
+
+func (s *SQLiteStore) Proposals(token string) ([]*ProposalRow, error) {
+	rows, err := s.db.Query(`
+		SELECT token, id, title, description, status, yes_votes, no_votes FROM proposals
+		WHERE token = ? ORDER BY rowid`, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query proposals: %v", err)
+	}
+	defer rows.Close()
+
+	proposals := []*ProposalRow{}
+	for rows.Next() {
+		p := &ProposalRow{}
+		if err := rows.Scan(&p.Token, &p.ID, &p.Title, &p.Description, &p.Status, &p.YesVotes, &p.NoVotes); err != nil {
+			return nil, fmt.Errorf("failed to scan proposal: %v", err)
+		}
+		proposals = append(proposals, p)
+	}
+	return proposals, rows.Err()
+}
+
+func (s *SQLiteStore) Votes(token, proposalID string) ([]*VoteRow, error) {
+	rows, err := s.db.Query(`
+		SELECT block_height, position, token, proposal_id, voter, choice FROM votes
+		WHERE token = ? AND proposal_id = ? ORDER BY block_height, position`, token, proposalID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query votes: %v", err)
+	}
+	defer rows.Close()
+
+	votes := []*VoteRow{}
+	for rows.Next() {
+		v := &VoteRow{}
+		var choice string
+		if err := rows.Scan(&v.Height, &v.Position, &v.Token, &v.ProposalID, &v.Voter, &choice); err != nil {
+			return nil, fmt.Errorf("failed to scan vote: %v", err)
+		}
+		v.Yes = choice == "yes"
+		votes = append(votes, v)
+	}
+	return votes, rows.Err()
+}
+
+// sqliteBatch is a batch that can also change the queryable tables
+type sqliteBatch struct {
+	*batch
+}
+
+func (b *sqliteBatch) IndexBlock(row *BlockRow) {
+	b.ops = append(b.ops, &op{kind: opIndexBlock, height: row.Height, rows: row})
+}
+
+func (b *sqliteBatch) SetBalances(rows []*BalanceRow) {
+	b.ops = append(b.ops, &op{kind: opSetBalances, rows: rows})
+}
+
+func (b *sqliteBatch) SetProposals(rows []*ProposalRow) {
+	b.ops = append(b.ops, &op{kind: opSetProposals, rows: rows})
+}
+
+func (s *SQLiteStore) NewBatch() Batch {
+	return &sqliteBatch{&batch{write: s.write}}
+}
+
+func (s *SQLiteStore) write(ops []*op) error {
+	height, err := s.Height()
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	balances, proposals := s.balances, s.proposals
+	for _, o := range ops {
+		switch o.kind {
+		case opPutBlock:
+			if o.height < 0 || o.height > height+1 {
+				return fmt.Errorf("cannot store block %d above height %d", o.height, height)
+			}
+			// A replaced block's rows and hash must be gone before the new one is inserted
+			if err = deleteBlocks(tx, "=", o.height); err == nil {
+				_, err = tx.Exec("INSERT INTO chain_blocks (height, hash, header, block, derived) VALUES (?, ?, ?, ?, ?)", o.height, o.hash, o.header, o.value, o.derived)
+			}
+			if o.height > height {
+				height = o.height
+			}
+		case opTruncate:
+			err = deleteBlocks(tx, ">", o.height)
+			if o.height < height {
+				height = o.height
+			}
+		case opPut:
+			_, err = tx.Exec("INSERT OR REPLACE INTO chain_records (key, value) VALUES (?, ?)", o.hash, o.value)
+		case opDelete:
+			_, err = tx.Exec("DELETE FROM chain_records WHERE key = ?", o.hash)
+		case opIndexBlock:
+			err = indexBlock(tx, o.rows.(*BlockRow))
+		case opSetBalances:
+			balances, err = setBalances(tx, balances, o.rows.([]*BalanceRow))
+		case opSetProposals:
+			proposals, err = setProposals(tx, proposals, o.rows.([]*ProposalRow))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write batch: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit batch: %v", err)
+	}
+	s.balances, s.proposals = balances, proposals
+	return nil
+}
+
+// deleteBlocks removes the blocks whose height compares to height with op,
+// together with their rows in the queryable tables
+func deleteBlocks(tx *sql.Tx, op string, height int) error {
+	if err := deleteIndexed(tx, op, height); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM chain_blocks WHERE height "+op+" ?", height)
+	return err
+}
+
+// deleteIndexed removes the rows of the blocks whose height compares to
+// height with op from the queryable tables, children first
+func deleteIndexed(tx *sql.Tx, op string, height int) error {
+	for _, table := range []string{"votes", "receipts", "transactions"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE block_height "+op+" ?", height); err != nil {
+			return err
+		}
+	}
+	_, err := tx.Exec("DELETE FROM headers WHERE height "+op+" ?", height)
+	return err
+}
+
+// nullable stores an empty string as NULL
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func indexBlock(tx *sql.Tx, row *BlockRow) error {
+	if err := deleteIndexed(tx, "=", row.Height); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`
+		INSERT INTO headers (height, hash, previous_hash, version, timestamp, merkle_root, state_root, difficulty, nonce, coinbase, base_fee, extra, tx_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		row.Height, row.Hash, row.PreviousHash, row.Version, row.Timestamp, row.MerkleRoot, row.StateRoot,
+		row.Difficulty, int64(row.Nonce), row.Coinbase, nullable(row.BaseFee), row.Extra, len(row.Transactions))
+	if err != nil {
+		return err
+	}
+
+	for _, t := range row.Transactions {
+		_, err := tx.Exec(`
+			INSERT INTO transactions (block_height, position, hash, type, sender, receiver, amount, token_symbol, data, nonce, max_fee, priority_fee)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			row.Height, t.Position, t.Hash, t.Type, t.Sender, t.Receiver, t.Amount, t.TokenSymbol, t.Data,
+			int64(t.Nonce), nullable(t.MaxFee), nullable(t.PriorityFee))
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO receipts (block_height, position, fee_burned, tip, tip_recipient) VALUES (?, ?, ?, ?, ?)",
+			row.Height, t.Position, t.FeeBurned, t.Tip, row.Author)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, v := range row.Votes {
+		choice := "no"
+		if v.Yes {
+			choice = "yes"
+		}
+		_, err := tx.Exec("INSERT INTO votes (block_height, position, token, proposal_id, voter, choice) VALUES (?, ?, ?, ?, ?, ?)",
+			row.Height, v.Position, v.Token, v.ProposalID, v.Voter, choice)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBalances makes the balances table hold rows, given the balances it
+// holds now, or nil if they are not loaded yet. Only the rows that differ are
+// written. It returns the balances the table holds afterwards.
+func setBalances(tx *sql.Tx, current map[rowKey]string, rows []*BalanceRow) (map[rowKey]string, error) {
+	if current == nil {
+		var err error
+		if current, err = loadBalances(tx); err != nil {
+			return nil, err
+		}
+	}
+	upsert, err := tx.Prepare(`
+		INSERT INTO balances (address, token, balance) VALUES (?, ?, ?)
+		ON CONFLICT (address, token) DO UPDATE SET balance = excluded.balance`)
+	if err != nil {
+		return nil, err
+	}
+	defer upsert.Close()
+
+	next := make(map[rowKey]string, len(rows))
+	for _, row := range rows {
+		key := rowKey{row.Address, row.Token}
+		next[key] = row.Balance
+		if balance, ok := current[key]; ok && balance == row.Balance {
+			continue
+		}
+		if _, err := upsert.Exec(row.Address, row.Token, row.Balance); err != nil {
+			return nil, err
+		}
+	}
+	for key := range current {
+		if _, ok := next[key]; ok {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM balances WHERE address = ? AND token = ?", key.a, key.b); err != nil {
+			return nil, err
+		}
+	}
+	return next, nil
+}
+
+func loadBalances(tx *sql.Tx) (map[rowKey]string, error) {
+	rows, err := tx.Query("SELECT address, token, balance FROM balances")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	balances := make(map[rowKey]string)
+	for rows.Next() {
+		var key rowKey
+		var balance string
+		if err := rows.Scan(&key.a, &key.b, &balance); err != nil {
+			return nil, err
+		}
+		balances[key] = balance
+	}
+	return balances, rows.Err()
+}
+
+// setProposals is setBalances for the proposals table
+func setProposals(tx *sql.Tx, current map[rowKey]ProposalRow, rows []*ProposalRow) (map[rowKey]ProposalRow, error) {
+	if current == nil {
+		var err error
+		if current, err = loadProposals(tx); err != nil {
+			return nil, err
+		}
+	}
+	upsert, err := tx.Prepare(`
+		INSERT INTO proposals (token, id, title, description, status, yes_votes, no_votes) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (token, id) DO UPDATE SET title = excluded.title, description = excluded.description,
+			status = excluded.status, yes_votes = excluded.yes_votes, no_votes = excluded.no_votes`)
+	if err != nil {
+		return nil, err
+	}
+	defer upsert.Close()
+
+	next := make(map[rowKey]ProposalRow, len(rows))
+	for _, row := range rows {
+		key := rowKey{row.Token, row.ID}
+		next[key] = *row
+		if proposal, ok := current[key]; ok && proposal == *row {
+			continue
+		}
+		if _, err := upsert.Exec(row.Token, row.ID, row.Title, row.Description, row.Status, row.YesVotes, row.NoVotes); err != nil {
+			return nil, err
+		}
+	}
+	for key := range current {
+		if _, ok := next[key]; ok {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM proposals WHERE token = ? AND id = ?", key.a, key.b); err != nil {
+			return nil, err
+		}
+	}
+	return next, nil
+}
+
+func loadProposals(tx *sql.Tx) (map[rowKey]ProposalRow, error) {
+	rows, err := tx.Query("SELECT token, id, title, description, status, yes_votes, no_votes FROM proposals")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	proposals := make(map[rowKey]ProposalRow)
+	for rows.Next() {
+		var p ProposalRow
+		if err := rows.Scan(&p.Token, &p.ID, &p.Title, &p.Description, &p.Status, &p.YesVotes, &p.NoVotes); err != nil {
+			return nil, err
+		}
+		proposals[rowKey{p.Token, p.ID}] = p
+	}
+	return proposals, rows.Err()
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
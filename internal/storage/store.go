@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned for blocks, headers and records that are not stored
+var ErrNotFound = errors.New("not found")
+
+// ChainStore persists a chain's blocks and the records derived from them,
+// such as state and indexes. Blocks and headers are encoded by the caller and
+// stored by height and hash, each with the data the caller derived from it.
+// Records are values stored under a name. All changes go through a Batch,
+// which applies them atomically.
+type ChainStore interface {
+	// Height returns the height of the highest stored block, -1 if there is none
+	Height() (int, error)
+
+	BlockByHeight(height int) ([]byte, error)
+	BlockByHash(hash string) ([]byte, error)
+	HeaderByHeight(height int) ([]byte, error)
+	HeaderByHash(hash string) ([]byte, error)
+
+	// DerivedByHeight returns the data stored with the block at height,
+	// ErrNotFound if it was stored without any
+	DerivedByHeight(height int) ([]byte, error)
+
+	// Get returns the record stored under key
+	Get(key string) ([]byte, error)
+
+	NewBatch() Batch
+	Close() error
+}
+
+// Batch collects changes to a ChainStore. Write applies them in the order
+// they were made, either all or none.
+type Batch interface {
+	// PutBlock stores a block at height, replacing any block stored there,
+	// along with the data derived from it, which may be nil. Blocks are put
+	// in order, each one at most one above the highest.
+	PutBlock(height int, hash string, header, block, derived []byte)
+
+	// Truncate removes the blocks above height
+	Truncate(height int)
+
+	Put(key string, value []byte)
+	Delete(key string)
+	Write() error
+}
+
+// Store backends selectable in the config
+const (
+	BackendJSON   = "json"
+	BackendSQLite = "sqlite"
+	BackendMemory = "memory"
+)
+
+// Open opens the store of the named backend at path, a directory for JSON
+// files or a database file for SQLite. The memory backend ignores the path.
+func Open(backend, path string) (ChainStore, error) {
+	switch backend {
+	case "", BackendJSON:
+		return NewFileStore(path)
+	case BackendSQLite:
+		return OpenSQLiteStore(path)
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+type opKind int
+
+const (
+	opPutBlock opKind = iota
+	opTruncate
+	opPut
+	opDelete
+	opIndexBlock   // See IndexBatch
+	opSetBalances  // See IndexBatch
+	opSetProposals // See IndexBatch
+)
+
+type op struct {
+	kind    opKind
+	height  int
+	hash    string // Block hash or record key
+	header  []byte
+	value   []byte      // Block or record value
+	derived []byte      // Of a block
+	rows    interface{} // Rows of an IndexBatch change
+}
+
+// batch records the changes of a Batch for a store to apply on Write
+type batch struct {
+	ops   []*op
+	write func(ops []*op) error
+}
+
+func (b *batch) PutBlock(height int, hash string, header, block, derived []byte) {
+	b.ops = append(b.ops, &op{kind: opPutBlock, height: height, hash: hash, header: header, value: block, derived: derived})
+}
+
+func (b *batch) Truncate(height int) {
+	b.ops = append(b.ops, &op{kind: opTruncate, height: height})
+}
+
+func (b *batch) Put(key string, value []byte) {
+	b.ops = append(b.ops, &op{kind: opPut, hash: key, value: value})
+}
+
+func (b *batch) Delete(key string) {
+	b.ops = append(b.ops, &op{kind: opDelete, hash: key})
+}
+
+func (b *batch) Write() error {
+	if err := b.write(b.ops); err != nil {
+		return err
+	}
+	b.ops = nil
+	return nil
+}
+
+// blockIndex keeps blocks and headers in memory by height and hash. It backs
+// the memory store and holds the chain files of earlier versions while the
+// file store converts them.
+type blockIndex struct {
+	blocks  [][]byte
+	headers [][]byte
+	derived [][]byte
+	hashes  []string
+	byHash  map[string]int
+}
+
+func newBlockIndex() *blockIndex {
+	return &blockIndex{byHash: make(map[string]int)}
+}
+
+// put stores a block at height, which is at most one above the highest
+func (idx *blockIndex) put(height int, hash string, header, block, derived []byte) error {
+	if height < 0 || height > len(idx.blocks) {
+		return fmt.Errorf("cannot store block %d above height %d", height, len(idx.blocks)-1)
+	}
+	if height == len(idx.blocks) {
+		idx.blocks = append(idx.blocks, nil)
+		idx.headers = append(idx.headers, nil)
+		idx.derived = append(idx.derived, nil)
+		idx.hashes = append(idx.hashes, "")
+	} else {
+		delete(idx.byHash, idx.hashes[height])
+	}
+	idx.blocks[height] = block
+	idx.headers[height] = header
+	idx.derived[height] = derived
+	idx.hashes[height] = hash
+	if hash != "" {
+		idx.byHash[hash] = height
+	}
+	return nil
+}
+
+// truncate removes the blocks above height
+func (idx *blockIndex) truncate(height int) {
+	if height < -1 {
+		height = -1
+	}
+	for i := height + 1; i < len(idx.blocks); i++ {
+		delete(idx.byHash, idx.hashes[i])
+	}
+	if height+1 < len(idx.blocks) {
+		idx.blocks = idx.blocks[:height+1]
+		idx.headers = idx.headers[:height+1]
+		idx.derived = idx.derived[:height+1]
+		idx.hashes = idx.hashes[:height+1]
+	}
+}
+
+func (idx *blockIndex) blockByHeight(height int) ([]byte, error) {
+	if height < 0 || height >= len(idx.blocks) {
+		return nil, ErrNotFound
+	}
+	return idx.blocks[height], nil
+}
+
+func (idx *blockIndex) headerByHeight(height int) ([]byte, error) {
+	if height < 0 || height >= len(idx.headers) || idx.headers[height] == nil {
+		return nil, ErrNotFound
+	}
+	return idx.headers[height], nil
+}
+
+func (idx *blockIndex) derivedByHeight(height int) ([]byte, error) {
+	if height < 0 || height >= len(idx.derived) || idx.derived[height] == nil {
+		return nil, ErrNotFound
+	}
+	return idx.derived[height], nil
+}
+
+func (idx *blockIndex) heightOf(hash string) (int, error) {
+	height, ok := idx.byHash[hash]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return height, nil
+}
+
+// copy returns an index sharing the stored values, so changes to the copy
+// leave idx unchanged
+func (idx *blockIndex) copy() *blockIndex {
+	cp := &blockIndex{
+		blocks:  append([][]byte(nil), idx.blocks...),
+		headers: append([][]byte(nil), idx.headers...),
+		derived: append([][]byte(nil), idx.derived...),
+		hashes:  append([]string(nil), idx.hashes...),
+		byHash:  make(map[string]int, len(idx.byHash)),
+	}
+	for hash, height := range idx.byHash {
+		cp.byHash[hash] = height
+	}
+	return cp
+}
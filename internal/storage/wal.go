@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Files the file store keeps next to the chain files and records
+const (
+	checksumFile = "checksums.json" // Checksums of files written by earlier versions
+	walFile      = "batch.wal"      // Write-ahead log of the batch being written
+	tmpSuffix    = ".tmp"           // New content of a file until its batch is applied
+)
+
+// Every file the store writes ends with a line holding the checksum of the
+// content before it, so writing a file never touches any other
+const (
+	sealPrefix = "\nsha256:"
+	sealSize   = len(sealPrefix) + sha256.Size*2 + 1
+)
+
+// fileChange is a file a batch writes, or removes if data is nil
+type fileChange struct {
+	name string
+	data []byte
+}
+
+// walEntry is a file change recorded in the write-ahead log
+type walEntry struct {
+	Name     string `json:"name"`
+	Checksum string `json:"checksum,omitempty"` // Empty if the file is removed
+}
+
+// walLog is the content of the write-ahead log
+type walLog struct {
+	Entries  json.RawMessage `json:"entries"`
+	Checksum string          `json:"checksum"` // Of Entries
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// seal appends the checksum line to the content of a file
+func seal(data []byte) []byte {
+	sealed := make([]byte, 0, len(data)+sealSize)
+	sealed = append(sealed, data...)
+	sealed = append(sealed, sealPrefix...)
+	sealed = append(sealed, checksum(data)...)
+	return append(sealed, '\n')
+}
+
+// unseal splits a file into its content and checksum line, reporting false
+// if it has none
+func unseal(data []byte) ([]byte, string, bool) {
+	if len(data) < sealSize || data[len(data)-1] != '\n' {
+		return nil, "", false
+	}
+	trailer := data[len(data)-sealSize:]
+	if !bytes.HasPrefix(trailer, []byte(sealPrefix)) {
+		return nil, "", false
+	}
+	return data[:len(data)-sealSize], string(trailer[len(sealPrefix) : sealSize-1]), true
+}
+
+func (s *FileStore) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// commit writes the files of a batch so that after a crash either all or none
+// of them are changed. Each file is first written and synced next to its
+// target. The batch is committed once the write-ahead log listing them is in
+// place; the files are then renamed over their targets and the log removed.
+// A crash before the log is in place leaves only temporary files, which
+// recover discards, and a crash after it is replayed by recover.
+func (s *FileStore) commit(changes []*fileChange) error {
+	// Files written by earlier versions leave the manifest as they are replaced
+	checksums := s.checksums
+	for _, c := range changes {
+		if _, ok := checksums[c.name]; !ok {
+			continue
+		}
+		if len(checksums) == len(s.checksums) {
+			checksums = make(map[string]string, len(s.checksums))
+			for name, sum := range s.checksums {
+				checksums[name] = sum
+			}
+		}
+		delete(checksums, c.name)
+	}
+	if len(checksums) < len(s.checksums) {
+		manifest := &fileChange{name: checksumFile}
+		if len(checksums) > 0 {
+			data, err := json.Marshal(checksums)
+			if err != nil {
+				return fmt.Errorf("failed to marshal checksums: %v", err)
+			}
+			manifest.data = data
+		}
+		changes = append(changes, manifest)
+	}
+
+	entries := make([]*walEntry, 0, len(changes))
+	for _, c := range changes {
+		entry := &walEntry{Name: c.name}
+		if c.data != nil {
+			data := c.data
+			if c.name != checksumFile {
+				data = seal(c.data)
+			}
+			if err := writeFileSync(s.path(c.name+tmpSuffix), data); err != nil {
+				return err
+			}
+			entry.Checksum = checksum(data)
+		}
+		entries = append(entries, entry)
+	}
+	if err := s.writeLog(entries); err != nil {
+		return err
+	}
+	if err := s.replay(entries); err != nil {
+		return err
+	}
+	s.checksums = checksums
+	return s.removeLog()
+}
+
+// writeFileSync writes a file and syncs it to disk
+func writeFileSync(filename string, data []byte) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %v", filename, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write to file %s: %v", filename, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync file %s: %v", filename, err)
+	}
+	return f.Close()
+}
+
+// syncDir syncs the directory so renames and removals in it are on disk
+func (s *FileStore) syncDir() error {
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to open block directory: %v", err)
+	}
+	defer dir.Close()
+	if err := dir.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block directory: %v", err)
+	}
+	return nil
+}
+
+// writeLog atomically puts the write-ahead log in place, which commits its batch
+func (s *FileStore) writeLog(entries []*walEntry) error {
+	raw, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal write-ahead log: %v", err)
+	}
+	data, err := json.Marshal(&walLog{Entries: raw, Checksum: checksum(raw)})
+	if err != nil {
+		return fmt.Errorf("failed to marshal write-ahead log: %v", err)
+	}
+	if err := writeFileSync(s.path(walFile+tmpSuffix), data); err != nil {
+		return err
+	}
+	if err := os.Rename(s.path(walFile+tmpSuffix), s.path(walFile)); err != nil {
+		return fmt.Errorf("failed to commit write-ahead log: %v", err)
+	}
+	return s.syncDir()
+}
+
+// readLog reads the write-ahead log, failing if its checksum doesn't match
+func (s *FileStore) readLog() ([]*walEntry, error) {
+	data, err := os.ReadFile(s.path(walFile))
+	if err != nil {
+		return nil, err
+	}
+	var log walLog
+	if err := json.Unmarshal(data, &log); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal write-ahead log: %v", err)
+	}
+	if checksum(log.Entries) != log.Checksum {
+		return nil, fmt.Errorf("write-ahead log checksum mismatch")
+	}
+	var entries []*walEntry
+	if err := json.Unmarshal(log.Entries, &entries); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal write-ahead log: %v", err)
+	}
+	return entries, nil
+}
+
+func (s *FileStore) removeLog() error {
+	if err := os.Remove(s.path(walFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove write-ahead log: %v", err)
+	}
+	return s.syncDir()
+}
+
+// replay moves the files of a committed batch over their targets and removes
+// the files it deletes. Files already moved by an earlier replay are skipped.
+func (s *FileStore) replay(entries []*walEntry) error {
+	for _, entry := range entries {
+		target := s.path(entry.Name)
+		if entry.Checksum == "" {
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove file %s: %v", target, err)
+			}
+			continue
+		}
+		if err := os.Rename(target+tmpSuffix, target); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to replace file %s: %v", target, err)
+		}
+	}
+	return s.syncDir()
+}
+
+// hasChecksum reports whether a file exists with the given checksum
+func (s *FileStore) hasChecksum(name, sum string) bool {
+	data, err := os.ReadFile(s.path(name))
+	return err == nil && checksum(data) == sum
+}
+
+// recover finishes the batch of a write-ahead log left by a crash and
+// discards the temporary files of a batch that was not committed
+func (s *FileStore) recover() error {
+	entries, err := s.readLog()
+	switch {
+	case err == nil:
+		for _, entry := range entries {
+			if entry.Checksum != "" && !s.hasChecksum(entry.Name+tmpSuffix, entry.Checksum) && !s.hasChecksum(entry.Name, entry.Checksum) {
+				return fmt.Errorf("cannot recover interrupted write: %s is missing or corrupted", entry.Name)
+			}
+		}
+		if err := s.replay(entries); err != nil {
+			return err
+		}
+		if err := s.removeLog(); err != nil {
+			return err
+		}
+		fmt.Printf("Recovered an interrupted write of %d files.\n", len(entries))
+	case os.IsNotExist(err):
+	default:
+		// The log is put in place by a rename, so it is only torn by corruption
+		fmt.Printf("Discarding unreadable write-ahead log: %v\n", err)
+		if err := s.removeLog(); err != nil {
+			return err
+		}
+	}
+
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read block directory: %v", err)
+	}
+	discarded := 0
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), tmpSuffix) {
+			if err := os.Remove(s.path(file.Name())); err != nil {
+				return fmt.Errorf("failed to remove file %s: %v", file.Name(), err)
+			}
+			discarded++
+		}
+	}
+	if discarded > 0 {
+		fmt.Printf("Discarded %d files of an interrupted write.\n", discarded)
+		return s.syncDir()
+	}
+	return nil
+}
+
+// loadChecksums reads the checksums of the files written by earlier versions
+// that kept them in a manifest. Files written before that are not verified.
+func (s *FileStore) loadChecksums() error {
+	s.checksums = make(map[string]string)
+	data, err := os.ReadFile(s.path(checksumFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read checksums: %v", err)
+	}
+	if err := json.Unmarshal(data, &s.checksums); err != nil {
+		return fmt.Errorf("failed to unmarshal checksums: %v", err)
+	}
+	return nil
+}
+
+// verify checks a stored file against its checksum and returns its content
+func (s *FileStore) verify(name string, data []byte) ([]byte, error) {
+	if sum, ok := s.checksums[name]; ok {
+		if checksum(data) != sum {
+			return nil, fmt.Errorf("file %s is corrupted: checksum mismatch", s.path(name))
+		}
+		return data, nil
+	}
+	content, sum, ok := unseal(data)
+	if !ok {
+		return data, nil
+	}
+	if checksum(content) != sum {
+		return nil, fmt.Errorf("file %s is corrupted: checksum mismatch", s.path(name))
+	}
+	return content, nil
+}
@@ -2,12 +2,9 @@ package wallet
 
 import (
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/hex"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -22,9 +19,9 @@ import (
 
 // Wallet represents a cryptocurrency wallet with associated data.
 type Wallet struct {
-	Mnemonic   string
-	PrivateKey *ecdsa.PrivateKey
-	PublicKey  *ecdsa.PublicKey
+	Mnemonic   string            `json:"-"` // Like the key, kept in the wallets/ directory, never in chain files
+	PrivateKey *ecdsa.PrivateKey `json:"-"`
+	PublicKey  *ecdsa.PublicKey  `json:"-"`
 	Address    string
 	Balances   map[string]*big.Int             // Balances for tokens (e.g., "TPY": 0)
 	Tokens     map[string]*common.UtilityToken // Tokens held by the wallet
@@ -112,17 +109,10 @@ func LoadWordlist() ([]string, error) {
 	return words, nil
 }
 
+// NewWallet creates a wallet with a new mnemonic and saves it to the wallets/
+// directory. Its key is derived from the mnemonic like in RecoverWallet, so
+// the mnemonic restores the wallet.
 func NewWallet() (*Wallet, error) {
-	// Generate a private key
-	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate private key: %v", err)
-	}
-
-	// Derive public key and address
-	publicKey := &privateKey.PublicKey
-	address := crypto.PubkeyToAddress(*publicKey).Hex()
-
 	// Generate a mnemonic
 	entropy, err := bip39.NewEntropy(128)
 	if err != nil {
@@ -133,20 +123,18 @@ func NewWallet() (*Wallet, error) {
 		return nil, fmt.Errorf("failed to generate mnemonic: %v", err)
 	}
 
+	// Derive the key and address
+	w, err := RecoverWallet(mnemonic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %v", err)
+	}
+
 	// Save the wallet data
-	err = saveWalletToFile(privateKey, mnemonic, address)
+	err = saveWalletToFile(w.PrivateKey, mnemonic, w.Address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to save wallet: %v", err)
 	}
-
-	// Return the wallet
-	return &Wallet{
-		Mnemonic:   mnemonic,
-		PrivateKey: privateKey,
-		PublicKey:  publicKey,
-		Address:    address,
-		Balances:   make(map[string]*big.Int),
-	}, nil
+	return w, nil
 }
 
 // saveWalletToFile saves the private key and mnemonic to a single file in the .wallets/ directory.
@@ -158,24 +146,14 @@ func saveWalletToFile(privateKey *ecdsa.PrivateKey, mnemonic, address string) er
 		return fmt.Errorf("failed to create wallets directory: %v", err)
 	}
 
-	// Convert the private key to PEM format
-	privateKeyBytes, err := x509.MarshalECPrivateKey(privateKey)
-	if err != nil {
-		return fmt.Errorf("failed to marshal private key: %v", err)
-	}
-
-	pemBlock := &pem.Block{
-		Type:  "EC PRIVATE KEY",
-		Bytes: privateKeyBytes,
-	}
-	privateKeyPem := pem.EncodeToMemory(pemBlock)
-
-	// Prepare the file content, including the address
+	// Prepare the file content, including the address. secp256k1 keys have
+	// no PEM encoding, so the key is stored as hex.
 	fileContent := fmt.Sprintf(
-		"Address:\n%s\n\nMnemonic:\n%s\n\nPrivate Key (PEM):\n%s",
+		"Address:\n%s\n\nMnemonic:\n%s\n\n%s\n%s\n",
 		address,
 		mnemonic,
-		string(privateKeyPem),
+		walletKeyHeading,
+		hex.EncodeToString(crypto.FromECDSA(privateKey)),
 	)
 
 	// Save the wallet to a file
@@ -188,26 +166,34 @@ func saveWalletToFile(privateKey *ecdsa.PrivateKey, mnemonic, address string) er
 	return nil
 }
 
-// LoadPrivateKeyFromFile loads a private key from a local PEM file.
+// walletKeyHeading introduces the hex encoded secp256k1 key in a wallet file
+const walletKeyHeading = "Private Key (hex):"
+
+// LoadPrivateKeyFromFile loads the private key from a wallet file written by
+// NewWallet. Files of earlier versions hold a P-256 key in PEM format, which
+// cannot sign transactions, so they are refused.
 func LoadPrivateKeyFromFile(filename string) (*ecdsa.PrivateKey, error) {
-	// Read the PEM file
-	pemData, err := ioutil.ReadFile(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read private key file: %v", err)
+		return nil, fmt.Errorf("failed to read wallet file: %v", err)
 	}
-
-	// Decode the PEM block
-	block, _ := pem.Decode(pemData)
-	if block == nil || block.Type != "EC PRIVATE KEY" {
-		return nil, errors.New("invalid private key PEM file")
+	content := string(data)
+
+	i := strings.Index(content, walletKeyHeading)
+	if i < 0 {
+		if strings.Contains(content, "EC PRIVATE KEY") {
+			return nil, errors.New("wallet file holds a P-256 key of an earlier version, which cannot sign transactions")
+		}
+		return nil, errors.New("wallet file holds no private key")
 	}
-
-	// Parse the private key
-	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+	fields := strings.Fields(content[i+len(walletKeyHeading):])
+	if len(fields) == 0 {
+		return nil, errors.New("wallet file holds no private key")
+	}
+	privateKey, err := crypto.HexToECDSA(fields[0])
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key: %v", err)
 	}
-
 	return privateKey, nil
 }
 
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"tpy-blockchain/internal/blockchain"
+	"tpy-blockchain/internal/consensus"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler for listing the proof-of-authority signers and pending votes
+func getSignersHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		snap, err := chain.CliqueSnapshot()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"index":   snap.Index,
+			"signers": snap.SignerList(),
+			"votes":   snap.Votes,
+			"tally":   snap.Tally,
+		})
+	}
+}
+
+// Handler for listing the votes this node casts in the blocks it seals
+func getProposalsHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clique, ok := chain.Engine().(*consensus.Clique)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "chain does not use proof-of-authority"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"proposals": clique.Proposals()})
+	}
+}
+
+// Handler for voting a signer in or out, or discarding a vote
+func proposeSignerHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clique, ok := chain.Engine().(*consensus.Clique)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "chain does not use proof-of-authority"})
+			return
+		}
+
+		var req struct {
+			Address   string `json:"address"`
+			Authorize bool   `json:"authorize"`
+			Discard   bool   `json:"discard"`
+		}
+		if err := c.BindJSON(&req); err != nil || req.Address == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Address is required"})
+			return
+		}
+
+		if req.Discard {
+			clique.Discard(req.Address)
+		} else {
+			clique.Propose(req.Address, req.Authorize)
+		}
+		c.JSON(http.StatusOK, gin.H{"proposals": clique.Proposals()})
+	}
+}
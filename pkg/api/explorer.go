@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"tpy-blockchain/internal/blockchain"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parsePagination reads the page and limit query parameters and returns the
+// page, limit and offset. Pages start at 1.
+func parsePagination(c *gin.Context) (int, int, int, bool) {
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'page' parameter"})
+		return 0, 0, 0, false
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageLimit)))
+	if err != nil || limit < 1 || limit > maxPageLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'limit' parameter"})
+		return 0, 0, 0, false
+	}
+	return page, limit, (page - 1) * limit, true
+}
+
+// Handler for looking up a confirmed transaction by hash
+func getTransactionHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tx, location, err := chain.GetTransaction(c.Param("hash"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"transaction": tx,
+			"blockIndex":  location.BlockIndex,
+			"blockHash":   location.BlockHash,
+			"position":    location.Position,
+		})
+	}
+}
+
+// Handler for listing the transactions that touched an address, newest first
+func getAddressTransactionsHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset, ok := parsePagination(c)
+		if !ok {
+			return
+		}
+
+		transactions, total := chain.GetAddressTransactions(c.Param("address"), offset, limit)
+		c.JSON(http.StatusOK, gin.H{
+			"address":      c.Param("address"),
+			"page":         page,
+			"limit":        limit,
+			"total":        total,
+			"transactions": transactions,
+		})
+	}
+}
+
+// Handler for listing the holders of a token
+func getTokenHoldersHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, limit, offset, ok := parsePagination(c)
+		if !ok {
+			return
+		}
+
+		holders, total, err := chain.GetTokenHolders(c.Param("symbol"), offset, limit)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"token":   c.Param("symbol"),
+			"page":    page,
+			"limit":   limit,
+			"total":   total,
+			"holders": holders,
+		})
+	}
+}
+
+// Handler for looking up what a confirmed transaction paid in fees
+func getReceiptHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		receipt, err := chain.GetReceipt(c.Param("hash"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, receipt)
+	}
+}
+
+// Handler for listing the governance proposals of a token with their votes
+func getTokenProposalsHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		proposals, err := chain.GetTokenProposals(c.Param("symbol"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"token":     c.Param("symbol"),
+			"proposals": proposals,
+		})
+	}
+}
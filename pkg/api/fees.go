@@ -0,0 +1,15 @@
+package api
+
+import (
+	"net/http"
+	"tpy-blockchain/internal/blockchain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler for suggesting the fees of a transaction in the next block
+func getFeesHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, chain.EstimateFees())
+	}
+}
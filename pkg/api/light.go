@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"tpy-blockchain/internal/blockchain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxHeadersPerRequest caps the size of a headers range response
+const maxHeadersPerRequest = 2000
+
+// Handler for serving a range of block headers to light clients
+func getHeadersHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, err := strconv.Atoi(c.DefaultQuery("from", "0"))
+		if err != nil || from < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'from' parameter"})
+			return
+		}
+		to, err := strconv.Atoi(c.DefaultQuery("to", strconv.Itoa(from+maxHeadersPerRequest-1)))
+		if err != nil || to < from {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'to' parameter"})
+			return
+		}
+		if to-from >= maxHeadersPerRequest {
+			to = from + maxHeadersPerRequest - 1
+		}
+
+		headers, err := chain.GetHeaders(from, to)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"headers": headers})
+	}
+}
+
+// Handler for serving a transaction inclusion proof
+func getTxProofHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		proof, err := chain.GetTxProof(c.Param("hash"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, proof)
+	}
+}
+
+// Handler for serving a balance proof against the latest state root
+func getBalanceProofHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		address := c.Query("address")
+		if address == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Address is required"})
+			return
+		}
+
+		proof, err := chain.GetBalanceProof(address, c.Query("token"))
+		if err == blockchain.ErrStateMismatch {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, proof)
+	}
+}
+
+// Handler for serving the compact filter of a block
+func getFilterHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		index, err := strconv.Atoi(c.Param("index"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid block index"})
+			return
+		}
+
+		filter, err := chain.GetFilter(index)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, filter)
+	}
+}
+
+// Handler for serving a range of filter headers
+func getFilterHeadersHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, err := strconv.Atoi(c.DefaultQuery("from", "0"))
+		if err != nil || from < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'from' parameter"})
+			return
+		}
+		to, err := strconv.Atoi(c.DefaultQuery("to", strconv.Itoa(from+maxHeadersPerRequest-1)))
+		if err != nil || to < from {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'to' parameter"})
+			return
+		}
+		if to-from >= maxHeadersPerRequest {
+			to = from + maxHeadersPerRequest - 1
+		}
+
+		headers, err := chain.GetFilterHeaders(from, to)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"from": from, "headers": headers})
+	}
+}
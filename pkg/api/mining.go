@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"tpy-blockchain/internal/blockchain"
+	"tpy-blockchain/internal/consensus"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler for the proof-of-work miner's threads and hash rate
+func getMiningHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pow, ok := chain.Engine().(*consensus.ProofOfWork)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "chain does not use proof-of-work"})
+			return
+		}
+		stats := pow.Miner.Stats()
+		c.JSON(http.StatusOK, gin.H{
+			"difficulty": pow.Difficulty,
+			"threads":    stats.Threads,
+			"running":    stats.Running,
+			"hashes":     stats.Hashes,
+			"hashRate":   stats.HashRate,
+		})
+	}
+}
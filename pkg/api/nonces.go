@@ -0,0 +1,164 @@
+package api
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"tpy-blockchain/internal/blockchain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseAmount parses an optional decimal amount, nil if it is empty
+func parseAmount(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	amount, ok := new(big.Int).SetString(s, 10)
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("invalid amount %q", s)
+	}
+	return amount, nil
+}
+
+// signedFields are the fields a client sets on a transaction before signing
+// its hash, see blockchain.Transaction.Sign. Only transactions signed by
+// their sender are accepted, so the node cannot fill them in.
+type signedFields struct {
+	Nonce       uint64 `json:"nonce"`
+	MaxFee      string `json:"max_fee"`      // Empty without the fee market
+	PriorityFee string `json:"priority_fee"` // Empty without the fee market
+	Version     int    `json:"version"`      // Transaction version, see blockchain.Transaction.SetVersion
+	Signature   string `json:"signature"`
+}
+
+// apply sets the fields on tx, which updates its hash
+func (f *signedFields) apply(tx *blockchain.Transaction) error {
+	if f.Nonce == 0 || f.Signature == "" {
+		return fmt.Errorf("a nonce and the sender's signature are required")
+	}
+	maxFee, err := parseAmount(f.MaxFee)
+	if err != nil {
+		return err
+	}
+	priorityFee, err := parseAmount(f.PriorityFee)
+	if err != nil {
+		return err
+	}
+	if f.Version != blockchain.TxVersionLegacy {
+		tx.SetVersion(f.Version)
+	}
+	tx.SetNonce(f.Nonce)
+	if maxFee != nil || priorityFee != nil {
+		tx.SetFees(maxFee, priorityFee)
+	}
+	tx.Signature = f.Signature
+	return nil
+}
+
+// Handler for submitting a signed transfer, replacing the sender's pending
+// transaction with the same nonce if it offers high enough fees
+func submitTransactionHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Sender      string `json:"sender"`
+			Receiver    string `json:"receiver"`
+			Amount      string `json:"amount"`
+			TokenSymbol string `json:"token_symbol"`
+			signedFields
+		}
+		if err := c.BindJSON(&req); err != nil || req.Sender == "" || req.Receiver == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if req.TokenSymbol == "" {
+			req.TokenSymbol = "TPY"
+		}
+		amount, err := parseAmount(req.Amount)
+		if err == nil && amount == nil {
+			err = fmt.Errorf("amount is required")
+		}
+		transaction := blockchain.NewUnsignedTransaction(blockchain.TxTypeTransfer, req.Sender, req.Receiver, amount, req.TokenSymbol, "")
+		if err == nil {
+			err = req.apply(transaction)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		replaced, err := chain.SubmitTransaction(transaction)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to add transaction: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Transaction added to the pending pool",
+			"transaction": transaction,
+			"replaced":    replaced,
+		})
+	}
+}
+
+// Handler for the unsigned cancellation of a pending transaction, with the
+// fees it needs, for the sender to sign
+func getCancellationHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nonce, err := strconv.ParseUint(c.Query("nonce"), 10, 64)
+		if err != nil || c.Query("address") == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "An address and a nonce are required"})
+			return
+		}
+
+		cancel, err := chain.NewCancellation(c.Query("address"), nonce)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"transaction": cancel})
+	}
+}
+
+// Handler for cancelling a pending transaction by replacing it with a signed
+// transfer of nothing to the sender
+func cancelTransactionHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Address string `json:"address"`
+			signedFields
+		}
+		if err := c.BindJSON(&req); err != nil || req.Address == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "An address is required"})
+			return
+		}
+		cancel := blockchain.NewCancelTransaction(req.Address, 0)
+		if err := req.apply(cancel); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		replaced, err := chain.CancelTransaction(cancel)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to cancel transaction: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Transaction cancelled",
+			"transaction": cancel,
+			"replaced":    replaced,
+		})
+	}
+}
+
+// Handler for the nonce of an address's next transaction
+func getNonceHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		address := c.Param("address")
+		c.JSON(http.StatusOK, gin.H{
+			"address": address,
+			"nonce":   chain.NextNonce(address),
+			"version": chain.TxVersion(),
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"tpy-blockchain/internal/blockchain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler for submitting a block from another node. Blocks whose parent is
+// unknown are kept as orphans and the response names the block to send next.
+// Blocks of a lighter branch are kept in case it outgrows the chain.
+func submitBlockHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var block blockchain.Block
+		if err := c.BindJSON(&block); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid block: %v", err)})
+			return
+		}
+
+		result, err := chain.ProcessBlock(&block)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if result.Orphan {
+			c.JSON(http.StatusAccepted, gin.H{"orphan": true, "missing": result.Missing})
+			return
+		}
+		if result.Side {
+			c.JSON(http.StatusAccepted, gin.H{"side": true})
+			return
+		}
+		if err := chain.Save(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to save blockchain: %v", err)})
+			return
+		}
+
+		connected := make([]string, 0, len(result.Connected))
+		for _, b := range result.Connected {
+			connected = append(connected, b.Hash)
+		}
+		disconnected := make([]string, 0, len(result.Disconnected))
+		for _, b := range result.Disconnected {
+			disconnected = append(disconnected, b.Hash)
+		}
+		c.JSON(http.StatusOK, gin.H{"connected": connected, "disconnected": disconnected, "height": chain.Height()})
+	}
+}
+
+// Handler for listing the orphan blocks and the blocks they wait for
+func getOrphansHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"orphans": chain.Orphans(),
+			"missing": chain.MissingBlocks(),
+		})
+	}
+}
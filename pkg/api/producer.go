@@ -0,0 +1,64 @@
+package api
+
+import (
+	"net/http"
+	"tpy-blockchain/internal/producer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterProducerRoutes sets up the routes controlling the node's block producer
+func RegisterProducerRoutes(router *gin.Engine, p *producer.Producer) {
+	router.GET("/producer", getProducerHandler(p))
+	router.POST("/producer/start", startProducerHandler(p))
+	router.POST("/producer/stop", stopProducerHandler(p))
+	router.POST("/producer/coinbase", setCoinbaseHandler(p))
+}
+
+// Handler for the block producer's status
+func getProducerHandler(p *producer.Producer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"running":  p.Running(),
+			"coinbase": p.Coinbase(),
+			"produced": p.Produced(),
+		})
+	}
+}
+
+// Handler for starting block production
+func startProducerHandler(p *producer.Producer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := p.Start(); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"running": true})
+	}
+}
+
+// Handler for stopping block production
+func stopProducerHandler(p *producer.Producer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := p.Stop(); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"running": false})
+	}
+}
+
+// Handler for setting the address that receives block rewards
+func setCoinbaseHandler(p *producer.Producer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Address string `json:"address"`
+		}
+		if err := c.BindJSON(&req); err != nil || req.Address == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Address is required"})
+			return
+		}
+		p.SetCoinbase(req.Address)
+		c.JSON(http.StatusOK, gin.H{"coinbase": p.Coinbase()})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math/big"
 	"net/http"
 	"tpy-blockchain/internal/blockchain"
+	"tpy-blockchain/internal/producer"
 	"tpy-blockchain/internal/wallet"
 
 	"github.com/ethereum/go-ethereum/crypto"
@@ -15,10 +16,46 @@ import (
 // RegisterRoutes setups all the routes for the API server
 func RegisterRoutes(router *gin.Engine, chain *blockchain.Blockchain) {
 	router.GET("/blocks", getBlocksHandler(chain))
+	router.POST("/blocks", submitBlockHandler(chain))
+	router.GET("/orphans", getOrphansHandler(chain))
 	router.POST("/wallets/new", createWalletHandler())
 	router.POST("/wallets/import", importWalletHandler())
 	router.GET("/wallets/balance", getWalletBalanceHandler(chain))
     router.POST("/wallets/transaction", createWalletTransactionHandler(chain))
+	router.GET("/headers", getHeadersHandler(chain))
+	router.GET("/proofs/tx/:hash", getTxProofHandler(chain))
+	router.GET("/proofs/balance", getBalanceProofHandler(chain))
+	router.GET("/filters/:index", getFilterHandler(chain))
+	router.GET("/filter-headers", getFilterHeadersHandler(chain))
+	router.POST("/transactions", submitTransactionHandler(chain))
+	router.GET("/transactions/cancel", getCancellationHandler(chain))
+	router.POST("/transactions/cancel", cancelTransactionHandler(chain))
+	router.GET("/transactions/:hash", getTransactionHandler(chain))
+	router.GET("/addresses/:address/nonce", getNonceHandler(chain))
+	router.GET("/addresses/:address/transactions", getAddressTransactionsHandler(chain))
+	router.GET("/tokens/:symbol/holders", getTokenHoldersHandler(chain))
+	router.GET("/tokens/:symbol/proposals", getTokenProposalsHandler(chain))
+	router.GET("/receipts/:hash", getReceiptHandler(chain))
+	router.GET("/upgrades", getUpgradesHandler(chain))
+	router.GET("/fees", getFeesHandler(chain))
+	router.GET("/mining", getMiningHandler(chain))
+	work := producer.NewWorkManager(chain)
+	router.GET("/work", getWorkHandler(work))
+	router.POST("/work", submitWorkHandler(work))
+	router.GET("/clique/signers", getSignersHandler(chain))
+	router.GET("/clique/proposals", getProposalsHandler(chain))
+	router.POST("/clique/proposals", proposeSignerHandler(chain))
+	router.GET("/validators", getValidatorsHandler(chain))
+	router.GET("/validators/:address", getValidatorHandler(chain))
+	router.GET("/delegators/:address", getDelegatorHandler(chain))
+	router.POST("/staking/stake", stakingTransactionHandler(chain, blockchain.TxTypeStake))
+	router.POST("/staking/unstake", stakingTransactionHandler(chain, blockchain.TxTypeUnstake))
+	router.POST("/staking/delegate", stakingTransactionHandler(chain, blockchain.TxTypeDelegate))
+	router.POST("/staking/undelegate", stakingTransactionHandler(chain, blockchain.TxTypeUndelegate))
+	router.POST("/staking/redelegate", stakingTransactionHandler(chain, blockchain.TxTypeRedelegate))
+	router.POST("/staking/commission", stakingTransactionHandler(chain, blockchain.TxTypeSetCommission))
+	router.POST("/staking/claim", stakingTransactionHandler(chain, blockchain.TxTypeClaimRewards))
+	router.POST("/staking/evidence", submitEvidenceHandler(chain))
 
 }
 
@@ -2,12 +2,17 @@ package api
 
 import (
     "tpy-blockchain/internal/blockchain"
+    "tpy-blockchain/internal/producer"
     "github.com/gin-gonic/gin"
 )
 
 // StartServer initializes and starts the Gin server on the specified port.
-func StartServer(port string, chain *blockchain.Blockchain) {
+// The block producer routes are only registered if p is not nil.
+func StartServer(port string, chain *blockchain.Blockchain, p *producer.Producer) {
     router := gin.Default()
     RegisterRoutes(router, chain)
+    if p != nil {
+        RegisterProducerRoutes(router, p)
+    }
     router.Run(":" + port) // Starts the HTTP server
 }
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"tpy-blockchain/internal/blockchain"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler for listing the proof-of-stake validators of the next block
+func getValidatorsHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		validators, err := chain.Validators()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"validators": validators})
+	}
+}
+
+// Handler for showing the stake, delegations and accrued rewards of an address
+func getDelegatorHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info, err := chain.GetDelegator(c.Param("address"), c.DefaultQuery("token", "TPY"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, info)
+	}
+}
+
+// Handler for showing a validator's stake, commission and reward pool
+func getValidatorHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info, err := chain.GetValidator(c.Param("address"), c.DefaultQuery("token", "TPY"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, info)
+	}
+}
+
+// Handler for submitting signed staking, delegation, commission and reward
+// claim transactions
+func stakingTransactionHandler(chain *blockchain.Blockchain, txType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Address         string `json:"address"`
+			Validator       string `json:"validator"`        // Target of delegate, undelegate and redelegate
+			SourceValidator string `json:"source_validator"` // Validator a redelegation moves stake away from
+			Amount          string `json:"amount"`
+			Commission      int    `json:"commission"` // Commission rate in basis points
+			TokenSymbol     string `json:"token_symbol"`
+			signedFields
+		}
+		if err := c.BindJSON(&req); err != nil || req.Address == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+			return
+		}
+		if req.TokenSymbol == "" {
+			req.TokenSymbol = "TPY"
+		}
+
+		amount := big.NewInt(0)
+		if req.Amount != "" {
+			var ok bool
+			if amount, ok = new(big.Int).SetString(req.Amount, 10); !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid amount"})
+				return
+			}
+		}
+		data := ""
+		switch txType {
+		case blockchain.TxTypeRedelegate:
+			data = req.SourceValidator
+		case blockchain.TxTypeSetCommission:
+			data = strconv.Itoa(req.Commission)
+		}
+
+		transaction := blockchain.NewUnsignedTransaction(txType, req.Address, req.Validator, amount, req.TokenSymbol, data)
+		if err := req.apply(transaction); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := chain.AddTransaction(transaction); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to add transaction: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Transaction added to the pending pool",
+			"transaction": transaction,
+		})
+	}
+}
+
+// Handler for reporting a validator that signed two blocks at the same height
+func submitEvidenceHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req struct {
+			Reporter    string                  `json:"reporter"`
+			TokenSymbol string                  `json:"token_symbol"`
+			A           *blockchain.BlockHeader `json:"a"`
+			B           *blockchain.BlockHeader `json:"b"`
+		}
+		if err := c.BindJSON(&req); err != nil || req.A == nil || req.B == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Two conflicting headers are required"})
+			return
+		}
+		if req.TokenSymbol == "" {
+			req.TokenSymbol = "TPY"
+		}
+
+		transaction, err := blockchain.NewEvidenceTransaction(req.Reporter, req.TokenSymbol, req.A, req.B)
+		if err == nil {
+			err = chain.AddTransaction(transaction)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Failed to add evidence: %v", err)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message":     "Evidence added to the pending pool",
+			"transaction": transaction,
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"net/http"
+	"tpy-blockchain/internal/blockchain"
+	"tpy-blockchain/internal/consensus"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler for listing the chain's upgrades and those that apply to the next block
+func getUpgradesHandler(chain *blockchain.Blockchain) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config := chain.ChainConfig()
+		upgrades := []*consensus.Upgrade{}
+		if config != nil {
+			upgrades = config.Upgrades
+		}
+		next := chain.Height() + 1
+		c.JSON(http.StatusOK, gin.H{
+			"upgrades":     upgrades,
+			"nextHeight":   next,
+			"active":       config.ActiveUpgrades(next),
+			"blockVersion": config.BlockVersion(next),
+		})
+	}
+}
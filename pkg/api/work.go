@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"tpy-blockchain/internal/producer"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Handler for handing out a block template to an external miner, paying
+// block rewards to the coinbase query parameter
+func getWorkHandler(work *producer.WorkManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, err := work.GetWork(c.Query("coinbase"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	}
+}
+
+// Handler for accepting a solved block template from an external miner
+func submitWorkHandler(work *producer.WorkManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var solution producer.Solution
+		if err := c.BindJSON(&solution); err != nil || solution.ID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Job ID is required"})
+			return
+		}
+
+		block, err := work.SubmitWork(&solution)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"accepted": false, "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"accepted": true, "index": block.Index, "hash": block.Hash})
+	}
+}
@@ -0,0 +1,112 @@
+package crypto
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Leaves and inner nodes are hashed with different prefixes, so an inner
+// node cannot be passed off as a leaf. The root also commits to the number
+// of leaves, which fixes the path of every position.
+const (
+	merkleLeafPrefix  = "\x00"
+	merkleNodePrefix  = "\x01"
+	merkleCountPrefix = "\x02"
+)
+
+// MerkleProofStep is one sibling hash on the path from a leaf up to the root
+type MerkleProofStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left"` // True when the sibling sits to the left of the running hash
+}
+
+// MerkleRoot computes the root of a binary Merkle tree over hex-encoded leaf hashes.
+// An odd node at any level is promoted to the next level unchanged, so no two
+// leaf lists share a root.
+func MerkleRoot(leaves []string) string {
+	if len(leaves) == 0 {
+		return HashSHA256("")
+	}
+
+	level := merkleLeaves(leaves)
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+	return merkleTop(level[0], len(leaves))
+}
+
+// MerkleProof returns the sibling path proving that leaves[index] is part of MerkleRoot(leaves)
+func MerkleProof(leaves []string, index int) ([]MerkleProofStep, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("leaf index %d out of range", index)
+	}
+
+	proof := []MerkleProofStep{}
+	level := merkleLeaves(leaves)
+	for len(level) > 1 {
+		if index%2 == 1 {
+			proof = append(proof, MerkleProofStep{Hash: level[index-1], Left: true})
+		} else if index+1 < len(level) {
+			proof = append(proof, MerkleProofStep{Hash: level[index+1], Left: false})
+		}
+		level = nextMerkleLevel(level)
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof checks that leaf, at position index of count leaves,
+// hashes up to root along the given proof. The proof must have exactly the
+// steps and sides that the position gives.
+func VerifyMerkleProof(leaf string, index, count int, proof []MerkleProofStep, root string) bool {
+	if index < 0 || index >= count {
+		return false
+	}
+
+	hash := HashSHA256(merkleLeafPrefix + leaf)
+	steps := 0
+	for size := count; size > 1; size = (size + 1) / 2 {
+		if index%2 == 1 || index+1 < size {
+			if steps == len(proof) || proof[steps].Left != (index%2 == 1) {
+				return false
+			}
+			step := proof[steps]
+			if step.Left {
+				hash = HashSHA256(merkleNodePrefix + step.Hash + hash)
+			} else {
+				hash = HashSHA256(merkleNodePrefix + hash + step.Hash)
+			}
+			steps++
+		}
+		index /= 2
+	}
+	return steps == len(proof) && merkleTop(hash, count) == root
+}
+
+// merkleTop binds the top node of a tree to its number of leaves
+func merkleTop(node string, count int) string {
+	return HashSHA256(merkleCountPrefix + strconv.Itoa(count) + ":" + node)
+}
+
+// merkleLeaves hashes leaves into the bottom level of a tree
+func merkleLeaves(leaves []string) []string {
+	level := make([]string, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = HashSHA256(merkleLeafPrefix + leaf)
+	}
+	return level
+}
+
+// nextMerkleLevel hashes adjacent pairs of a tree level into its parent
+// level, promoting an odd last node
+func nextMerkleLevel(level []string) []string {
+	next := make([]string, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, HashSHA256(merkleNodePrefix+level[i]+level[i+1]))
+	}
+	return next
+}
@@ -8,9 +8,58 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	Difficulty   int    `json:"difficulty"`
-	BlockchainDB string `json:"blockchain_db"`
-	ServerPort   string `json:"server_port"`
+	Difficulty       int             `json:"difficulty"`
+	MiningThreads    int             `json:"mining_threads"` // Proof-of-work worker goroutines, all CPUs if 0
+	PowAlgorithm     string          `json:"pow_algorithm"`  // Proof-of-work hash, "sha256" (default) or "scrypt"; fixed at genesis
+	BlockchainDB     string          `json:"blockchain_db"`  // SQLite database file of the sqlite storage backend
+	Storage          string          `json:"storage"`        // Storage backend: "json" (default), "sqlite" or "memory"
+	ServerPort       string          `json:"server_port"`
+	AuditEachBlock   bool            `json:"audit_each_block"`       // Check token supply after every block
+	Consensus        string          `json:"consensus"`              // Consensus engine: "pow", "poa", "pos" or "bft"
+	BlockReward      int64           `json:"block_reward"`           // Reward per block in RewardToken, 0 for none
+	RewardToken      string          `json:"reward_token"`           // Token block rewards are paid in, "" for native
+	Signers          []string        `json:"signers"`                // Proof-of-authority signers at genesis
+	Epoch            int             `json:"epoch"`                  // Proof-of-authority blocks between vote resets
+	SignerMnemonic   string          `json:"signer_mnemonic"`        // Wallet mnemonic this node seals or votes on blocks with
+	FaucetMnemonic   string          `json:"faucet_mnemonic"`        // Wallet funding the wallets created in the CLI, none if empty
+	StakeToken       string          `json:"stake_token"`            // Token staked for proof-of-stake, "TPY" by default
+	Validators       []string        `json:"validators"`             // Proof-of-stake validators until the first stake is bonded, or the BFT validator set
+	RoundTimeoutMs   int             `json:"round_timeout_ms"`       // Proof-of-stake delay before each later proposer round, or the BFT propose timeout
+	ProduceBlocks    bool            `json:"produce_blocks"`         // Start the block producer with the node
+	Coinbase         string          `json:"coinbase"`               // Address receiving the rewards of produced blocks
+	AllowEmptyBlocks bool            `json:"allow_empty_blocks"`     // Produce blocks even without pending transactions
+	Upgrades         []UpgradeConfig `json:"upgrades"`               // Consensus rule changes with their activation heights
+	MaxBlockSize     int             `json:"max_block_size"`         // Serialized bytes of a block, 1 MiB if 0
+	MaxBlockTxs      int             `json:"max_block_transactions"` // Transactions in a block including rewards, 5000 if 0
+	MaxTxSize        int             `json:"max_tx_size"`            // Serialized bytes of a transaction, 16 KiB if 0
+	BootstrapSigners []string        `json:"bootstrap_signers"`      // Addresses whose signed bootstrap files are imported
+	ExportMnemonic   string          `json:"export_mnemonic"`        // Wallet mnemonic the export command signs bootstrap files with, none if empty
+}
+
+// UpgradeConfig schedules a named consensus upgrade. Every node of a network
+// must use the same upgrades.
+type UpgradeConfig struct {
+	Name    string `json:"name"`
+	Height  int    `json:"height"`  // First block the upgrade applies to
+	Version int    `json:"version"` // Minimum block version from Height on, 0 to keep it unchanged
+}
+
+// DefaultConfig returns the configuration used when no config file is present
+func DefaultConfig() *Config {
+	return &Config{
+		Difficulty: 0,
+		ServerPort: "8080",
+		Consensus:  "pow",
+	}
+}
+
+// LoadConfigIfExists loads configuration from a JSON file, or returns the
+// defaults if the file does not exist
+func LoadConfigIfExists(filePath string) (*Config, error) {
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return DefaultConfig(), nil
+	}
+	return LoadConfig(filePath)
 }
 
 // LoadConfig loads configuration from a JSON file
@@ -21,11 +70,11 @@ func LoadConfig(filePath string) (*Config, error) {
 	}
 	defer file.Close()
 
-	var config Config
-	err = json.NewDecoder(file).Decode(&config)
+	config := DefaultConfig()
+	err = json.NewDecoder(file).Decode(config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %v", err)
 	}
 
-	return &config, nil
+	return config, nil
 }